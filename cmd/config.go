@@ -0,0 +1,104 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ljvmiranda921/burnout-barometer/pkg"
+	"github.com/spf13/cobra"
+)
+
+// configFlags loads the configuration from the --config file, the BB_*
+// environment variables and a flag for each configuration key.
+type configFlags struct {
+	path  string
+	flags map[string]string // configuration key to flag name
+}
+
+// addConfigFlags registers the configuration flags of a command.
+func addConfigFlags(command *cobra.Command) *configFlags {
+	c := &configFlags{flags: map[string]string{}}
+	command.Flags().StringVarP(&c.path, "config", "c", "config.json", "path to configuration file")
+	for _, key := range pkg.ConfigurationKeys() {
+		flag := strings.ToLower(strings.Replace(key, "_", "-", -1))
+		command.Flags().String(flag, "", fmt.Sprintf("override %s of the configuration file (env: BB_%s)", key, key))
+		c.flags[key] = flag
+	}
+	return c
+}
+
+// load returns the configuration, with flags taking precedence over the
+// environment variables, and those over the configuration file.
+func (c *configFlags) load(cmd *cobra.Command) (*pkg.Configuration, error) {
+	overrides := map[string]string{}
+	for key, flag := range c.flags {
+		if cmd.Flags().Changed(flag) {
+			overrides[key], _ = cmd.Flags().GetString(flag)
+		}
+	}
+	return pkg.LoadConfiguration(c.file(cmd), os.Environ(), overrides)
+}
+
+// file returns the path of the configuration file, or an empty string if
+// the default configuration file does not exist. It is optional when running
+// from environment variables only.
+func (c *configFlags) file(cmd *cobra.Command) string {
+	if _, err := os.Stat(c.path); os.IsNotExist(err) && !cmd.Flags().Changed("config") {
+		return ""
+	}
+	return c.path
+}
+
+// ConfigCommand groups the commands for inspecting the configuration.
+func ConfigCommand() *cobra.Command {
+
+	var command = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+
+	var (
+		probe bool
+		cfg   *configFlags
+	)
+
+	var validate = &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration for mistakes",
+		Long: `
+This command checks the configuration that 'barometer serve' would use: the
+database URL and its scheme, the shape of BigQuery tables, timezones, reminder
+and report times, alert rules, and required secrets. With --probe, it also
+checks that the database can be reached.
+`,
+		Example: "barometer config validate --config=config.yaml --probe",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			initLogger(verbosity)
+
+			config, err := cfg.load(cmd)
+			if err != nil {
+				return err
+			}
+			if err := config.Validate(); err != nil {
+				return err
+			}
+			if probe {
+				if err := config.Probe(); err != nil {
+					return fmt.Errorf("cannot reach the database: %v", err)
+				}
+			}
+			fmt.Println("Configuration is valid")
+			return nil
+		},
+	}
+
+	cfg = addConfigFlags(validate)
+	validate.Flags().BoolVar(&probe, "probe", false, "check that the database can be reached")
+	command.AddCommand(validate)
+	return command
+}
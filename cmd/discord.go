@@ -0,0 +1,60 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ljvmiranda921/burnout-barometer/pkg"
+	"github.com/spf13/cobra"
+)
+
+// DiscordCommand groups the commands for setting up the Discord application.
+func DiscordCommand() *cobra.Command {
+
+	var command = &cobra.Command{
+		Use:   "discord",
+		Short: "Set up the Discord application",
+	}
+
+	var (
+		guildID string
+		cfg     *configFlags
+	)
+
+	var register = &cobra.Command{
+		Use:   "register",
+		Short: "Register the /barometer command with Discord",
+		Long: `
+This command creates or updates the /barometer application command, using the
+DISCORD_APPLICATION_ID and DISCORD_BOT_TOKEN of the configuration. Commands are
+registered globally, which may take up to an hour to show up, or only in the
+server given by --guild, which is immediate.
+`,
+		Example: "barometer discord register --config=config.yaml --guild=123456789",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			initLogger(verbosity)
+
+			config, err := cfg.load(cmd)
+			if err != nil {
+				return err
+			}
+			if config.DiscordApplicationID == "" || config.DiscordBotToken == "" {
+				return fmt.Errorf("DISCORD_APPLICATION_ID and DISCORD_BOT_TOKEN are required for registering the command")
+			}
+			client := pkg.NewDiscordClient(config.DiscordApplicationID, config.DiscordBotToken)
+			if err := client.RegisterCommand(guildID); err != nil {
+				return err
+			}
+			fmt.Println("Registered the /barometer command")
+			return nil
+		},
+	}
+
+	cfg = addConfigFlags(register)
+	register.Flags().StringVar(&guildID, "guild", "", "register the command only in this server (guild) ID")
+	command.AddCommand(register)
+	return command
+}
@@ -5,11 +5,10 @@
 package cmd
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/ljvmiranda921/burnout-barometer/pkg"
@@ -17,12 +16,28 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// initGroups are the optional integrations that init asks about, keyed by the
+// name given to --with.
+var initGroups = map[string]string{
+	"slack":      "Slack",
+	"teams":      "Microsoft Teams",
+	"discord":    "Discord",
+	"mattermost": "Mattermost",
+	"rocketchat": "Rocket.Chat",
+	"twitter":    "replies from the tiny-care bots on Twitter",
+}
+
 // InitCommand creates a configuration file from a prompt or through environment variables
 func InitCommand() *cobra.Command {
 
 	var (
 		useEnvVars bool
 		outputPath string
+		fromFile   string
+		sets       []string
+		update     bool
+		noPrompt   bool
+		with       []string
 	)
 
 	var command = &cobra.Command{
@@ -30,40 +45,124 @@ func InitCommand() *cobra.Command {
 		Short: "Initialize a configuration file",
 		Long: `
 This command creates a configuration file, config.json, that will be used later
-on when running the server. The values can be obtained from the prompt or
-through environment variables (prefixed with 'BB_*').
+on when running the server. Use a .yaml or .toml output path for other formats.
+
+Values are taken, from lowest to highest precedence, from the existing output
+file (with --update), another configuration file (--from-file), environment
+variables prefixed with 'BB_*' (with --use-env-vars), and --set KEY=VALUE
+flags. You're prompted only for the values that are still missing, unless
+--no-prompt is set.
+
+The chat platforms and Twitter replies are optional: you're asked whether to
+set up each of them, unless it's listed in --with or some of its values are
+already given. With --no-prompt, only those are set up.
 
-If you're planning to use environment variables, then use the --use-env-vars
-flag. Find all available options in this link: 
+Secrets are base64-encoded unless they're references such as env:SLACK_TOKEN
+or file:///run/secrets/slack_token.
+
+Find all available options in this link:
 https://ljvmiranda921.github.io/burnout-barometer/installation/
 `,
-		Example: "barometer init",
+		Example: "barometer init --use-env-vars --set AREA=Europe/Berlin",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			initLogger(verbosity)
 
-			if useEnvVars {
-				cfg, err := getConfig(fromEnvVar)
+			cfg := &pkg.Configuration{}
+			if update {
+				existing, err := pkg.ReadRawConfiguration(outputPath)
 				if err != nil {
 					return err
 				}
-
-				if err := cfg.WriteConfiguration(outputPath); err != nil {
+				cfg = existing
+			}
+			if fromFile != "" {
+				other, err := pkg.ReadRawConfiguration(fromFile)
+				if err != nil {
 					return err
 				}
-				fmt.Printf("Configuration file generated in %s!", outputPath)
+				cfg.Merge(other)
+			}
+
+			values := map[string]string{}
+			if useEnvVars {
+				values = pkg.EnvOverrides(os.Environ())
+			}
+			for _, kv := range sets {
+				parts := strings.SplitN(kv, "=", 2)
+				if len(parts) != 2 {
+					return fmt.Errorf("--set must be in the form KEY=VALUE, got %q", kv)
+				}
+				values[strings.ToUpper(parts[0])] = parts[1]
+			}
+			for _, o := range pkg.ConfigurationOptions() {
+				if v, ok := values[o.Key]; ok && o.Secret {
+					values[o.Key] = pkg.EncodeSecret(v)
+				}
+			}
+			if err := cfg.Override(values); err != nil {
+				return err
+			}
+
+			enabled := map[string]bool{}
+			for _, g := range with {
+				g = strings.ToLower(strings.TrimSpace(g))
+				if _, ok := initGroups[g]; !ok {
+					return fmt.Errorf("unknown integration %q for --with, use one of %s", g, groupNames())
+				}
+				enabled[g] = true
+			}
+			opts := pkg.ConfigurationOptions()
+			for _, o := range opts {
+				if o.Group != "" && cfg.Value(o.Key) != "" {
+					enabled[o.Group] = true
+				}
+			}
+
+			// Ask only for the values that are still missing, starting with
+			// the ones that every configuration needs
+			sort.SliceStable(opts, func(i, j int) bool { return opts[i].Group == "" && opts[j].Group != "" })
+			asked := map[string]bool{}
+			for _, o := range opts {
+				if cfg.Value(o.Key) != "" {
+					continue
+				}
+				if o.Group != "" && !enabled[o.Group] {
+					if noPrompt || asked[o.Group] {
+						continue
+					}
+					asked[o.Group] = true
+					ok, err := confirm(fmt.Sprintf("Set up %s", initGroups[o.Group]))
+					if err != nil {
+						return err
+					}
+					if !ok {
+						continue
+					}
+					enabled[o.Group] = true
+				}
+				if noPrompt {
+					if !o.Optional {
+						return fmt.Errorf("missing value for %s, set it with --set %s=VALUE or BB_%s", o.Key, o.Key, o.Key)
+					}
+					continue
+				}
 
-			} else {
-				cfg, err := getConfig(fromPrompt)
+				value, err := fromPrompt(o)
 				if err != nil {
 					return err
 				}
-
-				if err := cfg.WriteConfiguration(outputPath); err != nil {
+				if o.Secret {
+					value = pkg.EncodeSecret(value)
+				}
+				if err := cfg.Override(map[string]string{o.Key: value}); err != nil {
 					return err
 				}
-				fmt.Printf("Configuration file generated in %s!", outputPath)
 			}
 
+			if err := cfg.WriteConfiguration(outputPath); err != nil {
+				return err
+			}
+			fmt.Printf("Configuration file generated in %s!\n", outputPath)
 			return nil
 		},
 	}
@@ -71,148 +170,57 @@ https://ljvmiranda921.github.io/burnout-barometer/installation/
 	// Add flags
 	command.Flags().BoolVar(&useEnvVars, "use-env-vars", false, "use environment variables")
 	command.Flags().StringVarP(&outputPath, "output-path", "o", "config.json", "output path for writing configuration file")
+	command.Flags().StringVar(&fromFile, "from-file", "", "merge the values of another configuration file")
+	command.Flags().StringArrayVar(&sets, "set", nil, "set a configuration value, e.g. --set AREA=Asia/Manila")
+	command.Flags().BoolVar(&update, "update", false, "edit the existing configuration file at the output path")
+	command.Flags().BoolVar(&noPrompt, "no-prompt", false, "fail instead of prompting for missing values")
+	command.Flags().StringSliceVar(&with, "with", nil, fmt.Sprintf("set up these integrations without asking (%s)", groupNames()))
 	return command
 }
 
-// Specify all configuration options here. As of now, we can only maintain this
-// by manually checking the struct fields within pkg.Configuration. It may be
-// easier to decouple them instead.
-type opt struct {
-	name     string
-	toEncode bool
-
-	// environment-var specific options
-	envVarName string
-
-	// promptui specific options
-	prompt     string
-	defaultVal string
-	mask       bool
-}
-
-var opts = []opt{
-	opt{
-		name:       "TABLE",
-		toEncode:   false,
-		envVarName: "BB_TABLE",
-		prompt:     "Database URL to store all logs",
-		defaultVal: "bq://my-gcp-project.my-dataset.my-table",
-		mask:       false,
-	},
-	opt{
-		name:       "SLACK_TOKEN",
-		toEncode:   true,
-		envVarName: "BB_SLACK_TOKEN",
-		prompt:     "Slack verification token",
-		defaultVal: "",
-		mask:       true,
-	},
-	opt{
-		name:       "AREA",
-		toEncode:   false,
-		envVarName: "BB_AREA",
-		prompt:     "Where are you? (refer to IANA timezone database)",
-		defaultVal: "Asia/Manila",
-		mask:       false,
-	},
-	opt{
-		name:       "TWITTER_CONSUMER_KEY",
-		toEncode:   true,
-		envVarName: "BB_TWITTER_CONSUMER_KEY",
-		prompt:     "Twitter API Consumer Key",
-		defaultVal: "",
-		mask:       true,
-	},
-	opt{
-		name:       "TWITTER_CONSUMER_SECRET",
-		toEncode:   true,
-		envVarName: "BB_TWITTER_CONSUMER_SECRET",
-		prompt:     "Twitter API Consumer Secret",
-		defaultVal: "",
-		mask:       true,
-	},
-	opt{
-		name:       "TWITTER_ACCESS_KEY",
-		toEncode:   true,
-		envVarName: "BB_TWITTER_ACCESS_KEY",
-		prompt:     "Twitter API Access Key",
-		defaultVal: "",
-		mask:       true,
-	},
-	opt{
-		name:       "TWITTER_ACCESS_SECRET",
-		toEncode:   true,
-		envVarName: "BB_TWITTER_ACCESS_SECRET",
-		prompt:     "Twitter API Access Secret",
-		defaultVal: "",
-		mask:       true,
-	},
-}
-
-func getConfig(fn func(options opt) (string, error)) (*pkg.Configuration, error) {
-	m := make(map[string]interface{})
-	for i := 0; i < len(opts); i++ {
-		val, err := fn(opts[i])
-		if err != nil {
-			return nil, err
-		}
-		m[opts[i].name] = val
+// groupNames lists the integrations that --with accepts.
+func groupNames() string {
+	names := []string{}
+	for name := range initGroups {
+		names = append(names, name)
 	}
-
-	jsonString, _ := json.Marshal(m) // convert map to json
-	config := &pkg.Configuration{}
-	json.Unmarshal(jsonString, config) // convert json to struct
-	return config, nil
+	sort.Strings(names)
+	return strings.Join(names, ", ")
 }
 
-func fromEnvVar(options opt) (string, error) {
-	if value, exists := os.LookupEnv(options.envVarName); exists {
-		if options.toEncode {
-			valueEnc := base64.StdEncoding.EncodeToString([]byte(value))
-			return valueEnc, nil
+// confirm asks a yes/no question, defaulting to no.
+func confirm(label string) (bool, error) {
+	prompt := promptui.Prompt{Label: label, IsConfirm: true}
+	if _, err := prompt.Run(); err != nil {
+		if err == promptui.ErrAbort {
+			return false, nil
 		}
-		return value, nil
+		return false, err
 	}
-
-	err := fmt.Errorf("Cannot find environment variable: %s", options.envVarName)
-	return "", err
+	return true, nil
 }
 
-func fromPrompt(options opt) (string, error) {
-
-	var prompt promptui.Prompt
-
-	validate := func(input string) error {
-		if len(strings.TrimSpace(input)) < 1 {
-			return errors.New("Input must not be empty")
-		}
-		return nil
+func fromPrompt(o pkg.ConfigOption) (string, error) {
+	prompt := promptui.Prompt{
+		Label:   o.Prompt,
+		Default: o.Default,
+		Validate: func(input string) error {
+			if len(strings.TrimSpace(input)) < 1 && !o.Optional {
+				return errors.New("Input must not be empty")
+			}
+			return nil
+		},
 	}
-
-	if options.mask {
-		prompt = promptui.Prompt{
-			Label:    options.prompt,
-			Default:  options.defaultVal,
-			Validate: validate,
-			Mask:     '*',
-		}
-	} else {
-		prompt = promptui.Prompt{
-			Label:    options.prompt,
-			Default:  options.defaultVal,
-			Validate: validate,
-		}
+	if o.Secret {
+		prompt.Mask = '*'
+	}
+	if prompt.Label == "" {
+		prompt.Label = o.Key
 	}
 
 	value, err := prompt.Run()
 	if err != nil {
-		return "", nil
+		return "", err
 	}
-
-	if options.toEncode {
-		valueEnc := base64.StdEncoding.EncodeToString([]byte(value))
-		return valueEnc, nil
-	}
-
 	return value, nil
 }
@@ -0,0 +1,172 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"4d63.com/tz"
+	"github.com/ljvmiranda921/burnout-barometer/pkg"
+	"github.com/spf13/cobra"
+)
+
+// LogCommand logs a mood from the terminal.
+func LogCommand() *cobra.Command {
+
+	var (
+		userID    string
+		teamID    string
+		serverURL string
+		token     string
+		cfg       *configFlags
+	)
+
+	var command = &cobra.Command{
+		Use:   "log MOOD [NOTES]",
+		Short: "Log your mood from the terminal",
+		Long: `
+This command logs your mood-level, from 1 to 5, along with optional notes.
+
+With --server, the log is sent to the API of a running server, authenticated
+with the API token from '/barometer token' (read from BAROMETER_TOKEN if
+--token is not given). Your settings and rate limits on the server apply.
+
+Otherwise, the log is written directly into the configured database as the
+user given by --user, e.g. your Slack user ID. It is stamped in the timezone of
+your settings, or in the configured AREA. If the app is installed into several
+workspaces, the log is stored under the workspace given by --team, e.g.
+T0123456789, or else under the one of your settings.
+`,
+		Example: `barometer log 3 "long review day" --server=https://barometer.example.com`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			initLogger(verbosity)
+
+			text := strings.Join(args, " ")
+			if strings.TrimSpace(text) == "" {
+				return fmt.Errorf("the mood-level is missing, e.g. barometer log 3 \"long review day\"")
+			}
+			var item *pkg.LogItem
+			var err error
+			if serverURL != "" {
+				item, err = logThroughServer(serverURL, token, text)
+			} else {
+				item, err = logDirectly(cmd, cfg, userID, teamID, text)
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Logged your mood: %s at %s\n", describe(item), item.Timestamp.Format("2006-01-02 15:04 MST"))
+			return nil
+		},
+	}
+
+	cfg = addConfigFlags(command)
+	command.Flags().StringVarP(&userID, "user", "u", "", "user ID to log as when writing to the database, e.g. your Slack user ID")
+	command.Flags().StringVar(&teamID, "team", "", "Slack team ID to log under when the app is installed into several workspaces")
+	command.Flags().StringVar(&serverURL, "server", "", "URL of a running server to log through its API")
+	command.Flags().StringVar(&token, "token", "", "API token for --server (env: BAROMETER_TOKEN)")
+	return command
+}
+
+// logThroughServer sends a log to the API of a running server.
+func logThroughServer(serverURL, token, text string) (*pkg.LogItem, error) {
+	if token == "" {
+		token = os.Getenv("BAROMETER_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("--token or BAROMETER_TOKEN is required for logging through the server")
+	}
+	measure, notes, err := pkg.ParseMessage(text)
+	if err != nil {
+		return nil, err
+	}
+	return pkg.NewAPIClient(serverURL, token).CreateLog(pkg.LogItem{Measure: *measure, Notes: *notes})
+}
+
+// logDirectly writes a log into the configured database.
+func logDirectly(cmd *cobra.Command, cfg *configFlags, userID, teamID, text string) (*pkg.LogItem, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("--user is required for writing to the database, or use --server")
+	}
+	config, err := cfg.load(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := pkg.NewDBInserter(config.Table)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := db.(io.Closer); ok {
+		defer c.Close()
+	}
+	prefs := &pkg.Preferences{UserID: userID}
+	if store, ok := db.(pkg.PreferenceStore); ok {
+		if p, err := store.GetPreferences(userID); err == nil {
+			prefs = p
+		}
+	}
+	from, err := logContext(config, db, userID, teamID, prefs)
+	if err != nil {
+		return nil, err
+	}
+	area := config.Area
+	if prefs.Timezone != "" {
+		area = prefs.Timezone
+	}
+	loc, err := tz.LoadLocation(area)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find location: %s", area)
+	}
+
+	return pkg.StoreLog(context.Background(), from, text, time.Now().In(loc), db, prefs, nil, false)
+}
+
+// logContext returns who a log written directly into the database is from.
+// If the app is installed into several workspaces, the log belongs to the
+// given team, or else to the team of the user's preferences, which must be
+// installed.
+func logContext(config *pkg.Configuration, db pkg.DBInserter, userID, teamID string, prefs *pkg.Preferences) (pkg.LogContext, error) {
+	from := pkg.LogContext{UserID: userID}
+	workspaces, err := pkg.NewWorkspaces(config, db)
+	if err != nil {
+		return from, err
+	}
+	if workspaces == nil {
+		if teamID != "" {
+			return from, fmt.Errorf("--team is only used when the app is installed into several workspaces, i.e. SLACK_CLIENT_ID is set")
+		}
+		return from, nil
+	}
+
+	if teamID == "" {
+		teamID = prefs.TeamID
+	}
+	if teamID == "" {
+		return from, fmt.Errorf("--team is required since the app is installed into several workspaces")
+	}
+	if _, err := workspaces.Installation(teamID); err != nil {
+		return from, fmt.Errorf("cannot log under team %s: %v", teamID, err)
+	}
+	from.TeamID = teamID
+	return from, nil
+}
+
+// describe formats a log as in the replies of the server, e.g. "3 (long
+// review day)".
+func describe(item *pkg.LogItem) string {
+	if item.Notes == "" {
+		return strconv.Itoa(item.Measure)
+	}
+	return fmt.Sprintf("%d (%s)", item.Measure, item.Notes)
+}
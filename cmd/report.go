@@ -0,0 +1,108 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ljvmiranda921/burnout-barometer/pkg"
+	"github.com/spf13/cobra"
+)
+
+// ReportCommand generates the digests of the latest logs.
+func ReportCommand() *cobra.Command {
+
+	var (
+		cfg       *configFlags
+		days      int
+		format    string
+		outputDir string
+		send      bool
+	)
+
+	var command = &cobra.Command{
+		Use:   "report",
+		Short: "Generate digests of the latest logs",
+		Long: `
+This command generates a private digest for each user and an anonymised team
+digest of the logs within the last few days (a week by default). The team
+digest is only generated if there are at least three participants, and users
+who opted out of team reports are left out.
+
+The digests are written as Markdown or HTML files into the output directory.
+If --send is set, they are delivered through Slack instead: each user receives
+their own digest by direct message, and the team digest is posted to the
+configured REPORT_CHANNEL. If the app is installed into several workspaces,
+each gets the digests of its own team through its bot, and no team digest is
+posted.
+`,
+		Example: "barometer report --format=html --output-dir=reports",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			initLogger(verbosity)
+
+			config, err := cfg.load(cmd)
+			if err != nil {
+				return err
+			}
+
+			db, err := pkg.NewDBInserter(config.Table)
+			if err != nil {
+				return err
+			}
+			if c, ok := db.(io.Closer); ok {
+				defer c.Close()
+			}
+			dq, ok := db.(pkg.DBQuerier)
+			if !ok {
+				return fmt.Errorf("the configured database cannot be queried for reports")
+			}
+			prefs, _ := db.(pkg.PreferenceStore)
+
+			since, until, err := pkg.LastDays(time.Now(), config.Area, days)
+			if err != nil {
+				return err
+			}
+
+			if send {
+				workspaces, err := pkg.NewWorkspaces(config, db)
+				if err != nil {
+					return err
+				}
+				if workspaces == nil && config.BotToken == "" {
+					return fmt.Errorf("SLACK_BOT_TOKEN is required for sending reports")
+				}
+				n, err := pkg.SendReports(dq, prefs, workspaces, pkg.NewSlackClient(config.BotToken), config.ReportChannel, since, until)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Sent %d digests through Slack\n", n)
+				return nil
+			}
+
+			report, err := pkg.BuildReport(dq, prefs, since, until)
+			if err != nil {
+				return err
+			}
+			paths, err := report.WriteFiles(outputDir, format)
+			if err != nil {
+				return err
+			}
+			for _, p := range paths {
+				fmt.Printf("Report written in %s\n", p)
+			}
+			return nil
+		},
+	}
+
+	// Add flags
+	command.Flags().IntVar(&days, "days", 7, "number of days before today to include")
+	command.Flags().StringVarP(&format, "format", "f", pkg.FormatMarkdown, "format of the report files (markdown or html)")
+	command.Flags().StringVarP(&outputDir, "output-dir", "o", "reports", "directory for writing the report files")
+	command.Flags().BoolVar(&send, "send", false, "send the digests through Slack instead of writing files")
+	cfg = addConfigFlags(command)
+	return command
+}
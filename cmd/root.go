@@ -6,11 +6,16 @@
 package cmd
 
 import (
+	"github.com/ljvmiranda921/burnout-barometer/pkg"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
-var verbosity int
+var (
+	verbosity    int
+	logFormat    string
+	logSensitive bool
+)
 
 // NewCommand returns a new instance of an optserve command.
 func NewCommand() *cobra.Command {
@@ -27,15 +32,26 @@ func NewCommand() *cobra.Command {
 
 	// Define persistent flags
 	command.PersistentFlags().CountVarP(&verbosity, "verbosity", "v", "set verbosity")
+	command.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format, either text or json")
+	command.PersistentFlags().BoolVar(&logSensitive, "log-sensitive", false, "don't redact tokens, notes and user IDs from logs")
 
 	// Add subcommands
 	command.AddCommand(InitCommand())
 	command.AddCommand(ServeCommand())
+	command.AddCommand(ReportCommand())
+	command.AddCommand(SecretsCommand())
+	command.AddCommand(ConfigCommand())
+	command.AddCommand(DiscordCommand())
+	command.AddCommand(LogCommand())
 
 	return command
 }
 
 func initLogger(verbosity int) {
+	if err := pkg.ConfigureLogging(logFormat, !logSensitive); err != nil {
+		log.WithFields(log.Fields{"err": err}).Warn("ConfigureLogging")
+	}
+
 	switch {
 	case verbosity == 1:
 		log.SetLevel(log.DebugLevel)
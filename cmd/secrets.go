@@ -0,0 +1,103 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ljvmiranda921/burnout-barometer/pkg"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+// SecretsCommand manages the encrypted secrets file.
+func SecretsCommand() *cobra.Command {
+
+	var secretsPath string
+
+	var command = &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage the encrypted secrets file",
+		Long: `
+This command manages a secrets file encrypted with AES-256-GCM. Its key is read
+from the BB_SECRETS_KEY environment variable, which can be generated with the
+keygen subcommand. Refer to a secret in the configuration as "secret:NAME" and
+set SECRETS_FILE to the path of the secrets file.
+`,
+		Example: "barometer secrets set slack_token",
+	}
+
+	var keygen = &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a key for the secrets file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			initLogger(verbosity)
+			key, err := pkg.NewSecretsKey()
+			if err != nil {
+				return err
+			}
+			fmt.Println(key)
+			return nil
+		},
+	}
+
+	var set = &cobra.Command{
+		Use:   "set NAME",
+		Short: "Add or replace a secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			initLogger(verbosity)
+			f, err := pkg.OpenSecretsFile(secretsPath, os.Getenv(pkg.SecretsKeyEnvVar))
+			if err != nil {
+				return err
+			}
+
+			prompt := promptui.Prompt{
+				Label: fmt.Sprintf("Value of %s", args[0]),
+				Mask:  '*',
+				Validate: func(input string) error {
+					if input == "" {
+						return errors.New("Input must not be empty")
+					}
+					return nil
+				},
+			}
+			value, err := prompt.Run()
+			if err != nil {
+				return err
+			}
+
+			f.Set(args[0], value)
+			if err := f.Save(); err != nil {
+				return err
+			}
+			fmt.Printf("Secret %s saved in %s, refer to it as secret:%s\n", args[0], secretsPath, args[0])
+			return nil
+		},
+	}
+
+	var list = &cobra.Command{
+		Use:   "list",
+		Short: "List the names of all secrets",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			initLogger(verbosity)
+			f, err := pkg.OpenSecretsFile(secretsPath, os.Getenv(pkg.SecretsKeyEnvVar))
+			if err != nil {
+				return err
+			}
+			for _, name := range f.Names() {
+				fmt.Println(name)
+			}
+			return nil
+		},
+	}
+
+	// Add flags and subcommands
+	command.PersistentFlags().StringVarP(&secretsPath, "file", "f", "secrets.enc", "path to the encrypted secrets file")
+	command.AddCommand(keygen, set, list)
+	return command
+}
@@ -16,19 +16,34 @@ import (
 func ServeCommand() *cobra.Command {
 
 	var (
-		port    int
-		cfgPath string
-		debug   bool
+		port  int
+		debug bool
+		cfg   *configFlags
 	)
 
 	var command = &cobra.Command{
-		Use:     "serve",
-		Short:   "Start the server",
+		Use:   "serve",
+		Short: "Start the server",
+		Long: `
+This command starts the server that handles requests coming from Slack. The
+configuration is taken, from lowest to highest precedence, from:
+
+  1. the configuration file (skipped if the default config.json does not exist)
+  2. environment variables prefixed with 'BB_', e.g. BB_TABLE
+  3. command-line flags, e.g. --table
+
+Secrets given through environment variables or flags are used as-is (not
+base64-encoded) and are never written to disk.
+
+The configuration is reloaded whenever the configuration file changes or the
+server receives SIGHUP. If the new configuration is invalid, the server keeps
+running with the current one.
+`,
 		Example: "barometer serve --port=8080",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			initLogger(verbosity)
 
-			config, err := pkg.ReadConfiguration(cfgPath)
+			config, err := cfg.load(cmd)
 			if err != nil {
 				fmt.Printf("error reading configuration: %s", err)
 				return err
@@ -39,21 +54,23 @@ func ServeCommand() *cobra.Command {
 			}
 
 			server := pkg.Server{
-				Port:   port,
-				Router: httprouter.New(),
-				Config: config,
-				Debug:  debug,
+				Port:       port,
+				Router:     httprouter.New(),
+				Config:     config,
+				ConfigPath: cfg.file(cmd),
+				Loader:     func() (*pkg.Configuration, error) { return cfg.load(cmd) },
+				Debug:      debug,
+				Version:    cmd.Root().Version,
 			}
 
 			server.Routes()
-			server.Start()
-			return nil
+			return server.Start()
 		},
 	}
 
 	// Add flags
 	command.Flags().IntVarP(&port, "port", "p", 8080, "port to run the server on")
-	command.Flags().StringVarP(&cfgPath, "config", "c", "config.json", "path to configuration file")
 	command.Flags().BoolVar(&debug, "debug-mode", false, "enable debug-mode, don't write to table")
+	cfg = addConfigFlags(command)
 	return command
 }
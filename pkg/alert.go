@@ -0,0 +1,381 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of alert rules
+const (
+	// RuleConsecutiveLow triggers when the last Count or more logs are all
+	// less than or equal to the Threshold, once for each streak.
+	RuleConsecutiveLow = "consecutive_low"
+	// RuleAverageDrop triggers when the average of the last Count days
+	// dropped by more than the Threshold compared to the Count days before.
+	RuleAverageDrop = "average_drop"
+	// RuleInactivity triggers when there are no logs for Count or more
+	// working days, once for each stretch of inactivity.
+	RuleInactivity = "inactivity"
+)
+
+// AlertRule defines a condition on a user's logs that triggers a private
+// message to the user, and to their buddy if they opted in for one. The
+// messages can refer to the user and their buddy with {user} and {buddy}.
+type AlertRule struct {
+	Name         string  `json:"NAME" yaml:"name" toml:"name"`
+	Kind         string  `json:"KIND" yaml:"kind" toml:"kind"`
+	Threshold    float64 `json:"THRESHOLD" yaml:"threshold" toml:"threshold"`
+	Count        int     `json:"COUNT" yaml:"count" toml:"count"`
+	Message      string  `json:"MESSAGE" yaml:"message" toml:"message"`
+	BuddyMessage string  `json:"BUDDY_MESSAGE" yaml:"buddy_message,omitempty" toml:"buddy_message,omitempty"`
+}
+
+// DefaultAlertRules are used if no rules are configured.
+var DefaultAlertRules = []AlertRule{
+	{
+		Name:         "low streak",
+		Kind:         RuleConsecutiveLow,
+		Threshold:    2,
+		Count:        3,
+		Message:      "Hi {user}, your last few logs have been rough. Take a breather, and consider reaching out to someone you trust.",
+		BuddyMessage: "Hi {buddy}, {user} asked me to let you know when they're having a rough time. Maybe check in on them?",
+	},
+	{
+		Name:         "dropping average",
+		Kind:         RuleAverageDrop,
+		Threshold:    1.0,
+		Count:        7,
+		Message:      "Hi {user}, your mood this week is noticeably lower than the week before. Is there anything you can take off your plate?",
+		BuddyMessage: "Hi {buddy}, {user} seems to be having a harder week than usual. Maybe check in on them?",
+	},
+	{
+		Name:    "inactive",
+		Kind:    RuleInactivity,
+		Count:   10,
+		Message: "Hi {user}, I haven't heard from you in a while. How are you feeling?",
+	},
+}
+
+// Alert is the last alert of a rule sent to a user. It's stored so that an
+// alert is sent only once for each period, e.g. for each streak of low logs,
+// even if the server was restarted in between.
+type Alert struct {
+	tableName struct{} `sql:"alerts"`
+
+	UserID string    `sql:",pk" bigquery:"user_id"`
+	Rule   string    `sql:",pk" bigquery:"rule"`
+	Period time.Time `bigquery:"period"` // Start of what the alert is about, e.g. the first log of a streak
+	SentAt time.Time `bigquery:"sent_at"`
+}
+
+// ErrNoAlert is returned by an AlertStore for users who never got an alert of
+// a rule.
+var ErrNoAlert = errors.New("no alert was sent")
+
+// AlertStore is an interface for storing the last alert of each rule sent to
+// each user. LastAlert returns ErrNoAlert if there is none.
+type AlertStore interface {
+	LastAlert(userID, rule string) (*Alert, error)
+	SaveAlert(a Alert) error
+}
+
+// AlertEngine evaluates the alert rules on the logs of each user.
+type AlertEngine struct {
+	Rules       []AlertRule
+	DB          DBQuerier
+	Preferences PreferenceStore // Optional store to look up buddies
+	Client      *SlackClient
+	Workspaces  *Workspaces // Optional, to alert through the bot of each workspace
+	Store       AlertStore  // Optional, to remember the alerts that were sent across restarts
+
+	// Queue runs the evaluation in the background. If nil, the rules are
+	// evaluated right away.
+	Queue *Queue
+
+	mu    sync.Mutex
+	fired map[string]Alert // user ID and rule name to the last alert
+}
+
+// Observe evaluates the rules after a log has been inserted. It is safe to
+// call Observe on a nil AlertEngine.
+func (e *AlertEngine) Observe(item LogItem) {
+	if e == nil {
+		return
+	}
+	job := func() { e.Evaluate(item.TeamID, item.UserID, item.Timestamp) }
+	if e.Queue == nil {
+		job()
+		return
+	}
+	if err := e.Queue.Push(job); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Queue.Push")
+	}
+}
+
+// Evaluate checks the log-based rules for a user at the given time. Only the
+// logs of the user's team are considered, or all logs if teamID is empty.
+func (e *AlertEngine) Evaluate(teamID, userID string, now time.Time) {
+	lookback := 30
+	for _, r := range e.Rules {
+		if r.Kind == RuleAverageDrop && 2*r.Count > lookback {
+			lookback = 2 * r.Count
+		}
+	}
+
+	items, err := e.DB.QueryDB(Query{
+		TeamID: teamID,
+		UserID: userID,
+		Since:  now.AddDate(0, 0, -lookback),
+		Until:  now.Add(time.Second),
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("DBQuerier.QueryDB")
+		return
+	}
+
+	for _, r := range e.Rules {
+		var triggered bool
+		var period time.Time
+		switch r.Kind {
+		case RuleConsecutiveLow:
+			// Alert once for each streak, even if it was missed when the
+			// streak reached the count
+			period, triggered = consecutiveLow(items, r)
+			triggered = triggered && !e.alerted(userID, r, period)
+		case RuleAverageDrop:
+			// Don't remind the user more than once within the window
+			period = now.AddDate(0, 0, -r.Count)
+			triggered = averageDrop(items, now, r) && !e.alertedSince(userID, r, period)
+		default:
+			continue
+		}
+		if triggered {
+			e.alert(teamID, userID, r, period, now)
+		}
+	}
+}
+
+// CheckInactivity evaluates the inactivity rules for everyone who logged
+// recently. It is meant to be run once a day by the Scheduler. When installed
+// into several workspaces, each workspace is checked on its own logs.
+func (e *AlertEngine) CheckInactivity(now time.Time) {
+	if e.Workspaces == nil {
+		e.checkInactivity(e.DB, "", now)
+		return
+	}
+	installs, err := e.Workspaces.List()
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Workspaces.List")
+		return
+	}
+	for _, i := range installs {
+		e.checkInactivity(teamQuerier{e.DB, i.TeamID}, i.TeamID, now)
+	}
+}
+
+func (e *AlertEngine) checkInactivity(dq DBQuerier, teamID string, now time.Time) {
+	for _, r := range e.Rules {
+		if r.Kind != RuleInactivity {
+			continue
+		}
+
+		// Weekends are skipped, so twice the working days is enough
+		items, err := dq.QueryDB(Query{Since: now.AddDate(0, 0, -2*r.Count-7), Until: now})
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("DBQuerier.QueryDB")
+			return
+		}
+
+		last := make(map[string]time.Time)
+		for _, item := range items {
+			last[item.UserID] = item.Timestamp
+		}
+		for userID, t := range last {
+			// Only alert once for each stretch of inactivity, which starts
+			// with the last log
+			if workingDaysBetween(t, now) < r.Count || e.alerted(userID, r, t) {
+				continue
+			}
+			e.alert(teamID, userID, r, t, now)
+		}
+	}
+}
+
+// alert sends the messages of a rule about the given period, and remembers
+// that they were sent.
+func (e *AlertEngine) alert(teamID, userID string, r AlertRule, period, now time.Time) {
+	if platformUser(userID) {
+		return
+	}
+
+	a := Alert{UserID: userID, Rule: r.Name, Period: period, SentAt: now}
+	e.mu.Lock()
+	if e.fired == nil {
+		e.fired = make(map[string]Alert)
+	}
+	e.fired[userID+"/"+r.Name] = a
+	e.mu.Unlock()
+	if e.Store != nil {
+		if err := e.Store.SaveAlert(a); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("AlertStore.SaveAlert")
+		}
+	}
+
+	log.WithFields(log.Fields{"rule": r.Name, "user": userID}).Info("alert triggered")
+
+	buddy := ""
+	if e.Preferences != nil {
+		prefs, err := e.Preferences.GetPreferences(userID)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("PreferenceStore.GetPreferences")
+		} else {
+			buddy = prefs.Buddy
+		}
+	}
+
+	client := e.Workspaces.Client(teamID, e.Client)
+	if client == nil {
+		return
+	}
+	replacer := strings.NewReplacer("{user}", fmt.Sprintf("<@%s>", userID), "{buddy}", fmt.Sprintf("<@%s>", buddy))
+	if r.Message != "" {
+		msg := &Message{Text: replacer.Replace(r.Message)}
+		if err := client.SendDirectMessage(userID, msg); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("SlackClient.SendDirectMessage")
+		}
+	}
+	if r.BuddyMessage != "" && buddy != "" {
+		msg := &Message{Text: replacer.Replace(r.BuddyMessage)}
+		if err := client.SendDirectMessage(buddy, msg); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("SlackClient.SendDirectMessage")
+		}
+	}
+}
+
+// inherit copies the alerts that another engine already sent, so that they
+// are not repeated after the configuration was reloaded.
+func (e *AlertEngine) inherit(old *AlertEngine) {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.fired == nil {
+		e.fired = make(map[string]Alert)
+	}
+	for k, a := range old.fired {
+		e.fired[k] = a
+	}
+}
+
+// lastAlert returns the last alert of a rule sent to a user, or nil if there
+// is none. Alerts sent by this engine are looked up without the Store.
+func (e *AlertEngine) lastAlert(userID string, r AlertRule) *Alert {
+	key := userID + "/" + r.Name
+	e.mu.Lock()
+	a, ok := e.fired[key]
+	e.mu.Unlock()
+	if ok {
+		return &a
+	}
+	if e.Store == nil {
+		return nil
+	}
+
+	stored, err := e.Store.LastAlert(userID, r.Name)
+	if err == ErrNoAlert {
+		return nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("AlertStore.LastAlert")
+		return nil
+	}
+	e.mu.Lock()
+	if e.fired == nil {
+		e.fired = make(map[string]Alert)
+	}
+	e.fired[key] = *stored
+	e.mu.Unlock()
+	return stored
+}
+
+// alerted reports whether the user already got an alert of the rule about
+// the given period, or a later one.
+func (e *AlertEngine) alerted(userID string, r AlertRule, period time.Time) bool {
+	a := e.lastAlert(userID, r)
+	return a != nil && !a.Period.Before(period)
+}
+
+// alertedSince reports whether the user got an alert of the rule since t.
+func (e *AlertEngine) alertedSince(userID string, r AlertRule, t time.Time) bool {
+	a := e.lastAlert(userID, r)
+	return a != nil && !a.SentAt.Before(t)
+}
+
+// consecutiveLow checks if the streak of low logs reached the count, and
+// returns when the streak started.
+func consecutiveLow(items []LogItem, r AlertRule) (time.Time, bool) {
+	streak := 0
+	for i := len(items) - 1; i >= 0; i-- {
+		if float64(items[i].Measure) > r.Threshold {
+			break
+		}
+		streak++
+	}
+	if streak == 0 || streak < r.Count {
+		return time.Time{}, false
+	}
+	return items[len(items)-streak].Timestamp, true
+}
+
+// averageDrop compares the average of the last Count days with the Count days
+// before that.
+func averageDrop(items []LogItem, now time.Time, r AlertRule) bool {
+	start := now.AddDate(0, 0, -r.Count)
+	var recent, previous []LogItem
+	for _, item := range items {
+		if item.Timestamp.Before(start) {
+			if !item.Timestamp.Before(start.AddDate(0, 0, -r.Count)) {
+				previous = append(previous, item)
+			}
+		} else {
+			recent = append(recent, item)
+		}
+	}
+	if len(recent) == 0 || len(previous) == 0 {
+		return false
+	}
+	return average(previous)-average(recent) > r.Threshold
+}
+
+func average(items []LogItem) float64 {
+	sum := 0
+	for _, item := range items {
+		sum += item.Measure
+	}
+	return float64(sum) / float64(len(items))
+}
+
+// workingDaysBetween counts the weekdays after the day of from and before the
+// day of to.
+func workingDaysBetween(from, to time.Time) int {
+	from = from.In(to.Location())
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, to.Location()).AddDate(0, 0, 1)
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, to.Location())
+
+	n := 0
+	for ; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,235 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+// logs creates a history of daily logs for a user, ending on the given day.
+func logs(userID string, end time.Time, measures ...int) []LogItem {
+	items := []LogItem{}
+	for i, m := range measures {
+		items = append(items, LogItem{
+			UserID:    userID,
+			Measure:   m,
+			Timestamp: end.AddDate(0, 0, i-len(measures)+1),
+		})
+	}
+	return items
+}
+
+func TestAlertEngine_Evaluate(t *testing.T) {
+	// 2020-01-17 is a Friday
+	now := time.Date(2020, 1, 17, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		rules     []AlertRule
+		items     []LogItem
+		prefs     map[string]Preferences
+		alerted   *Alert // stored before evaluating, e.g. by an earlier run
+		wantUser  int
+		wantBuddy int
+	}{
+		{
+			name:     "low streak reached",
+			rules:    []AlertRule{{Name: "low", Kind: RuleConsecutiveLow, Threshold: 2, Count: 3, Message: "hi {user}"}},
+			items:    logs("U1", now, 4, 2, 1, 2),
+			wantUser: 1,
+		},
+		{
+			name:     "low streak not yet reached",
+			rules:    []AlertRule{{Name: "low", Kind: RuleConsecutiveLow, Threshold: 2, Count: 3, Message: "hi {user}"}},
+			items:    logs("U1", now, 4, 4, 1, 2),
+			wantUser: 0,
+		},
+		{
+			name:     "low streak already alerted",
+			rules:    []AlertRule{{Name: "low", Kind: RuleConsecutiveLow, Threshold: 2, Count: 3, Message: "hi {user}"}},
+			items:    logs("U1", now, 2, 2, 1, 2),
+			alerted:  &Alert{UserID: "U1", Rule: "low", Period: now.AddDate(0, 0, -3), SentAt: now.AddDate(0, 0, -1)},
+			wantUser: 0,
+		},
+		{
+			name:     "low streak alert was missed",
+			rules:    []AlertRule{{Name: "low", Kind: RuleConsecutiveLow, Threshold: 2, Count: 3, Message: "hi {user}"}},
+			items:    logs("U1", now, 2, 2, 1, 2),
+			wantUser: 1,
+		},
+		{
+			name:     "earlier low streak alerted",
+			rules:    []AlertRule{{Name: "low", Kind: RuleConsecutiveLow, Threshold: 2, Count: 3, Message: "hi {user}"}},
+			items:    logs("U1", now, 2, 2, 4, 1, 2, 2),
+			alerted:  &Alert{UserID: "U1", Rule: "low", Period: now.AddDate(0, 0, -5), SentAt: now.AddDate(0, 0, -4)},
+			wantUser: 1,
+		},
+		{
+			name:     "average drop already alerted",
+			rules:    []AlertRule{{Name: "drop", Kind: RuleAverageDrop, Threshold: 1.0, Count: 3, Message: "hi {user}"}},
+			items:    logs("U1", now, 5, 5, 4, 3, 3, 2),
+			alerted:  &Alert{UserID: "U1", Rule: "drop", Period: now.AddDate(0, 0, -4), SentAt: now.AddDate(0, 0, -1)},
+			wantUser: 0,
+		},
+		{
+			name:     "average dropped",
+			rules:    []AlertRule{{Name: "drop", Kind: RuleAverageDrop, Threshold: 1.0, Count: 3, Message: "hi {user}"}},
+			items:    logs("U1", now, 5, 5, 4, 3, 3, 2),
+			wantUser: 1,
+		},
+		{
+			name:     "average stable",
+			rules:    []AlertRule{{Name: "drop", Kind: RuleAverageDrop, Threshold: 1.0, Count: 3, Message: "hi {user}"}},
+			items:    logs("U1", now, 4, 4, 4, 3, 4, 4),
+			wantUser: 0,
+		},
+		{
+			name: "buddy is notified",
+			rules: []AlertRule{{
+				Name: "low", Kind: RuleConsecutiveLow, Threshold: 2, Count: 1,
+				Message: "hi {user}", BuddyMessage: "hi {buddy}, check on {user}",
+			}},
+			items:     logs("U1", now, 4, 1),
+			prefs:     map[string]Preferences{"U1": {UserID: "U1", Buddy: "U2"}},
+			wantUser:  1,
+			wantBuddy: 1,
+		},
+		{
+			name: "no buddy to notify",
+			rules: []AlertRule{{
+				Name: "low", Kind: RuleConsecutiveLow, Threshold: 2, Count: 1,
+				Message: "hi {user}", BuddyMessage: "hi {buddy}, check on {user}",
+			}},
+			items:    logs("U1", now, 4, 1),
+			wantUser: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSlackStandIn("xoxb-test")
+			defer srv.Close()
+
+			db := &memory{items: tt.items}
+			if tt.alerted != nil {
+				db.SaveAlert(*tt.alerted)
+			}
+			e := &AlertEngine{
+				Rules:       tt.rules,
+				DB:          db,
+				Preferences: &memory{preferences: tt.prefs},
+				Client:      srv.client("xoxb-test"),
+				Store:       db,
+			}
+			e.Evaluate("", "U1", now)
+
+			var user, buddy int
+			for _, msg := range srv.posted() {
+				switch msg.Channel {
+				case "DU1":
+					user++
+				case "DU2":
+					buddy++
+				}
+			}
+			if user != tt.wantUser || buddy != tt.wantBuddy {
+				t.Errorf("AlertEngine.Evaluate() sent %d to user and %d to buddy, want %d and %d", user, buddy, tt.wantUser, tt.wantBuddy)
+			}
+		})
+	}
+}
+
+func TestAlertEngine_CheckInactivity(t *testing.T) {
+	rules := []AlertRule{{Name: "inactive", Kind: RuleInactivity, Count: 3, Message: "hi {user}"}}
+	// 2020-01-17 is a Friday
+	now := time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		items   []LogItem
+		alerted *Alert
+		ticks   []time.Time
+		want    int
+	}{
+		{
+			name:  "inactive for three working days",
+			items: logs("U1", time.Date(2020, 1, 13, 9, 0, 0, 0, time.UTC), 3),
+			ticks: []time.Time{now},
+			want:  1,
+		},
+		{
+			name:  "weekends are not counted",
+			items: logs("U1", time.Date(2020, 1, 10, 9, 0, 0, 0, time.UTC), 3),
+			ticks: []time.Time{time.Date(2020, 1, 16, 10, 0, 0, 0, time.UTC)},
+			want:  1,
+		},
+		{
+			name:  "recently active",
+			items: logs("U1", time.Date(2020, 1, 15, 9, 0, 0, 0, time.UTC), 3),
+			ticks: []time.Time{now},
+			want:  0,
+		},
+		{
+			name:  "alerted once",
+			items: logs("U1", time.Date(2020, 1, 13, 9, 0, 0, 0, time.UTC), 3),
+			ticks: []time.Time{now, now.AddDate(0, 0, 1)},
+			want:  1,
+		},
+		{
+			name:  "check was missed",
+			items: logs("U1", time.Date(2020, 1, 13, 9, 0, 0, 0, time.UTC), 3),
+			ticks: []time.Time{now.AddDate(0, 0, 3)},
+			want:  1,
+		},
+		{
+			name:    "alerted before a restart",
+			items:   logs("U1", time.Date(2020, 1, 13, 9, 0, 0, 0, time.UTC), 3),
+			alerted: &Alert{UserID: "U1", Rule: "inactive", Period: time.Date(2020, 1, 13, 9, 0, 0, 0, time.UTC), SentAt: now},
+			ticks:   []time.Time{now.AddDate(0, 0, 3)},
+			want:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSlackStandIn("xoxb-test")
+			defer srv.Close()
+
+			db := &memory{items: tt.items}
+			if tt.alerted != nil {
+				db.SaveAlert(*tt.alerted)
+			}
+			e := &AlertEngine{
+				Rules:  rules,
+				DB:     db,
+				Client: srv.client("xoxb-test"),
+				Store:  db,
+			}
+			for _, tick := range tt.ticks {
+				e.CheckInactivity(tick)
+			}
+
+			if got := len(srv.posted()); got != tt.want {
+				t.Errorf("AlertEngine.CheckInactivity() sent %d alerts, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkingDaysBetween(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     int
+	}{
+		{name: "same day", from: time.Date(2020, 1, 13, 9, 0, 0, 0, time.UTC), to: time.Date(2020, 1, 13, 17, 0, 0, 0, time.UTC), want: 0},
+		{name: "within a week", from: time.Date(2020, 1, 13, 9, 0, 0, 0, time.UTC), to: time.Date(2020, 1, 17, 9, 0, 0, 0, time.UTC), want: 3},
+		{name: "across a weekend", from: time.Date(2020, 1, 10, 9, 0, 0, 0, time.UTC), to: time.Date(2020, 1, 14, 9, 0, 0, 0, time.UTC), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := workingDaysBetween(tt.from, tt.to); got != tt.want {
+				t.Errorf("workingDaysBetween() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"4d63.com/tz"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// apiTokenPrefix makes tokens easy to recognize, e.g. by secret scanners
+	apiTokenPrefix = "bb_"
+
+	// apiDefaultDays is how far back GET /api/v1/logs looks without since
+	apiDefaultDays = 30
+
+	// apiTokenTTL is how long verified tokens are cached. A token revoked
+	// through another server may still be accepted by this one for as long.
+	apiTokenTTL = time.Minute
+)
+
+// ErrNoToken is returned by a TokenStore if the user has no API token.
+var ErrNoToken = errors.New("the user has no API token")
+
+// APIToken is the token of a user for the REST API. Only the SHA-256 hash of
+// the token is stored, and each user has at most one.
+type APIToken struct {
+	tableName struct{} `sql:"api_tokens"`
+
+	UserID   string    `sql:",pk" bigquery:"user_id"`
+	TeamID   string    `bigquery:"team_id"` // Only set if installed into several workspaces
+	Hash     string    `bigquery:"hash"`    // Hex-encoded SHA-256 of the token, empty if revoked
+	IssuedAt time.Time `bigquery:"issued_at"`
+}
+
+// TokenStore is an interface for storing the API tokens of users.
+// GetAPIToken returns ErrNoToken for users without one.
+type TokenStore interface {
+	GetAPIToken(userID string) (*APIToken, error)
+	SaveAPIToken(t APIToken) error
+}
+
+// NewAPIToken creates a token for a user, and returns it along with the
+// APIToken to store. The user ID is part of the token, so that it can be
+// looked up without an index on the hash.
+func NewAPIToken(userID, teamID string) (string, *APIToken, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := fmt.Sprintf("%s%s.%s", apiTokenPrefix, base64.RawURLEncoding.EncodeToString([]byte(userID)), hex.EncodeToString(b))
+	return token, &APIToken{UserID: userID, TeamID: teamID, Hash: hashAPIToken(token), IssuedAt: time.Now()}, nil
+}
+
+// apiTokenUser returns the user ID within a token.
+func apiTokenUser(token string) (string, error) {
+	i := strings.LastIndex(token, ".")
+	if !strings.HasPrefix(token, apiTokenPrefix) || i < 0 {
+		return "", fmt.Errorf("malformed token")
+	}
+	userID, err := base64.RawURLEncoding.DecodeString(token[len(apiTokenPrefix):i])
+	if err != nil || len(userID) == 0 {
+		return "", fmt.Errorf("malformed token")
+	}
+	return string(userID), nil
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks a token against the stored one.
+func (t *APIToken) Verify(token string) error {
+	if t.Hash == "" || subtle.ConstantTimeCompare([]byte(hashAPIToken(token)), []byte(t.Hash)) != 1 {
+		return fmt.Errorf("invalid or revoked token")
+	}
+	return nil
+}
+
+// commandToken issues a new API token for the user, replacing the previous
+// one, or revokes it with `token revoke`.
+func (s *Server) commandToken(form url.Values, args []string) (*Message, error) {
+	store, ok := s.database.(TokenStore)
+	if !ok {
+		return nil, fmt.Errorf("the configured database cannot store API tokens")
+	}
+	userID := form.Get("user_id")
+	if len(args) > 0 && args[0] != "revoke" {
+		return &Message{ResponseType: "ephemeral", Text: "Use `token` to get a new API token, or `token revoke` to revoke it."}, nil
+	}
+	team, err := s.verifyTeam(form.Get("team_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 0 {
+		if err := s.saveAPIToken(store, APIToken{UserID: userID, TeamID: team, IssuedAt: time.Now()}); err != nil {
+			return nil, err
+		}
+		// Other servers cache verified tokens for apiTokenTTL
+		return &Message{
+			ResponseType: "ephemeral",
+			Text:         "Your API token is revoked. If the barometer runs on several servers, the others may still accept it for up to a minute.",
+		}, nil
+	}
+
+	token, t, err := NewAPIToken(userID, team)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveAPIToken(store, *t); err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		ResponseType: "ephemeral",
+		Text: fmt.Sprintf("Here's your API token, which replaces any previous one. Keep it secret, as I won't show it again:\n`%s`\n\n"+
+			"Log through `POST /api/v1/logs` with the header `Authorization: Bearer <token>` and a body such as "+
+			"`{\"measure\": 3, \"notes\": \"long review day\"}`. Use `token revoke` to revoke it.", token),
+	}
+	return msg, nil
+}
+
+// apiToken authenticates a request to the REST API through its bearer token.
+// It must be called on a snapshot of the Server.
+func (s *Server) apiToken(r *http.Request) (*APIToken, error) {
+	store, ok := s.database.(TokenStore)
+	if !ok {
+		return nil, fmt.Errorf("the configured database cannot store API tokens")
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil, fmt.Errorf("missing bearer token")
+	}
+	userID, err := apiTokenUser(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Looking up tokens is slow on BigQuery, so verified ones are cached
+	if t, ok := s.apiTokens.Get(userID); ok && t.(*APIToken).Verify(token) == nil {
+		return t.(*APIToken), nil
+	}
+	t, err := store.GetAPIToken(userID)
+	if err != nil {
+		if err == ErrNoToken {
+			return nil, fmt.Errorf("invalid or revoked token")
+		}
+		return nil, err
+	}
+	if err := t.Verify(token); err != nil {
+		return nil, err
+	}
+	s.apiTokens.Set(userID, t)
+	return t, nil
+}
+
+// saveAPIToken stores the token of a user, replacing the cached one.
+func (s *Server) saveAPIToken(store TokenStore, t APIToken) error {
+	if err := store.SaveAPIToken(t); err != nil {
+		return err
+	}
+	s.apiTokens.Delete(t.UserID)
+	return nil
+}
+
+// authenticated rejects requests to the REST API without a valid token.
+func (s *Server) authenticated(h func(w http.ResponseWriter, r *http.Request, t *APIToken)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		t, err := s.apiToken(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			e := errorMsg{Message: fmt.Sprintf("token may be missing or invalid: %s", err), Code: http.StatusUnauthorized}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("apiToken")
+			verificationFailures.WithLabelValues("/api/v1/logs").Inc()
+			return
+		}
+		h(w, r, t)
+	}
+}
+
+// handleAPICreateLog stores a log sent as a JSON LogItem, of which only the
+// measure, notes and optionally the timestamp are used. It responds with the
+// stored LogItem.
+func (s *Server) handleAPICreateLog() http.HandlerFunc {
+	return s.authenticated(func(w http.ResponseWriter, r *http.Request, t *APIToken) {
+		logger(r.Context()).WithFields(log.Fields{"path": "/api/v1/logs"}).Trace("received request")
+
+		in := LogItem{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&in); err != nil {
+			e := errorMsg{Message: fmt.Sprintf("couldn't parse body: %s", err), Code: http.StatusBadRequest}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("json.Decode")
+			return
+		}
+		text := fmt.Sprintf("%d %s", in.Measure, in.Notes)
+		if _, _, err := ParseMessage(text); err != nil {
+			e := errorMsg{Message: fmt.Sprintf("invalid log: %s", err), Code: http.StatusBadRequest}
+			e.JSONError(w)
+			return
+		}
+
+		item, _, resp, err := s.store(r.Context(), &Submission{
+			From:      LogContext{UserID: t.UserID, TeamID: t.TeamID},
+			Text:      text,
+			Timestamp: in.Timestamp,
+			Workspace: t.TeamID,
+		})
+		if err != nil {
+			e := errorMsg{Message: fmt.Sprintf("error in storing log: %s", err), Code: http.StatusInternalServerError}
+			e.JSONError(w)
+			return
+		}
+		if resp != nil {
+			e := errorMsg{Message: resp.Text, Code: http.StatusTooManyRequests}
+			e.JSONError(w)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(item)
+	})
+}
+
+// handleAPIListLogs responds with the logs of the user as JSON LogItems. The
+// period is set by the since and until parameters, either as RFC 3339
+// timestamps or dates in the user's timezone, and defaults to the last
+// apiDefaultDays days.
+func (s *Server) handleAPIListLogs() http.HandlerFunc {
+	return s.authenticated(func(w http.ResponseWriter, r *http.Request, t *APIToken) {
+		logger(r.Context()).WithFields(log.Fields{"path": "/api/v1/logs"}).Trace("received request")
+
+		dq, ok := s.database.(DBQuerier)
+		if !ok {
+			e := errorMsg{Message: "the configured database cannot be queried", Code: http.StatusNotImplemented}
+			e.JSONError(w)
+			return
+		}
+
+		area := s.area(t.TeamID, s.userPreferences(t.UserID))
+		since, _, err := LastDays(time.Now(), area, apiDefaultDays)
+		if err != nil {
+			e := errorMsg{Message: err.Error(), Code: http.StatusInternalServerError}
+			e.JSONError(w)
+			return
+		}
+		q := Query{TeamID: t.TeamID, UserID: t.UserID, Since: since}
+		for param, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			v := r.URL.Query().Get(param)
+			if v == "" {
+				continue
+			}
+			if *dst, err = parseAPITime(v, area); err != nil {
+				e := errorMsg{Message: fmt.Sprintf("invalid %s: %s", param, err), Code: http.StatusBadRequest}
+				e.JSONError(w)
+				return
+			}
+		}
+
+		items, err := dq.QueryDB(q)
+		if err != nil {
+			e := errorMsg{Message: fmt.Sprintf("error in querying logs: %s", err), Code: http.StatusInternalServerError}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("QueryDB")
+			return
+		}
+		json.NewEncoder(w).Encode(items)
+	})
+}
+
+// parseAPITime parses an RFC 3339 timestamp, or a date such as 2020-05-04 at
+// midnight in the given area.
+func parseAPITime(s, area string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	loc, err := tz.LoadLocation(area)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 timestamp nor a date such as 2020-05-04", s)
+	}
+	return t, nil
+}
+
+// APIClient is a minimal client for the REST API of a running server, e.g.
+// for logging from the terminal.
+type APIClient struct {
+	BaseURL    string // URL of the server, e.g. https://barometer.example.com
+	Token      string // API token obtained through `/barometer token`
+	HTTPClient *http.Client
+}
+
+// NewAPIClient creates an APIClient for the server at baseURL.
+func NewAPIClient(baseURL, token string) *APIClient {
+	return &APIClient{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// CreateLog stores a log through POST /api/v1/logs and returns the stored
+// LogItem.
+func (c *APIClient) CreateLog(item LogItem) (*LogItem, error) {
+	body, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/api/v1/logs", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error in calling the API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		e := errorMsg{}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Message == "" {
+			return nil, fmt.Errorf("the API returned status %s", resp.Status)
+		}
+		return nil, fmt.Errorf("the API returned status %s: %s", resp.Status, e.Message)
+	}
+	stored := &LogItem{}
+	if err := json.NewDecoder(resp.Body).Decode(stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
@@ -0,0 +1,287 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAPIToken(t *testing.T) {
+	token, stored, err := NewAPIToken("U1", "T1")
+	if err != nil {
+		t.Fatalf("NewAPIToken() error = %v", err)
+	}
+	if stored.UserID != "U1" || stored.TeamID != "T1" || stored.Hash == "" || strings.Contains(stored.Hash, token) {
+		t.Errorf("NewAPIToken() stored %+v", stored)
+	}
+	if userID, err := apiTokenUser(token); err != nil || userID != "U1" {
+		t.Errorf("apiTokenUser() = %q, %v, want U1", userID, err)
+	}
+	if err := stored.Verify(token); err != nil {
+		t.Errorf("APIToken.Verify() error = %v", err)
+	}
+	if err := stored.Verify(token + "0"); err == nil {
+		t.Errorf("APIToken.Verify() accepted another token")
+	}
+	if err := (&APIToken{UserID: "U1"}).Verify(token); err == nil {
+		t.Errorf("APIToken.Verify() accepted a revoked token")
+	}
+}
+
+func TestServer_commandToken(t *testing.T) {
+	db := &memory{}
+	s := &Server{Config: &Configuration{Area: "Asia/Manila"}, database: db}
+	form := url.Values{"user_id": {"U1"}, "team_id": {"T1"}}
+
+	msg, err := s.commandToken(form, nil)
+	if err != nil {
+		t.Fatalf("commandToken() error = %v", err)
+	}
+	token := regexp.MustCompile("`(bb_[^`]+)`").FindStringSubmatch(msg.Text)
+	if msg.ResponseType != "ephemeral" || len(token) != 2 {
+		t.Fatalf("commandToken() = %+v, want an ephemeral reply with the token", msg)
+	}
+	stored, _ := db.GetAPIToken("U1")
+	if err := stored.Verify(token[1]); err != nil || stored.TeamID != "" {
+		t.Errorf("commandToken() stored %+v: %v", stored, err)
+	}
+
+	if _, err := s.commandToken(form, []string{"revoke"}); err != nil {
+		t.Fatalf("commandToken(revoke) error = %v", err)
+	}
+	stored, _ = db.GetAPIToken("U1")
+	if err := stored.Verify(token[1]); err == nil {
+		t.Errorf("commandToken(revoke) didn't revoke the token")
+	}
+}
+
+func TestServer_commandToken_workspaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		teamID  string
+		wantErr bool
+	}{
+		{name: "issued", teamID: "T1"},
+		{name: "revoked", args: []string{"revoke"}, teamID: "T1"},
+		{name: "issued to unknown team", teamID: "T2", wantErr: true},
+		{name: "revoked by unknown team", args: []string{"revoke"}, teamID: "T2", wantErr: true},
+		{name: "revoked without team", args: []string{"revoke"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memory{}
+			db.SaveInstallation(Installation{TeamID: "T1", BotToken: "xoxb-1"})
+			s := &Server{
+				Config:     &Configuration{Area: "Asia/Manila"},
+				database:   db,
+				workspaces: &Workspaces{Store: db},
+			}
+			form := url.Values{"user_id": {"U1"}, "team_id": {tt.teamID}}
+
+			_, err := s.commandToken(form, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("commandToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			stored, err := db.GetAPIToken("U1")
+			if tt.wantErr {
+				if err != ErrNoToken {
+					t.Errorf("commandToken() stored %+v for an unknown team", stored)
+				}
+				return
+			}
+			if err != nil || stored.TeamID != "T1" {
+				t.Errorf("commandToken() stored %+v, %v, want the token of T1", stored, err)
+			}
+		})
+	}
+}
+
+// countingTokenStore counts the lookups of API tokens.
+type countingTokenStore struct {
+	memory
+	lookups int
+}
+
+func (c *countingTokenStore) GetAPIToken(userID string) (*APIToken, error) {
+	c.lookups++
+	return c.memory.GetAPIToken(userID)
+}
+
+func TestServer_apiToken_cache(t *testing.T) {
+	db := &countingTokenStore{}
+	s := &Server{Config: &Configuration{Area: "Asia/Manila"}, database: db, apiTokens: &cache{TTL: time.Minute}}
+	form := url.Values{"user_id": {"U1"}}
+	msg, _ := s.commandToken(form, nil)
+	token := regexp.MustCompile("`(bb_[^`]+)`").FindStringSubmatch(msg.Text)[1]
+
+	authenticate := func() error {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/logs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, err := s.apiToken(req)
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if err := authenticate(); err != nil {
+			t.Fatalf("apiToken() error = %v", err)
+		}
+	}
+	if db.lookups != 1 {
+		t.Errorf("apiToken() looked up the token %d times, want it cached after the first", db.lookups)
+	}
+
+	// Revoking the token invalidates the cache
+	if _, err := s.commandToken(form, []string{"revoke"}); err != nil {
+		t.Fatalf("commandToken(revoke) error = %v", err)
+	}
+	if err := authenticate(); err == nil {
+		t.Errorf("apiToken() accepted a revoked token")
+	}
+}
+
+func TestServer_handleAPICreateLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		auth      string // "valid" is replaced by a valid token
+		body      string
+		wantCode  int
+		wantItems int
+	}{
+		{name: "stored", auth: "valid", body: `{"measure": 3, "notes": "long review day"}`, wantCode: http.StatusCreated, wantItems: 1},
+		{name: "with timestamp", auth: "valid", body: `{"measure": 4, "timestamp": "2020-05-04T03:02:01Z"}`, wantCode: http.StatusCreated, wantItems: 1},
+		{name: "missing token", auth: "", body: `{"measure": 3}`, wantCode: http.StatusUnauthorized},
+		{name: "unknown token", auth: "bb_VTI.abcd", body: `{"measure": 3}`, wantCode: http.StatusUnauthorized},
+		{name: "invalid measure", auth: "valid", body: `{"measure": 7}`, wantCode: http.StatusBadRequest},
+		{name: "malformed body", auth: "valid", body: `{"measure": `, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memory{}
+			s := &Server{Config: &Configuration{Area: "Asia/Manila"}, database: db}
+			token, stored, _ := NewAPIToken("U1", "")
+			db.SaveAPIToken(*stored)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/logs", strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", "Bearer "+strings.Replace(tt.auth, "valid", token, 1))
+			}
+			rec := httptest.NewRecorder()
+			s.handleAPICreateLog()(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("handleAPICreateLog() status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			items, _ := db.QueryDB(Query{})
+			if len(items) != tt.wantItems {
+				t.Fatalf("handleAPICreateLog() stored %d logs, want %d", len(items), tt.wantItems)
+			}
+			if tt.wantItems == 0 {
+				return
+			}
+			got := LogItem{}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("handleAPICreateLog() returned invalid JSON: %v", err)
+			}
+			if got.UserID != "U1" || got.Measure != items[0].Measure || !got.Timestamp.Equal(items[0].Timestamp) {
+				t.Errorf("handleAPICreateLog() = %+v, stored %+v", got, items[0])
+			}
+		})
+	}
+}
+
+func TestServer_handleAPIListLogs(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantNotes []string
+	}{
+		{name: "last days", query: "", wantCode: http.StatusOK, wantNotes: []string{"yesterday", "today"}},
+		{name: "since date", query: "?since=2020-05-01", wantCode: http.StatusOK, wantNotes: []string{"long ago", "yesterday", "today"}},
+		{name: "until timestamp", query: "?since=2020-05-01&until=" + url.QueryEscape(now.Add(-time.Hour).Format(time.RFC3339)), wantCode: http.StatusOK, wantNotes: []string{"long ago", "yesterday"}},
+		{name: "invalid since", query: "?since=yesterday", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memory{}
+			db.InsertDB(LogItem{UserID: "U1", Timestamp: time.Date(2020, 5, 4, 3, 2, 1, 0, time.UTC), Measure: 2, Notes: "long ago"})
+			db.InsertDB(LogItem{UserID: "U1", Timestamp: now.Add(-24 * time.Hour), Measure: 3, Notes: "yesterday"})
+			db.InsertDB(LogItem{UserID: "U1", Timestamp: now, Measure: 4, Notes: "today"})
+			db.InsertDB(LogItem{UserID: "U2", Timestamp: now, Measure: 1, Notes: "someone else"})
+			s := &Server{Config: &Configuration{Area: "Asia/Manila"}, database: db}
+			token, stored, _ := NewAPIToken("U1", "")
+			db.SaveAPIToken(*stored)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/logs"+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			s.handleAPIListLogs()(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("handleAPIListLogs() status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			items := []LogItem{}
+			if err := json.NewDecoder(rec.Body).Decode(&items); err != nil {
+				t.Fatalf("handleAPIListLogs() returned invalid JSON: %v", err)
+			}
+			got := []string{}
+			for _, i := range items {
+				got = append(got, i.Notes)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantNotes, ",") {
+				t.Errorf("handleAPIListLogs() = %v, want %v", got, tt.wantNotes)
+			}
+		})
+	}
+}
+
+func TestAPIClient_CreateLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		measure int
+		wantErr string // substring of the expected error, or empty
+	}{
+		{name: "stored", token: "valid", measure: 3},
+		{name: "revoked token", token: "bb_VTE.abcd", measure: 3, wantErr: "401"},
+		{name: "invalid measure", token: "valid", measure: 0, wantErr: "invalid log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memory{}
+			s := &Server{Config: &Configuration{Area: "Asia/Manila"}, database: db}
+			token, stored, _ := NewAPIToken("U1", "")
+			db.SaveAPIToken(*stored)
+			ts := httptest.NewServer(s.handleAPICreateLog())
+			defer ts.Close()
+
+			c := NewAPIClient(ts.URL+"/", strings.Replace(tt.token, "valid", token, 1))
+			got, err := c.CreateLog(LogItem{Measure: tt.measure, Notes: "long review day"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("APIClient.CreateLog() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("APIClient.CreateLog() error = %v", err)
+			}
+			if got.UserID != "U1" || got.Measure != tt.measure || got.Notes != "long review day" {
+				t.Errorf("APIClient.CreateLog() = %+v", got)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -16,6 +17,8 @@ import (
 	"cloud.google.com/go/bigquery"
 	"github.com/dghubble/go-twitter/twitter"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -26,35 +29,87 @@ const (
 // UpdateLog accepts the userID and the text, parses the timestamp, and stores it into the database.
 // If debug is true, then log is not inserted into the database. This option is useful for testing.
 func UpdateLog(userID, text string, timestamp time.Time, db DBInserter, twitterClient *twitter.Client, debug bool) (*Message, error) {
+	ctx := context.Background()
+	prefs := &Preferences{UserID: userID}
+	item, err := StoreLog(ctx, LogContext{UserID: userID}, text, timestamp, db, prefs, nil, debug)
+	if err != nil {
+		return nil, err
+	}
+	return replyTo(ctx, item, twitterClient, prefs)
+}
+
+// replyTo acknowledges a stored log in the style of the user's preferences.
+func replyTo(ctx context.Context, item *LogItem, twitterClient *twitter.Client, prefs *Preferences) (*Message, error) {
+	if prefs.ReplyStyle != ReplyPlain {
+		item.TwitterClient = twitterClient
+	}
+
+	msg, err := item.Reply(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if prefs.ReplyStyle == ReplyPlain {
+		msg.Attachments = nil
+	}
+	if label := prefs.Label(item.Measure); label != "" {
+		msg.Text = fmt.Sprintf("%s: %d, %s (%s)", ackPrefix, item.Measure, label, item.Notes)
+	}
+	return msg, nil
+}
+
+// StoreLog parses the text of a user, e.g. "3 long review day", and stores it
+// into the database as in UpdateLog, but returns the stored item instead of a
+// reply. The fields of the LogContext other than the user ID are only stored
+// if not empty, and the user's preferences, if given, control what gets
+// stored. If alerts is not nil, then the alert rules are evaluated after the
+// log is inserted.
+func StoreLog(ctx context.Context, from LogContext, text string, timestamp time.Time, db DBInserter, prefs *Preferences, alerts *AlertEngine, debug bool) (item *LogItem, err error) {
+	ctx, span := tracer().Start(ctx, "StoreLog")
+	defer func() { endSpan(span, err) }()
+
+	_, parse := tracer().Start(ctx, "ParseMessage")
 	measure, notes, err := ParseMessage(text)
+	endSpan(parse, err)
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Error("strconv")
+		logger(ctx).WithFields(log.Fields{"err": err}).Error("strconv")
 		return nil, err
 	}
 
-	item := LogItem{
-		Timestamp:     timestamp,
-		UserID:        userID,
-		Measure:       *measure,
-		Notes:         *notes,
-		TwitterClient: twitterClient,
+	item = &LogItem{
+		Timestamp:    timestamp,
+		TeamID:       from.TeamID,
+		EnterpriseID: from.EnterpriseID,
+		ChannelID:    from.ChannelID,
+		UserID:       from.UserID,
+		UserName:     from.UserName,
+		TriggerID:    from.TriggerID,
+		Measure:      *measure,
+		Notes:        *notes,
+	}
+	if prefs != nil && prefs.HideNotes {
+		item.Notes = ""
 	}
 
 	if debug {
-		log.Info("DebugOnly is set to true, will not insert to database")
-	} else {
-		if err := item.Insert(db); err != nil {
-			log.WithFields(log.Fields{"err": err}).Error("logItem.insert")
-			return nil, err
-		}
+		logger(ctx).Info("DebugOnly is set to true, will not insert to database")
+		return item, nil
 	}
-
-	return item.Reply()
+	if err := item.Insert(ctx, db); err != nil {
+		logger(ctx).WithFields(log.Fields{"err": err}).Error("logItem.insert")
+		return nil, err
+	}
+	alerts.Observe(*item)
+	return item, nil
 }
 
 // ParseMessage extracts the barometer measure and notes from a given text.
 func ParseMessage(s string) (*int, *string, error) {
 	list := strings.Fields(s)
+	if len(list) == 0 {
+		err := fmt.Errorf("empty text, expected a mood-level such as \"3 long review day\"")
+		log.WithFields(log.Fields{"err": err}).Error("ParseMessage")
+		return nil, nil, err
+	}
 	m := list[0]
 	notes := strings.Join(list[1:], " ")
 	measure, err := strconv.Atoi(m)
@@ -71,40 +126,105 @@ func ParseMessage(s string) (*int, *string, error) {
 	return &measure, &notes, nil
 }
 
+// LogContext describes where a log was sent from, e.g. the Slack workspace
+// and channel. Only the UserID is required.
+type LogContext struct {
+	UserID       string
+	UserName     string
+	TeamID       string
+	EnterpriseID string
+	ChannelID    string
+	TriggerID    string
+}
+
+// ContextFields are the optional fields of a LogContext that can be stored
+// with each log, named after their columns.
+var ContextFields = []string{"team_id", "enterprise_id", "channel_id", "user_name", "trigger_id"}
+
+// Only clears the optional fields that are not in fields.
+func (c LogContext) Only(fields map[string]bool) LogContext {
+	only := LogContext{UserID: c.UserID}
+	if fields["team_id"] {
+		only.TeamID = c.TeamID
+	}
+	if fields["enterprise_id"] {
+		only.EnterpriseID = c.EnterpriseID
+	}
+	if fields["channel_id"] {
+		only.ChannelID = c.ChannelID
+	}
+	if fields["user_name"] {
+		only.UserName = c.UserName
+	}
+	if fields["trigger_id"] {
+		only.TriggerID = c.TriggerID
+	}
+	return only
+}
+
 // LogItem is the user log for the barometer. This also serves as
 // the schema for the database.
 type LogItem struct {
-	Timestamp     time.Time
-	UserID        string
-	Measure       int
-	Notes         string
-	TwitterClient *twitter.Client
+	Timestamp     time.Time       `json:"timestamp"`
+	TeamID        string          `json:"team_id,omitempty"`       // Slack workspace or Microsoft Teams team
+	EnterpriseID  string          `json:"enterprise_id,omitempty"` // Enterprise Grid organization or Microsoft Teams tenant
+	ChannelID     string          `json:"channel_id,omitempty"`    // Channel where the log was sent
+	UserID        string          `json:"user_id"`
+	UserName      string          `json:"user_name,omitempty"`
+	TriggerID     string          `json:"trigger_id,omitempty"` // For opening modals, expires after three seconds
+	Measure       int             `json:"measure"`
+	Notes         string          `json:"notes"`
+	TwitterClient *twitter.Client `sql:"-" json:"-"`
 }
 
-// Save allows us to implement BigQuery's ValueSaver interface.
+// Save allows us to implement BigQuery's ValueSaver interface. The optional
+// columns are only written if set, so that existing tables keep working.
 func (i *LogItem) Save() (map[string]bigquery.Value, string, error) {
-	return map[string]bigquery.Value{
+	row := map[string]bigquery.Value{
 		"timestamp":   i.Timestamp,
 		"user_id":     i.UserID,
 		"log_measure": i.Measure,
 		"notes":       i.Notes,
-	}, "", nil
+	}
+	for column, value := range i.optionalColumns() {
+		if value != "" {
+			row[column] = value
+		}
+	}
+	return row, "", nil
+}
+
+// optionalColumns maps the optional columns, i.e. the ContextFields, to their
+// values. Tables created before these were added don't have them.
+func (i *LogItem) optionalColumns() map[string]string {
+	return map[string]string{
+		"team_id":       i.TeamID,
+		"enterprise_id": i.EnterpriseID,
+		"channel_id":    i.ChannelID,
+		"user_name":     i.UserName,
+		"trigger_id":    i.TriggerID,
+	}
 }
 
 // Insert puts the item entry into the specified database.
-func (i *LogItem) Insert(db DBInserter) error {
-	if err := db.InsertDB(*i); err != nil {
-		log.Errorf("error in inserting item: %v", err)
+func (i *LogItem) Insert(ctx context.Context, db DBInserter) error {
+	_, span := tracer().Start(ctx, "InsertDB", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", dbScheme(db))))
+	err := db.InsertDB(*i)
+	endSpan(span, err)
+	observeInsert(db, err)
+	if err != nil {
+		logger(ctx).Errorf("error in inserting item: %v", err)
 		return err
 	}
 	return nil
 }
 
 // Reply prepares the Slack message as a response to a slash command.
-func (i *LogItem) Reply() (*Message, error) {
+func (i *LogItem) Reply(ctx context.Context) (*Message, error) {
 	var text string
 	if i.TwitterClient != nil {
-		text = i.fetchTwitterMessage("tinycarebot", 20, true)
+		text = i.fetchTwitterMessage(ctx, "tinycarebot", 20, true)
 	} else {
 		text = defaultMessage
 	}
@@ -122,21 +242,29 @@ func (i *LogItem) Reply() (*Message, error) {
 }
 
 // fetchTwitterMessage gets N number of the latest tweets from a username (preferably, tinycarebot)
-func (i *LogItem) fetchTwitterMessage(screenName string, count int, userOnly bool) string {
-	log.WithFields(log.Fields{"username": screenName}).Trace("fetching tweet")
+func (i *LogItem) fetchTwitterMessage(ctx context.Context, screenName string, count int, userOnly bool) string {
+	logger(ctx).WithFields(log.Fields{"username": screenName}).Trace("fetching tweet")
+	_, span := tracer().Start(ctx, "fetchTwitterMessage", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("provider", "twitter")))
+	start := time.Now()
 	tweets, resp, err := i.TwitterClient.Timelines.UserTimeline(&twitter.UserTimelineParams{
 		ScreenName:     screenName,
 		Count:          count,
 		ExcludeReplies: &userOnly,
 	})
-	if err != nil || resp.StatusCode != http.StatusOK {
-		log.Tracef("fetch unsuccessful: %v", err)
+	fetchDuration.WithLabelValues("twitter").Observe(time.Since(start).Seconds())
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("twitter responded with %s", resp.Status)
+	}
+	endSpan(span, err)
+	if err != nil {
+		logger(ctx).Tracef("fetch unsuccessful: %v", err)
 		return defaultMessage
 	}
 	// Choose a random tweet from tinycarebot
 	rand.Seed(time.Now().Unix())
 	tweet := tweets[rand.Intn(len(tweets))]
-	log.Tracef("status (%s), tweet: %s", resp.Status, tweet.Text)
+	logger(ctx).Tracef("status (%s), tweet: %s", resp.Status, tweet.Text)
 	text := fmt.Sprintf("%s (@%s)", tweet.Text, screenName)
 	return text
 }
@@ -144,9 +272,12 @@ func (i *LogItem) fetchTwitterMessage(screenName string, count int, userOnly boo
 // Message is the Slack message event. see
 // https://api.slack.com/docs/message-formatting for more information.
 type Message struct {
-	ResponseType string       `json:"response_type"`
-	Text         string       `json:"text"`
-	Attachments  []Attachment `json:"attachments"`
+	Channel         string       `json:"channel,omitempty"`
+	ResponseType    string       `json:"response_type"`
+	ReplaceOriginal bool         `json:"replace_original,omitempty"`
+	Text            string       `json:"text"`
+	Attachments     []Attachment `json:"attachments"`
+	Blocks          []Block      `json:"blocks,omitempty"`
 }
 
 // Attachment defines the message output after running the slash command.
@@ -157,3 +288,52 @@ type Attachment struct {
 	Text      string `json:"text"`
 	ImageURL  string `json:"image_url"`
 }
+
+// Block is a Slack layout block. Only the fields needed by the barometer
+// are defined, see https://api.slack.com/reference/block-kit/blocks.
+type Block struct {
+	Type     string         `json:"type"`
+	BlockID  string         `json:"block_id,omitempty"`
+	Text     *TextObject    `json:"text,omitempty"`
+	Elements []BlockElement `json:"elements,omitempty"`
+
+	// Input blocks are used inside modals
+	Label    *TextObject   `json:"label,omitempty"`
+	Hint     *TextObject   `json:"hint,omitempty"`
+	Element  *BlockElement `json:"element,omitempty"`
+	Optional bool          `json:"optional,omitempty"`
+}
+
+// BlockElement is an interactive element, such as a button, inside a Block.
+type BlockElement struct {
+	Type          string      `json:"type"`
+	ActionID      string      `json:"action_id,omitempty"`
+	Text          *TextObject `json:"text,omitempty"`
+	Value         string      `json:"value,omitempty"`
+	InitialValue  string      `json:"initial_value,omitempty"`
+	Options       []Option    `json:"options,omitempty"`
+	InitialOption *Option     `json:"initial_option,omitempty"`
+	InitialUser   string      `json:"initial_user,omitempty"`
+}
+
+// Option is a choice inside a select menu.
+type Option struct {
+	Text  *TextObject `json:"text"`
+	Value string      `json:"value"`
+}
+
+// View is a Slack modal, see https://api.slack.com/reference/surfaces/views.
+type View struct {
+	Type       string      `json:"type"`
+	CallbackID string      `json:"callback_id,omitempty"`
+	Title      *TextObject `json:"title"`
+	Submit     *TextObject `json:"submit,omitempty"`
+	Close      *TextObject `json:"close,omitempty"`
+	Blocks     []Block     `json:"blocks"`
+}
+
+// TextObject defines the text shown inside blocks and elements.
+type TextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
@@ -5,6 +5,7 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"testing"
@@ -59,6 +60,59 @@ func TestUpdateLog(t *testing.T) {
 	}
 }
 
+func TestStoreLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		prefs     *Preferences
+		wantNotes string
+		wantReply string
+	}{
+		{
+			name:      "without preferences",
+			text:      "4 hello world",
+			wantNotes: "hello world",
+			wantReply: fmt.Sprintf("%s: 4 (hello world)", ackPrefix),
+		},
+		{
+			name:      "hidden notes",
+			text:      "4 hello world",
+			prefs:     &Preferences{HideNotes: true},
+			wantNotes: "",
+			wantReply: fmt.Sprintf("%s: 4 ()", ackPrefix),
+		},
+		{
+			name:      "scale labels",
+			text:      "4 hello world",
+			prefs:     &Preferences{ScaleLabels: []string{"a", "b", "c", "d", "e"}},
+			wantNotes: "hello world",
+			wantReply: fmt.Sprintf("%s: 4, d (hello world)", ackPrefix),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := &memory{}
+			item, err := StoreLog(ctx, LogContext{UserID: "U1", TeamID: "T1"}, tt.text, time.Now(), db, tt.prefs, nil, false)
+			if err != nil {
+				t.Fatalf("StoreLog() error = %v", err)
+			}
+			if len(db.items) != 1 || db.items[0].Notes != tt.wantNotes || db.items[0].TeamID != "T1" {
+				t.Errorf("StoreLog() stored %+v, want the notes %q in T1", db.items, tt.wantNotes)
+			}
+
+			prefs := tt.prefs
+			if prefs == nil {
+				prefs = &Preferences{UserID: "U1"}
+			}
+			msg, err := replyTo(ctx, item, nil, prefs)
+			if err != nil || msg.Text != tt.wantReply {
+				t.Errorf("replyTo() = %v, %v, want %v", msg, err, tt.wantReply)
+			}
+		})
+	}
+}
+
 func TestParseMessage(t *testing.T) {
 	tests := []struct {
 		name, arg, want1 string
@@ -72,6 +126,8 @@ func TestParseMessage(t *testing.T) {
 		{name: "float measure", arg: "2.0 hello world", wantErr: true},
 		{name: "log measure outside range 1", arg: "100 hello world", wantErr: true},
 		{name: "log measure outside range 2", arg: "-100 hello world", wantErr: true},
+		{name: "empty text", arg: "", wantErr: true},
+		{name: "blank text", arg: "  ", wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -92,6 +148,57 @@ func TestParseMessage(t *testing.T) {
 	}
 }
 
+func TestLogItem_Save(t *testing.T) {
+	tests := []struct {
+		name        string
+		item        LogItem
+		wantColumns []string
+	}{
+		{name: "without context", item: LogItem{UserID: "U1", Measure: 3}, wantColumns: []string{"timestamp", "user_id", "log_measure", "notes"}},
+		{
+			name:        "with context",
+			item:        LogItem{UserID: "U1", Measure: 3, TeamID: "T1", ChannelID: "C1"},
+			wantColumns: []string{"timestamp", "user_id", "log_measure", "notes", "team_id", "channel_id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, _, err := tt.item.Save()
+			if err != nil {
+				t.Fatalf("LogItem.Save() error = %v", err)
+			}
+			if len(row) != len(tt.wantColumns) {
+				t.Errorf("LogItem.Save() = %v, want columns %v", row, tt.wantColumns)
+			}
+			for _, c := range tt.wantColumns {
+				if _, ok := row[c]; !ok {
+					t.Errorf("LogItem.Save() = %v, missing column %s", row, c)
+				}
+			}
+		})
+	}
+}
+
+func TestLogContext_Only(t *testing.T) {
+	c := LogContext{UserID: "U1", UserName: "jane", TeamID: "T1", EnterpriseID: "E1", ChannelID: "C1", TriggerID: "1.2.3"}
+	tests := []struct {
+		name   string
+		fields map[string]bool
+		want   LogContext
+	}{
+		{name: "nothing", want: LogContext{UserID: "U1"}},
+		{name: "some", fields: map[string]bool{"team_id": true, "channel_id": true}, want: LogContext{UserID: "U1", TeamID: "T1", ChannelID: "C1"}},
+		{name: "all", fields: map[string]bool{"team_id": true, "enterprise_id": true, "channel_id": true, "user_name": true, "trigger_id": true}, want: c},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Only(tt.fields); got != tt.want {
+				t.Errorf("LogContext.Only() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
 func ExampleUpdateLog() {
 	// Prepare inputs for updating the log
 	userID := "W012A3CDE"
@@ -0,0 +1,86 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"sync"
+	"time"
+)
+
+// cache keeps values for TTL, e.g. the results of calls to a rate-limited
+// API. A nil cache caches nothing, and it's safe for concurrent use.
+type cache struct {
+	TTL time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// Get returns the value of key, unless it has expired.
+func (c *cache) Get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !c.clock().Before(e.expires) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set stores the value of key until TTL has passed.
+func (c *cache) Set(key string, value interface{}) {
+	if c == nil || c.TTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock()
+	if c.entries == nil {
+		c.entries = map[string]cacheEntry{}
+	}
+	c.sweep(now)
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.TTL)}
+}
+
+// Delete forgets the value of key, e.g. when it has changed.
+func (c *cache) Delete(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+func (c *cache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// sweep forgets the expired entries, so that the cache doesn't grow with
+// every key it has seen. The caller must hold c.mu.
+func (c *cache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.TTL {
+		return
+	}
+	c.lastSweep = now
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2020, 5, 4, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		elapsed  time.Duration
+		deleted  bool
+		nilCache bool
+		want     interface{}
+		wantOK   bool
+	}{
+		{name: "fresh", ttl: time.Minute, elapsed: 30 * time.Second, want: "Europe/Berlin", wantOK: true},
+		{name: "expired", ttl: time.Minute, elapsed: time.Minute, wantOK: false},
+		{name: "deleted", ttl: time.Minute, deleted: true, wantOK: false},
+		{name: "disabled", ttl: 0, wantOK: false},
+		{name: "nil", nilCache: true, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := now
+			c := &cache{TTL: tt.ttl, now: func() time.Time { return clock }}
+			if tt.nilCache {
+				c = nil
+			}
+			c.Set("U1", "Europe/Berlin")
+			if tt.deleted {
+				c.Delete("U1")
+			}
+			clock = clock.Add(tt.elapsed)
+
+			got, ok := c.Get("U1")
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("cache.Get() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -5,29 +5,168 @@
 package pkg
 
 import (
-	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 )
 
 // Configuration contains all important settings for running the command.
+//
+// Fields with a prompt tag are asked for by `barometer init`, suggesting the
+// default tag. Fields with a group tag belong to an optional integration, and
+// are only asked for if it's set up. The section tag places a field in the
+// layout of YAML and TOML files, e.g. "slack.token" for the token key of the
+// slack section. The init tag marks fields as secret
+// (masked when prompted and base64-encoded or referenced when stored) and
+// optional.
 type Configuration struct {
-	Table string `json:"TABLE"`       // Database URL
-	Token string `json:"SLACK_TOKEN"` // Slack token provided by the app for verification
-	Area  string `json:"AREA"`        // IANA-compliant area
+	Table string `json:"TABLE" section:"database.table" prompt:"Database URL to store all logs" default:"bq://my-gcp-project.my-dataset.my-table"`
+	Token string `json:"SLACK_TOKEN" section:"slack.token" prompt:"Slack verification token" init:"secret" group:"slack"`     // Slack token provided by the app for verification
+	Area  string `json:"AREA" section:"area" prompt:"Where are you? (refer to IANA timezone database)" default:"Asia/Manila"` // IANA-compliant area
+
+	// BotToken is the bot user OAuth token (xoxb-*) used for sending direct
+	// messages such as check-in reminders.
+	BotToken  string     `json:"SLACK_BOT_TOKEN" section:"slack.bot_token" prompt:"Slack bot token for sending reminders (optional)" init:"secret,optional" group:"slack"`
+	Reminders []Reminder `json:"REMINDERS" section:"reminders.users,omitempty"` // Users who opted in for daily check-ins
+
+	// The app can be installed into several workspaces through the "Add to
+	// Slack" flow at /slack/install if the OAuth credentials of the app are
+	// set. SlackRedirectURL must match a redirect URL of the app, and is only
+	// needed if the app has more than one.
+	SlackClientID     string `json:"SLACK_CLIENT_ID" section:"slack.client_id,omitempty" prompt:"Slack client ID for installing into several workspaces (optional)" init:"optional" group:"slack"`
+	SlackClientSecret string `json:"SLACK_CLIENT_SECRET" section:"slack.client_secret,omitempty" prompt:"Slack client secret (optional)" init:"secret,optional" group:"slack"`
+	SlackRedirectURL  string `json:"SLACK_REDIRECT_URL" section:"slack.redirect_url,omitempty"`
+
+	// TeamsSecurityToken is the security token of a Microsoft Teams outgoing
+	// webhook pointing at /teams/messages. Teams is disabled if empty.
+	TeamsSecurityToken string `json:"TEAMS_SECURITY_TOKEN" section:"teams.security_token,omitempty" prompt:"Microsoft Teams outgoing webhook security token" init:"secret" group:"teams"`
+
+	// DiscordPublicKey is the public key of a Discord application whose
+	// interactions endpoint is /discord/interactions. Discord is disabled if
+	// empty. The application ID and bot token are only needed for registering
+	// the /barometer command through `barometer discord register`.
+	DiscordPublicKey     string `json:"DISCORD_PUBLIC_KEY" section:"discord.public_key,omitempty" prompt:"Discord application public key" group:"discord"`
+	DiscordApplicationID string `json:"DISCORD_APPLICATION_ID" section:"discord.application_id,omitempty" prompt:"Discord application ID for registering the command (optional)" init:"optional" group:"discord"`
+	DiscordBotToken      string `json:"DISCORD_BOT_TOKEN" section:"discord.bot_token,omitempty" prompt:"Discord bot token for registering the command (optional)" init:"secret,optional" group:"discord"`
+
+	// Comma-separated tokens of the Mattermost slash commands pointing at
+	// /mattermost/command, and of the Rocket.Chat outgoing webhooks pointing at
+	// /rocketchat/webhook. Each platform is disabled if empty.
+	MattermostTokens string `json:"MATTERMOST_TOKENS" section:"mattermost.tokens,omitempty" prompt:"Mattermost slash command tokens, comma-separated" init:"secret" group:"mattermost"`
+	RocketChatTokens string `json:"ROCKETCHAT_TOKENS" section:"rocketchat.tokens,omitempty" prompt:"Rocket.Chat outgoing webhook tokens, comma-separated" init:"secret" group:"rocketchat"`
+
+	// Alerts are the rules evaluated on each user's logs. If omitted, the
+	// DefaultAlertRules are used. Set to an empty list to disable alerts.
+	Alerts []AlertRule `json:"ALERTS" section:"alerts,omitempty"`
+
+	// Weekly digests are sent by the server on ReportDay (e.g. Monday) at
+	// ReportTime in the configured Area. The anonymised team digest is posted
+	// to ReportChannel if set. Leave ReportDay empty to disable.
+	ReportDay     string `json:"REPORT_DAY" section:"reports.day"`
+	ReportTime    string `json:"REPORT_TIME" section:"reports.time"`
+	ReportChannel string `json:"REPORT_CHANNEL" section:"reports.channel"`
+
+	// Timeouts of the HTTP server as durations, e.g. "10s". ShutdownTimeout
+	// is how long to wait for in-flight requests and queued jobs on shutdown.
+	ReadTimeout     string `json:"READ_TIMEOUT" section:"server.read_timeout,omitempty"`
+	WriteTimeout    string `json:"WRITE_TIMEOUT" section:"server.write_timeout,omitempty"`
+	IdleTimeout     string `json:"IDLE_TIMEOUT" section:"server.idle_timeout,omitempty"`
+	ShutdownTimeout string `json:"SHUTDOWN_TIMEOUT" section:"server.shutdown_timeout,omitempty"`
+
+	// TLS is terminated by the server with the TLSCert and TLSKey files, or
+	// with certificates obtained through ACME (e.g. Let's Encrypt) for the
+	// comma-separated ACMEDomains, cached in ACMECacheDir. Plain HTTP requests
+	// on RedirectAddr (":80" by default with ACME) are redirected to HTTPS.
+	TLSCert      string `json:"TLS_CERT" section:"tls.cert,omitempty"`
+	TLSKey       string `json:"TLS_KEY" section:"tls.key,omitempty"`
+	ACMEDomains  string `json:"ACME_DOMAINS" section:"tls.acme_domains,omitempty"`
+	ACMEEmail    string `json:"ACME_EMAIL" section:"tls.acme_email,omitempty"`
+	ACMECacheDir string `json:"ACME_CACHE_DIR" section:"tls.acme_cache_dir,omitempty"`
+	RedirectAddr string `json:"REDIRECT_ADDR" section:"tls.redirect_addr,omitempty"`
+
+	// Requests to /log are limited per user and per IP address, e.g. "10/1m"
+	// for 10 requests per minute, or "off". DailyLogLimit caps the number of
+	// logs stored per user and day, unlimited if empty. TrustedProxies are the
+	// comma-separated addresses or CIDR ranges of reverse proxies, whose
+	// X-Forwarded-For header gives the address of the client.
+	UserRateLimit  string `json:"USER_RATE_LIMIT" section:"limits.user_rate,omitempty"`
+	IPRateLimit    string `json:"IP_RATE_LIMIT" section:"limits.ip_rate,omitempty"`
+	DailyLogLimit  string `json:"DAILY_LOG_LIMIT" section:"limits.daily_logs,omitempty"`
+	TrustedProxies string `json:"TRUSTED_PROXIES" section:"limits.trusted_proxies,omitempty"`
+
+	// ContextFields is a comma-separated list of the Slack context stored with
+	// each log, e.g. "team_id,channel_id", or "all". Only the user ID is stored
+	// if empty, except for the team when installed into several workspaces.
+	ContextFields string `json:"CONTEXT_FIELDS" section:"privacy.context_fields,omitempty"`
+
+	// OTLPEndpoint is the OTLP/HTTP collector that traces are exported to,
+	// e.g. "http://localhost:4318". Tracing is disabled if empty.
+	OTLPEndpoint string `json:"OTLP_ENDPOINT" section:"tracing.otlp_endpoint,omitempty"`
+
+	// SecretsFile is an encrypted file of secrets, referred to as
+	// "secret:NAME". Its key is read from the BB_SECRETS_KEY env var.
+	SecretsFile string `json:"SECRETS_FILE" section:"secrets_file,omitempty"`
 
 	// This defines the API keys for accessing the Twitter API
 	// and get messages from the tiny-care bots
-	TwitterConsumerKey    string `json:"TWITTER_CONSUMER_KEY"`
-	TwitterConsumerSecret string `json:"TWITTER_CONSUMER_SECRET"`
-	TwitterAccessKey      string `json:"TWITTER_ACCESS_KEY"`
-	TwitterAccessSecret   string `json:"TWITTER_ACCESS_SECRET"`
+	TwitterConsumerKey    string `json:"TWITTER_CONSUMER_KEY" section:"twitter.consumer_key" prompt:"Twitter API Consumer Key" init:"secret" group:"twitter"`
+	TwitterConsumerSecret string `json:"TWITTER_CONSUMER_SECRET" section:"twitter.consumer_secret" prompt:"Twitter API Consumer Secret" init:"secret" group:"twitter"`
+	TwitterAccessKey      string `json:"TWITTER_ACCESS_KEY" section:"twitter.access_key" prompt:"Twitter API Access Key" init:"secret" group:"twitter"`
+	TwitterAccessSecret   string `json:"TWITTER_ACCESS_SECRET" section:"twitter.access_secret" prompt:"Twitter API Access Secret" init:"secret" group:"twitter"`
+}
+
+// ConfigOption describes a configuration key that `barometer init` asks for.
+type ConfigOption struct {
+	Key      string // Key in the configuration file, e.g. TABLE
+	Prompt   string
+	Default  string
+	Secret   bool
+	Optional bool
+	Group    string // Optional integration the key belongs to, e.g. slack
+
+	field string
+}
+
+// ConfigurationOptions returns the options of the Configuration that have a
+// prompt tag, in the order of the fields.
+func ConfigurationOptions() []ConfigOption {
+	opts := []ConfigOption{}
+	t := reflect.TypeOf(Configuration{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		o := ConfigOption{
+			Key:     f.Tag.Get("json"),
+			Prompt:  f.Tag.Get("prompt"),
+			Default: f.Tag.Get("default"),
+			Group:   f.Tag.Get("group"),
+			field:   f.Name,
+		}
+		for _, flag := range strings.Split(f.Tag.Get("init"), ",") {
+			switch flag {
+			case "secret":
+				o.Secret = true
+			case "optional":
+				o.Optional = true
+			}
+		}
+		if o.Prompt != "" || o.Secret {
+			opts = append(opts, o)
+		}
+	}
+	return opts
 }
 
 // WriteConfiguration creates a configuration file at a given output path.
+// The format is chosen from the file extension: .yaml/.yml for YAML, .toml for
+// TOML, and JSON otherwise.
 func (cfg *Configuration) WriteConfiguration(path string) error {
 
 	file, err := os.Create(path)
@@ -37,14 +176,214 @@ func (cfg *Configuration) WriteConfiguration(path string) error {
 	}
 	defer file.Close()
 
-	e := json.NewEncoder(file)
-	if err := e.Encode(cfg); err != nil {
-		log.WithFields(log.Fields{"err": err}).Error("json.NewEncoder.Encode")
+	switch configFormat(path) {
+	case formatYAML:
+		err = yaml.NewEncoder(file).Encode(cfg.sections())
+	case formatTOML:
+		err = toml.NewEncoder(file).Encode(cfg.sections())
+	default:
+		err = json.NewEncoder(file).Encode(cfg)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Encoder.Encode")
 		return err
 	}
 	return nil
 }
 
+// Supported formats of the configuration file
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configFormat returns the format of a configuration file from its extension.
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	default:
+		return formatJSON
+	}
+}
+
+// configSections is the layout of YAML and TOML configuration files, where
+// related settings are grouped into sections. It's a struct type built from
+// the section tags of the Configuration, with the keys at the top level
+// first and then each section in the order of its first field.
+var configSections = newSectionsLayout()
+
+// sectionsLayout is the struct type of the sectioned layout, and where each
+// field of the Configuration is found in it.
+type sectionsLayout struct {
+	typ    reflect.Type
+	fields []sectionField
+}
+
+// sectionField locates a field of the Configuration in configSections.
+type sectionField struct {
+	field   int // Index of the field in the Configuration
+	section int // Index of its section in configSections, or -1 at the top level
+	key     int // Index of its key in the section, or in configSections
+}
+
+// newSectionsLayout builds configSections. Lists are stored as pointers so
+// that an empty list, e.g. of ALERTS, is kept apart from an omitted one.
+func newSectionsLayout() sectionsLayout {
+	type key struct {
+		field int
+		name  string
+		tag   string
+	}
+	top := []key{}
+	sections := []string{}
+	keys := map[string][]key{}
+
+	t := reflect.TypeOf(Configuration{})
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("section")
+		if tag == "" {
+			continue
+		}
+		path := strings.SplitN(tag, ".", 2)
+		if len(path) == 1 {
+			top = append(top, key{i, t.Field(i).Name, tag})
+			continue
+		}
+		if _, ok := keys[path[0]]; !ok {
+			sections = append(sections, path[0])
+		}
+		keys[path[0]] = append(keys[path[0]], key{i, t.Field(i).Name, path[1]})
+	}
+
+	field := func(k key) reflect.StructField {
+		typ := t.Field(k.field).Type
+		if typ.Kind() == reflect.Slice {
+			typ = reflect.PtrTo(typ)
+		}
+		return reflect.StructField{Name: k.name, Type: typ, Tag: reflect.StructTag(fmt.Sprintf(`yaml:"%s" toml:"%s"`, k.tag, k.tag))}
+	}
+	layout := sectionsLayout{}
+	fields := []reflect.StructField{}
+	for _, k := range top {
+		layout.fields = append(layout.fields, sectionField{field: k.field, section: -1, key: len(fields)})
+		fields = append(fields, field(k))
+	}
+	for _, name := range sections {
+		section := []reflect.StructField{}
+		for _, k := range keys[name] {
+			layout.fields = append(layout.fields, sectionField{field: k.field, section: len(fields), key: len(section)})
+			section = append(section, field(k))
+		}
+		fields = append(fields, reflect.StructField{
+			Name: strings.ToUpper(name[:1]) + name[1:],
+			Type: reflect.StructOf(section),
+			Tag:  reflect.StructTag(fmt.Sprintf(`yaml:"%s" toml:"%s"`, name, name)),
+		})
+	}
+	layout.typ = reflect.StructOf(fields)
+	return layout
+}
+
+// value returns the field of configSections for a field of the Configuration.
+func (f sectionField) value(sections reflect.Value) reflect.Value {
+	if f.section >= 0 {
+		sections = sections.Field(f.section)
+	}
+	return sections.Field(f.key)
+}
+
+// sections converts the configuration into its sectioned layout, which is
+// returned as a pointer for encoding.
+func (cfg *Configuration) sections() interface{} {
+	sections := reflect.New(configSections.typ)
+	c := reflect.ValueOf(cfg).Elem()
+	for _, f := range configSections.fields {
+		v, dst := c.Field(f.field), f.value(sections.Elem())
+		if dst.Kind() != reflect.Ptr {
+			dst.Set(v)
+		} else if !v.IsNil() {
+			dst.Set(v.Addr())
+		}
+	}
+	return sections.Interface()
+}
+
+// configuration converts the sectioned layout, as returned by sections or
+// newSections, into a Configuration.
+func configuration(sections interface{}) *Configuration {
+	cfg := &Configuration{}
+	c := reflect.ValueOf(cfg).Elem()
+	for _, f := range configSections.fields {
+		v := f.value(reflect.ValueOf(sections).Elem())
+		if v.Kind() != reflect.Ptr {
+			c.Field(f.field).Set(v)
+		} else if !v.IsNil() {
+			c.Field(f.field).Set(v.Elem())
+		}
+	}
+	return cfg
+}
+
+// newSections returns an empty sectioned layout for decoding.
+func newSections() interface{} {
+	return reflect.New(configSections.typ).Interface()
+}
+
+// Default timeouts of the HTTP server
+const (
+	defaultReadTimeout     = 10 * time.Second
+	defaultWriteTimeout    = 10 * time.Second
+	defaultIdleTimeout     = 60 * time.Second
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+// duration parses a configured duration, falling back to a default if it is
+// empty or invalid.
+func duration(value string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+// alertRules returns the configured alert rules or the defaults.
+func (cfg *Configuration) alertRules() []AlertRule {
+	if cfg.Alerts == nil {
+		return DefaultAlertRules
+	}
+	return cfg.Alerts
+}
+
+// contextFields returns the set of ContextFields that may be stored.
+func (cfg *Configuration) contextFields() (map[string]bool, error) {
+	fields := make(map[string]bool)
+	if strings.TrimSpace(cfg.ContextFields) == "all" {
+		for _, f := range ContextFields {
+			fields[f] = true
+		}
+		return fields, nil
+	}
+	for _, f := range strings.Split(cfg.ContextFields, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		known := false
+		for _, k := range ContextFields {
+			known = known || k == f
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown field %q, use any of %s or all", f, strings.Join(ContextFields, ", "))
+		}
+		fields[f] = true
+	}
+	return fields, nil
+}
+
 func (cfg *Configuration) update(field string, value string) {
 	v := reflect.ValueOf(cfg).Elem().FieldByName(field)
 	if v.IsValid() {
@@ -52,44 +391,207 @@ func (cfg *Configuration) update(field string, value string) {
 	}
 }
 
+// envPrefix is prepended to a configuration key to get its environment
+// variable, e.g. BB_TABLE for TABLE.
+const envPrefix = "BB_"
+
+// configField returns the field of the configuration with the given key.
+func (cfg *Configuration) configField(key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("json") == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// ConfigurationKeys returns all keys of the configuration, e.g. TABLE and
+// SLACK_TOKEN.
+func ConfigurationKeys() []string {
+	keys := []string{}
+	t := reflect.TypeOf(Configuration{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("json"); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Value returns the value of a string configuration key as stored, i.e.
+// before secrets are resolved.
+func (cfg *Configuration) Value(key string) string {
+	v, ok := cfg.configField(key)
+	if !ok || v.Kind() != reflect.String {
+		return ""
+	}
+	return v.String()
+}
+
+// Merge overrides the configuration with the non-empty values of another.
+func (cfg *Configuration) Merge(other *Configuration) {
+	dst := reflect.ValueOf(cfg).Elem()
+	src := reflect.ValueOf(other).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		f := src.Field(i)
+		if f.Kind() == reflect.Slice && f.IsNil() || f.Kind() == reflect.String && f.String() == "" {
+			continue
+		}
+		dst.Field(i).Set(f)
+	}
+}
+
+// Override sets the configuration values of the given keys. Values are used
+// as-is, so secrets should not be base64-encoded. Lists such as REMINDERS and
+// ALERTS are given in JSON.
+func (cfg *Configuration) Override(values map[string]string) error {
+	for key, value := range values {
+		v, ok := cfg.configField(key)
+		if !ok {
+			return fmt.Errorf("unknown configuration key: %s", key)
+		}
+
+		if v.Kind() == reflect.String {
+			v.SetString(value)
+			continue
+		}
+		if err := json.Unmarshal([]byte(value), v.Addr().Interface()); err != nil {
+			return fmt.Errorf("cannot parse %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// EnvOverrides returns the configuration values set through BB_* environment
+// variables, given in the "key=value" form of os.Environ. Empty variables are
+// treated as unset.
+func EnvOverrides(environ []string) map[string]string {
+	keys := map[string]bool{}
+	for _, key := range ConfigurationKeys() {
+		keys[key] = true
+	}
+
+	values := map[string]string{}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[1] == "" || !strings.HasPrefix(parts[0], envPrefix) {
+			continue
+		}
+		if key := strings.TrimPrefix(parts[0], envPrefix); keys[key] {
+			values[key] = parts[1]
+		}
+	}
+	return values
+}
+
+// LoadConfiguration builds the configuration for running the server. Values
+// are taken, from lowest to highest precedence, from the configuration file
+// at path (skipped if empty), the BB_* environment variables in environ, and
+// the overrides, usually from command-line flags. Nothing is written to disk.
+func LoadConfiguration(path string, environ []string, overrides map[string]string) (*Configuration, error) {
+	lookup := environLookup(environ)
+	config := &Configuration{}
+	if path != "" {
+		var err error
+		if config, err = readConfiguration(path); err != nil {
+			return nil, err
+		}
+		if err := config.decodeSecrets(config.secretSources(lookup)); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("Configuration.decodeSecrets")
+			return nil, err
+		}
+	}
+
+	if err := config.Override(EnvOverrides(environ)); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Configuration.Override")
+		return nil, err
+	}
+	if err := config.Override(overrides); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Configuration.Override")
+		return nil, err
+	}
+
+	if err := config.resolveSecrets(config.secretSources(lookup)); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Configuration.resolveSecrets")
+		return nil, err
+	}
+	return config, nil
+}
+
+// environLookup looks up variables in the "key=value" form of os.Environ.
+func environLookup(environ []string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		for _, kv := range environ {
+			if strings.HasPrefix(kv, name+"=") {
+				return strings.TrimPrefix(kv, name+"="), true
+			}
+		}
+		return "", false
+	}
+}
+
+// ReadRawConfiguration reads the configuration file as stored, without
+// decoding or resolving its secrets. This is useful for editing the file.
+func ReadRawConfiguration(path string) (*Configuration, error) {
+	return readConfiguration(path)
+}
+
 // ReadConfiguration reads the configuration file and returns an instance
-// of a Configuration.
+// of a Configuration. YAML (.yaml/.yml) and TOML (.toml) files are read by
+// extension and group settings into sections; other files are read as JSON.
+//
+// Secrets can be references that are resolved when reading: "env:NAME" for
+// environment variables, "file:///path" for files such as Docker secrets,
+// and "secret:NAME" for the encrypted SecretsFile. Other secrets are
+// base64-decoded for backward compatibility.
 func ReadConfiguration(path string) (*Configuration, error) {
-
-	// Open configuration file
-	file, err := os.Open(path)
+	config, err := readConfiguration(path)
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Error("os.Open")
 		return nil, err
 	}
 
-	// Create a decoder from the file
-	d := json.NewDecoder(file)
-	config := &Configuration{}
-	if err = d.Decode(config); err != nil {
-		log.WithFields(log.Fields{"err": err}).Error("json.NewDecoder.Decode")
+	sources := config.secretSources(os.LookupEnv)
+	if err := config.decodeSecrets(sources); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Configuration.decodeSecrets")
 		return nil, err
 	}
+	if err := config.resolveSecrets(sources); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Configuration.resolveSecrets")
+		return nil, err
+	}
+	return config, nil
+}
+
+// readConfiguration reads the configuration file as-is.
+func readConfiguration(path string) (*Configuration, error) {
 
-	// Decode secrets
-	secretFields := []string{
-		"Token",
-		"TwitterConsumerKey",
-		"TwitterConsumerSecret",
-		"TwitterAccessKey",
-		"TwitterAccessSecret",
+	// Open configuration file
+	file, err := os.Open(path)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("os.Open")
+		return nil, err
 	}
 
-	for _, field := range secretFields {
-		v := reflect.ValueOf(*config)
-		enc := v.FieldByName(field).String()
-		dec, err := base64.StdEncoding.DecodeString(enc)
-		if err != nil {
-			log.WithFields(log.Fields{"err": err}).Error("base64.StdEncoding.DecodeString")
-			return nil, err
-		}
+	defer file.Close()
 
-		config.update(field, string(dec))
+	// Decode the file according to its format
+	config := &Configuration{}
+	switch configFormat(path) {
+	case formatYAML:
+		sections := newSections()
+		err = yaml.NewDecoder(file).Decode(sections)
+		config = configuration(sections)
+	case formatTOML:
+		sections := newSections()
+		_, err = toml.DecodeReader(file, sections)
+		config = configuration(sections)
+	default:
+		err = json.NewDecoder(file).Decode(config)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Decoder.Decode")
+		return nil, err
 	}
 	return config, nil
 }
@@ -5,8 +5,12 @@
 package pkg
 
 import (
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	log "github.com/sirupsen/logrus"
@@ -28,6 +32,18 @@ func TestConfiguration_WriteConfiguration(t *testing.T) {
 			arg:     "test_file.json",
 			wantErr: false,
 		},
+		{
+			name:    "yaml file exists after creation",
+			fields:  fields{Table: "test-table", Token: "test-token", Area: "test-area"},
+			arg:     "test_file.yaml",
+			wantErr: false,
+		},
+		{
+			name:    "toml file exists after creation",
+			fields:  fields{Table: "test-table", Token: "test-token", Area: "test-area"},
+			arg:     "test_file.toml",
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -62,6 +78,24 @@ func TestReadConfiguration(t *testing.T) {
 			want:    &Configuration{Table: "bq://test-table", Token: "ZK[VPIHE9E2CIMAz0QUE", Area: "Asia/Manila"},
 			wantErr: false,
 		},
+		{
+			name:    "happy path read yaml config",
+			arg:     "testdata/test_happy_path_read_config.yaml",
+			want:    &Configuration{Table: "bq://test-table", Area: "Asia/Manila", Reminders: []Reminder{{UserID: "U1", Time: "09:00"}}},
+			wantErr: false,
+		},
+		{
+			name:    "happy path read toml config",
+			arg:     "testdata/test_happy_path_read_config.toml",
+			want:    &Configuration{Table: "bq://test-table", Area: "Asia/Manila", Reminders: []Reminder{{UserID: "U1", Time: "09:00"}}},
+			wantErr: false,
+		},
+		{
+			name:    "faulty yaml config file",
+			arg:     "testdata/test_faulty_config_file.yaml",
+			want:    &Configuration{},
+			wantErr: true,
+		},
 		{
 			name:    "config does not exist",
 			arg:     "testdata/does_not_exist_config.json",
@@ -84,16 +118,175 @@ func TestReadConfiguration(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			_, err := ReadConfiguration(tt.arg)
+			got, err := ReadConfiguration(tt.arg)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ReadConfiguration() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if err == nil && (got.Table != tt.want.Table || got.Area != tt.want.Area || !reflect.DeepEqual(got.Reminders, tt.want.Reminders)) {
+				t.Errorf("ReadConfiguration() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguration_roundTrip(t *testing.T) {
+	// Every key has a place in the sections of YAML and TOML files
+	if got, want := len(configSections.fields), reflect.TypeOf(Configuration{}).NumField(); got != want {
+		t.Errorf("configSections has %d of the %d keys, is a section tag missing?", got, want)
+	}
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Configuration{
+		Table:     "postgres://db",
+		Token:     base64.StdEncoding.EncodeToString([]byte("slack-token")),
+		Area:      "Asia/Manila",
+		Reminders: []Reminder{{UserID: "U1", Time: "09:00", Area: "Europe/Berlin"}},
+		Alerts:    []AlertRule{},
+		ReportDay: "Monday",
+
+		SlackClientID: "123.456",
+		TLSCert:       "cert.pem",
+		OTLPEndpoint:  "http://localhost:4318",
+		SecretsFile:   "secrets.enc",
+	}
+	want := *cfg
+	want.Token = "slack-token"
+
+	for _, name := range []string{"config.json", "config.yaml", "config.yml", "config.toml"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := cfg.WriteConfiguration(path); err != nil {
+				t.Fatalf("Configuration.WriteConfiguration() error = %v", err)
+			}
+			got, err := ReadConfiguration(path)
+			if err != nil {
+				t.Fatalf("ReadConfiguration() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("ReadConfiguration() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfiguration(t *testing.T) {
+	file := "testdata/test_happy_path_read_config.json"
+	tests := []struct {
+		name      string
+		path      string
+		environ   []string
+		overrides map[string]string
+		want      *Configuration
+		wantErr   bool
+	}{
+		{
+			name: "file only",
+			path: file,
+			want: &Configuration{Table: "bq://test-table", Area: "Asia/Manila"},
+		},
+		{
+			name:    "environment variables override the file",
+			path:    file,
+			environ: []string{"BB_TABLE=postgres://db", "BB_SLACK_TOKEN=plain-token", "BB_AREA=", "HOME=/root"},
+			want:    &Configuration{Table: "postgres://db", Token: "plain-token", Area: "Asia/Manila"},
+		},
+		{
+			name:      "flags override environment variables",
+			path:      file,
+			environ:   []string{"BB_TABLE=postgres://db"},
+			overrides: map[string]string{"TABLE": "memory://"},
+			want:      &Configuration{Table: "memory://", Area: "Asia/Manila"},
+		},
+		{
+			name:    "no configuration file",
+			environ: []string{"BB_TABLE=memory://", "BB_REMINDERS=[{\"USER_ID\":\"U1\",\"TIME\":\"09:00\"}]"},
+			want:    &Configuration{Table: "memory://", Reminders: []Reminder{{UserID: "U1", Time: "09:00"}}},
+		},
+		{
+			name:    "malformed list",
+			environ: []string{"BB_ALERTS=not-json"},
+			wantErr: true,
+		},
+		{
+			name:      "unknown key",
+			overrides: map[string]string{"PROJECT_ID": "test-project"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadConfiguration(tt.path, tt.environ, tt.overrides)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfiguration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			// Secrets from the file are compared only if overridden
+			if tt.want.Token == "" {
+				tt.want.Token = got.Token
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadConfiguration() = %+v, want %+v", got, tt.want)
+			}
 		})
 	}
 }
 
+func TestConfigurationOptions(t *testing.T) {
+	opts := map[string]ConfigOption{}
+	for _, o := range ConfigurationOptions() {
+		opts[o.Key] = o
+	}
+
+	if o := opts["TABLE"]; o.Prompt == "" || o.Default == "" || o.Secret || o.Optional {
+		t.Errorf("ConfigurationOptions() TABLE = %+v", o)
+	}
+	if o := opts["SLACK_TOKEN"]; !o.Secret || o.Optional || o.Group != "slack" {
+		t.Errorf("ConfigurationOptions() SLACK_TOKEN = %+v", o)
+	}
+	if o := opts["SLACK_BOT_TOKEN"]; !o.Secret || !o.Optional || o.Group != "slack" {
+		t.Errorf("ConfigurationOptions() SLACK_BOT_TOKEN = %+v", o)
+	}
+	if o := opts["TWITTER_ACCESS_SECRET"]; !o.Secret || o.Group != "twitter" {
+		t.Errorf("ConfigurationOptions() TWITTER_ACCESS_SECRET = %+v", o)
+	}
+	for key, o := range opts {
+		if o.Prompt == "" {
+			t.Errorf("ConfigurationOptions() %s has no prompt", key)
+		}
+	}
+	if _, ok := opts["REMINDERS"]; ok {
+		t.Errorf("ConfigurationOptions() includes REMINDERS, want only prompted keys")
+	}
+}
+
+func TestConfiguration_Merge(t *testing.T) {
+	cfg := &Configuration{Table: "memory://", Area: "Asia/Manila", Reminders: []Reminder{{UserID: "U1"}}}
+	cfg.Merge(&Configuration{Area: "Europe/Berlin", Token: "token", Alerts: []AlertRule{}})
+
+	want := &Configuration{
+		Table:     "memory://",
+		Token:     "token",
+		Area:      "Europe/Berlin",
+		Reminders: []Reminder{{UserID: "U1"}},
+		Alerts:    []AlertRule{},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Configuration.Merge() = %+v, want %+v", cfg, want)
+	}
+	if got := cfg.Value("AREA"); got != "Europe/Berlin" {
+		t.Errorf("Configuration.Value() = %s, want Europe/Berlin", got)
+	}
+}
+
 func ExampleReadConfiguration() {
 	// Read config from a file
 	config, err := ReadConfiguration("path/to/config.json")
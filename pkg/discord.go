@@ -0,0 +1,261 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const discordAPIURL = "https://discord.com/api/v10"
+
+// Types of Discord interactions and of the responses to them. See
+// https://discord.com/developers/docs/interactions/receiving-and-responding
+const (
+	discordPing               = 1
+	discordApplicationCommand = 2
+	discordPong               = 1
+	discordChannelMessage     = 4
+	discordEphemeral          = 1 << 6
+)
+
+// discordCommand is the /barometer application command. Its options are
+// joined into the text of a log, e.g. "3 long review day".
+var discordCommand = map[string]interface{}{
+	"name":        "barometer",
+	"description": "Log your mood",
+	"options": []map[string]interface{}{
+		{"type": 4, "name": "mood", "description": "Your mood-level, from 1 (burnt out) to 5 (great)", "required": true, "min_value": 1, "max_value": 5},
+		{"type": 3, "name": "notes", "description": "What's on your mind?"},
+	},
+}
+
+// DiscordTransport receives logs through the /barometer command of a Discord
+// application, whose interactions endpoint is /discord/interactions.
+type DiscordTransport struct {
+	PublicKey ed25519.PublicKey
+}
+
+// discordInteraction is the part of an interaction that the barometer needs.
+type discordInteraction struct {
+	Type      int    `json:"type"`
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	Member    *struct {
+		User discordUser `json:"user"`
+	} `json:"member"`
+	User *discordUser `json:"user"`
+	Data struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string      `json:"name"`
+			Value interface{} `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// NewDiscordTransport creates a DiscordTransport from the hex-encoded public
+// key of the application.
+func NewDiscordTransport(publicKey string) (*DiscordTransport, error) {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%q is not a hex-encoded Ed25519 public key", publicKey)
+	}
+	return &DiscordTransport{PublicKey: ed25519.PublicKey(key)}, nil
+}
+
+// Verify checks the Ed25519 signature of the timestamp and body.
+func (t *DiscordTransport) Verify(r *http.Request, body []byte) error {
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("missing or malformed signature")
+	}
+	msg := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	if !ed25519.Verify(t.PublicKey, msg, sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// Acknowledge answers the pings that Discord sends to check the endpoint.
+func (t *DiscordTransport) Acknowledge(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	i := discordInteraction{}
+	if err := json.Unmarshal(body, &i); err != nil || i.Type != discordPing {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"type": discordPong})
+	return true
+}
+
+// Parse extracts the log from a /barometer command.
+func (t *DiscordTransport) Parse(r *http.Request, body []byte) (*Submission, error) {
+	i := discordInteraction{}
+	if err := json.Unmarshal(body, &i); err != nil {
+		return nil, err
+	}
+	if i.Type != discordApplicationCommand || i.Data.Name != discordCommand["name"] {
+		return nil, fmt.Errorf("unexpected interaction %d %q", i.Type, i.Data.Name)
+	}
+
+	// The user is a member if the command was used in a server, and only a
+	// user in direct messages
+	user := i.User
+	if i.Member != nil {
+		user = &i.Member.User
+	}
+	if user == nil || user.ID == "" {
+		return nil, fmt.Errorf("missing user")
+	}
+
+	var mood, notes string
+	for _, o := range i.Data.Options {
+		switch o.Name {
+		case "mood":
+			mood = fmt.Sprint(o.Value)
+		case "notes":
+			notes = fmt.Sprint(o.Value)
+		}
+	}
+	return &Submission{
+		From: LogContext{
+			UserID:    "discord:" + user.ID,
+			UserName:  user.Username,
+			TeamID:    i.GuildID,
+			ChannelID: i.ChannelID,
+		},
+		Text: strings.TrimSpace(mood + " " + notes),
+	}, nil
+}
+
+// Reply responds with a message whose attachments are rendered as embeds.
+// Ephemeral messages are only shown to the user, as in Slack.
+func (t *DiscordTransport) Reply(w http.ResponseWriter, msg *Message) error {
+	type image struct {
+		URL string `json:"url"`
+	}
+	type embed struct {
+		Title       string `json:"title,omitempty"`
+		URL         string `json:"url,omitempty"`
+		Description string `json:"description,omitempty"`
+		Color       int64  `json:"color,omitempty"`
+		Image       *image `json:"image,omitempty"`
+	}
+	type data struct {
+		Content string  `json:"content"`
+		Embeds  []embed `json:"embeds,omitempty"`
+		Flags   int     `json:"flags,omitempty"`
+	}
+	type response struct {
+		Type int  `json:"type"`
+		Data data `json:"data"`
+	}
+
+	d := data{Content: msg.Text}
+	if msg.ResponseType == "ephemeral" {
+		d.Flags = discordEphemeral
+	}
+	for _, a := range msg.Attachments {
+		e := embed{Title: a.Title, URL: a.TitleLink, Description: a.Text}
+		e.Color, _ = strconv.ParseInt(strings.TrimPrefix(a.Color, "#"), 16, 32)
+		if a.ImageURL != "" {
+			e.Image = &image{URL: a.ImageURL}
+		}
+		d.Embeds = append(d.Embeds, e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response{Type: discordChannelMessage, Data: d})
+}
+
+// ReplyError responds to a failed log with a message, since Discord doesn't
+// show the body of failed requests.
+func (t *DiscordTransport) ReplyError(w http.ResponseWriter, err error) error {
+	return t.Reply(w, errorReply(err))
+}
+
+// discord returns the Discord transport, or nil if it's not configured. It
+// must be called on a snapshot of the Server.
+func (s *Server) discord() Transport {
+	if s.Config.DiscordPublicKey == "" {
+		return nil
+	}
+	t, err := NewDiscordTransport(s.Config.DiscordPublicKey)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("NewDiscordTransport")
+		return nil
+	}
+	return t
+}
+
+// DiscordClient is a minimal client for the Discord API methods that the
+// barometer needs. The BaseURL can be pointed to a local stand-in for testing.
+type DiscordClient struct {
+	ApplicationID string
+	Token         string // Bot token of the application
+	BaseURL       string
+	HTTPClient    *http.Client
+}
+
+// NewDiscordClient creates a DiscordClient that talks to the Discord API.
+func NewDiscordClient(applicationID, token string) *DiscordClient {
+	return &DiscordClient{
+		ApplicationID: applicationID,
+		Token:         token,
+		BaseURL:       discordAPIURL,
+		HTTPClient:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// RegisterCommand creates or updates the /barometer command. Commands of a
+// guild (server) are available immediately, while global commands, used if
+// guildID is empty, may take up to an hour to show up.
+func (c *DiscordClient) RegisterCommand(guildID string) error {
+	path := fmt.Sprintf("%s/applications/%s/commands", c.BaseURL, c.ApplicationID)
+	if guildID != "" {
+		path = fmt.Sprintf("%s/applications/%s/guilds/%s/commands", c.BaseURL, c.ApplicationID, guildID)
+	}
+	body, err := json.Marshal(discordCommand)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bot %s", c.Token))
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	log.WithFields(log.Fields{"guild": guildID}).Trace("registering Discord command")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error in registering the command: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("registering the command returned status %s", resp.Status)
+	}
+	return nil
+}
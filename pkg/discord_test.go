@@ -0,0 +1,240 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// discordKey returns a key pair from a fixed seed, so that tests are
+// reproducible.
+func discordKey() (string, ed25519.PrivateKey) {
+	priv := ed25519.NewKeyFromSeed([]byte(strings.Repeat("s", ed25519.SeedSize)))
+	return hex.EncodeToString(priv.Public().(ed25519.PublicKey)), priv
+}
+
+// discordRequest creates an interaction request signed the way Discord does.
+func discordRequest(priv ed25519.PrivateKey, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/discord/interactions", strings.NewReader(body))
+	req.Header.Set("X-Signature-Timestamp", "1588561321")
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(priv, []byte("1588561321"+body))))
+	return req
+}
+
+const discordCommandInteraction = `{
+	"type": 2,
+	"guild_id": "G1",
+	"channel_id": "C1",
+	"member": {"user": {"id": "80351110224678912", "username": "jane"}},
+	"data": {"name": "barometer", "options": [{"name": "mood", "type": 4, "value": 3}, {"name": "notes", "type": 3, "value": "long review day"}]}
+}`
+
+func TestNewDiscordTransport(t *testing.T) {
+	public, _ := discordKey()
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "public key", key: public, wantErr: false},
+		{name: "too short", key: "abcd", wantErr: true},
+		{name: "not hex", key: strings.Repeat("x", 64), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDiscordTransport(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("NewDiscordTransport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiscordTransport_Verify(t *testing.T) {
+	public, priv := discordKey()
+	other := ed25519.NewKeyFromSeed([]byte(strings.Repeat("o", ed25519.SeedSize)))
+	tests := []struct {
+		name    string
+		req     func() *http.Request
+		wantErr bool
+	}{
+		{name: "signed", req: func() *http.Request { return discordRequest(priv, discordCommandInteraction) }, wantErr: false},
+		{name: "other key", req: func() *http.Request { return discordRequest(other, discordCommandInteraction) }, wantErr: true},
+		{
+			name: "other timestamp",
+			req: func() *http.Request {
+				req := discordRequest(priv, discordCommandInteraction)
+				req.Header.Set("X-Signature-Timestamp", "1588561322")
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing signature",
+			req: func() *http.Request {
+				req := discordRequest(priv, discordCommandInteraction)
+				req.Header.Del("X-Signature-Ed25519")
+				return req
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, _ := NewDiscordTransport(public)
+			if err := tr.Verify(tt.req(), []byte(discordCommandInteraction)); (err != nil) != tt.wantErr {
+				t.Errorf("DiscordTransport.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiscordTransport_Parse(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantUser string
+		wantText string
+		wantErr  bool
+	}{
+		{name: "command in a server", body: discordCommandInteraction, wantUser: "discord:80351110224678912", wantText: "3 long review day"},
+		{
+			name:     "direct message without notes",
+			body:     `{"type": 2, "user": {"id": "U1"}, "data": {"name": "barometer", "options": [{"name": "mood", "value": 4}]}}`,
+			wantUser: "discord:U1",
+			wantText: "4",
+		},
+		{name: "other command", body: `{"type": 2, "user": {"id": "U1"}, "data": {"name": "weather"}}`, wantErr: true},
+		{name: "missing user", body: `{"type": 2, "data": {"name": "barometer"}}`, wantErr: true},
+		{name: "malformed", body: `{"type": `, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &DiscordTransport{}
+			got, err := tr.Parse(httptest.NewRequest(http.MethodPost, "/discord/interactions", nil), []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DiscordTransport.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.From.UserID != tt.wantUser || got.Text != tt.wantText {
+				t.Errorf("DiscordTransport.Parse() = %q %q, want %q %q", got.From.UserID, got.Text, tt.wantUser, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestDiscordTransport_Reply(t *testing.T) {
+	msg := &Message{
+		ResponseType: "ephemeral",
+		Text:         "Gotcha",
+		Attachments:  []Attachment{{Color: "#ef4631", Title: "A tweet", TitleLink: "https://twitter.com", Text: "Drink some water", ImageURL: "https://example.com/a.png"}},
+	}
+	rec := httptest.NewRecorder()
+	if err := (&DiscordTransport{}).Reply(rec, msg); err != nil {
+		t.Fatalf("DiscordTransport.Reply() error = %v", err)
+	}
+
+	got := struct {
+		Type int `json:"type"`
+		Data struct {
+			Content string `json:"content"`
+			Flags   int    `json:"flags"`
+			Embeds  []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+				Color       int    `json:"color"`
+				Image       struct {
+					URL string `json:"url"`
+				} `json:"image"`
+			} `json:"embeds"`
+		} `json:"data"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("DiscordTransport.Reply() returned invalid JSON: %v", err)
+	}
+	if got.Type != discordChannelMessage || got.Data.Content != "Gotcha" || got.Data.Flags != discordEphemeral || len(got.Data.Embeds) != 1 {
+		t.Fatalf("DiscordTransport.Reply() = %+v", got)
+	}
+	e := got.Data.Embeds[0]
+	if e.Title != "A tweet" || e.URL != "https://twitter.com" || e.Description != "Drink some water" || e.Color != 0xef4631 || e.Image.URL != "https://example.com/a.png" {
+		t.Errorf("DiscordTransport.Reply() embed = %+v", e)
+	}
+}
+
+func TestServer_handleDiscord(t *testing.T) {
+	public, priv := discordKey()
+	tests := []struct {
+		name      string
+		body      string
+		wantType  int
+		wantItems int
+	}{
+		{name: "ping", body: `{"type": 1}`, wantType: discordPong},
+		{name: "command", body: discordCommandInteraction, wantType: discordChannelMessage, wantItems: 1},
+		{name: "invalid mood", body: strings.Replace(discordCommandInteraction, `"value": 3`, `"value": 9`, 1), wantType: discordChannelMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memory{}
+			s := &Server{Config: &Configuration{Area: "Asia/Manila", DiscordPublicKey: public}, database: db}
+			rec := httptest.NewRecorder()
+			s.handleTransport("/discord/interactions", s.discord)(rec, discordRequest(priv, tt.body))
+
+			got := struct {
+				Type int `json:"type"`
+			}{}
+			if err := json.NewDecoder(rec.Body).Decode(&got); rec.Code != http.StatusOK || err != nil || got.Type != tt.wantType {
+				t.Fatalf("handleDiscord() status = %d, type = %d, want %d", rec.Code, got.Type, tt.wantType)
+			}
+			items, _ := db.QueryDB(Query{})
+			if len(items) != tt.wantItems {
+				t.Fatalf("handleDiscord() stored %d logs, want %d", len(items), tt.wantItems)
+			}
+			if tt.wantItems > 0 && (items[0].UserID != "discord:80351110224678912" || items[0].Measure != 3) {
+				t.Errorf("handleDiscord() stored %+v", items[0])
+			}
+		})
+	}
+}
+
+func TestDiscordClient_RegisterCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		guildID  string
+		wantPath string
+	}{
+		{name: "global", guildID: "", wantPath: "/applications/A1/commands"},
+		{name: "guild", guildID: "G1", wantPath: "/applications/A1/guilds/G1/commands"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, auth, body string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				path, auth, body = r.URL.Path, r.Header.Get("Authorization"), string(b)
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer ts.Close()
+
+			c := NewDiscordClient("A1", "bot-token")
+			c.BaseURL = ts.URL
+			if err := c.RegisterCommand(tt.guildID); err != nil {
+				t.Fatalf("DiscordClient.RegisterCommand() error = %v", err)
+			}
+			if path != tt.wantPath || auth != "Bot bot-token" || !strings.Contains(body, `"name":"barometer"`) {
+				t.Errorf("DiscordClient.RegisterCommand() sent %s %q %s", path, auth, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// readyTimeout bounds how long the readiness check waits for the database.
+const readyTimeout = 5 * time.Second
+
+// healthResponse is the body returned by the health and readiness checks.
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// handleHealthz reports that the server is alive. It doesn't check any
+// dependencies, so a failing database doesn't restart the server.
+func (s *Server) handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger(r.Context()).WithFields(log.Fields{"path": "/healthz"}).Trace("received request")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&healthResponse{Status: "ok"})
+	}
+}
+
+// handleReadyz reports whether the server can handle requests, i.e. the
+// database can be reached and the background queue accepts jobs. It responds
+// with 503 Service Unavailable otherwise.
+func (s *Server) handleReadyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger(r.Context()).WithFields(log.Fields{"path": "/readyz"}).Trace("received request")
+		w.Header().Set("Content-Type", "application/json")
+
+		res := healthResponse{Status: "ok", Checks: map[string]string{}}
+		for name, err := range map[string]error{
+			"database": pingDB(s.database),
+			"queue":    queueHealthy(s.queue),
+		} {
+			res.Checks[name] = "ok"
+			if err != nil {
+				res.Status = "unavailable"
+				res.Checks[name] = err.Error()
+				logger(r.Context()).WithFields(log.Fields{"err": err, "check": name}).Warn("handleReadyz")
+			}
+		}
+
+		if res.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(&res)
+	}
+}
+
+// handleVersion returns the version of the server.
+func (s *Server) handleVersion() http.HandlerFunc {
+	type response struct {
+		Version   string `json:"version"`
+		GoVersion string `json:"go_version"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger(r.Context()).WithFields(log.Fields{"path": "/version"}).Trace("received request")
+		w.Header().Set("Content-Type", "application/json")
+		res := response{Version: s.Version, GoVersion: runtime.Version()}
+		if res.Version == "" {
+			res.Version = "unknown"
+		}
+		json.NewEncoder(w).Encode(&res)
+	}
+}
+
+// pingDB checks that the database can be reached, if it supports it.
+func pingDB(db DBInserter) error {
+	if db == nil {
+		return errors.New("database is not configured")
+	}
+	p, ok := db.(DBPinger)
+	if !ok {
+		return nil
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- p.PingDB() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(readyTimeout):
+		return errors.New("timed out waiting for the database")
+	}
+}
+
+// queueHealthy checks that the background queue accepts jobs.
+func queueHealthy(q *Queue) error {
+	if q == nil {
+		return errors.New("queue is not running")
+	}
+	return q.Healthy()
+}
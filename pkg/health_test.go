@@ -0,0 +1,94 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// unreachableDB is a database that cannot be reached.
+type unreachableDB struct{ memory }
+
+func (t *unreachableDB) PingDB() error {
+	return errors.New("connection refused")
+}
+
+func TestServer_handleReadyz(t *testing.T) {
+	closed := NewQueue(1, 1)
+	closed.Close()
+	tests := []struct {
+		name       string
+		database   DBInserter
+		queue      *Queue
+		wantStatus int
+		wantFailed string
+	}{
+		{name: "happy path", database: &memory{}, queue: NewQueue(1, 1), wantStatus: http.StatusOK},
+		{name: "unreachable database", database: &unreachableDB{}, queue: NewQueue(1, 1), wantStatus: http.StatusServiceUnavailable, wantFailed: "database"},
+		{name: "not started", queue: NewQueue(1, 1), wantStatus: http.StatusServiceUnavailable, wantFailed: "database"},
+		{name: "closed queue", database: &memory{}, queue: closed, wantStatus: http.StatusServiceUnavailable, wantFailed: "queue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{database: tt.database, queue: tt.queue}
+			rec := httptest.NewRecorder()
+			s.handleReadyz()(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("handleReadyz() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var res healthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("cannot decode response: %v", err)
+			}
+			for name, status := range res.Checks {
+				if failed := status != "ok"; failed != (name == tt.wantFailed) {
+					t.Errorf("handleReadyz() check %s = %q", name, status)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_handleHealthz(t *testing.T) {
+	// The liveness check doesn't depend on the database
+	s := &Server{database: &unreachableDB{}}
+	rec := httptest.NewRecorder()
+	s.handleHealthz()(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("handleHealthz() status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestServer_handleVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{version: "v1.0.0", want: "v1.0.0"},
+		{version: "", want: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			s := &Server{Version: tt.version}
+			rec := httptest.NewRecorder()
+			s.handleVersion()(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+			var res struct {
+				Version string `json:"version"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("cannot decode response: %v", err)
+			}
+			if res.Version != tt.want {
+				t.Errorf("handleVersion() version = %q, want %q", res.Version, tt.want)
+			}
+		})
+	}
+}
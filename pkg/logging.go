@@ -0,0 +1,125 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader carries the ID of a request. The ID is taken from the
+// request if set, so that it can be correlated with a proxy's logs, and is
+// always returned in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// redacted replaces sensitive values in the logs.
+const redacted = "[REDACTED]"
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+var (
+	// requestIDPattern rejects request IDs that could forge log lines.
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+	// tokenPattern matches Slack tokens, e.g. xoxb-1234-abcd.
+	tokenPattern = regexp.MustCompile(`xox[a-z]-[A-Za-z0-9-]+`)
+
+	// sensitiveFields are log fields that hold secrets or personal data.
+	sensitiveFields = map[string]bool{
+		"token":   true,
+		"user":    true,
+		"user_id": true,
+		"notes":   true,
+		"text":    true,
+	}
+)
+
+// ConfigureLogging sets the log format, either "text" or "json". If redact
+// is true, tokens, notes and user IDs are removed from all log entries.
+func ConfigureLogging(format string, redact bool) error {
+	switch format {
+	case "", "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, must be text or json", format)
+	}
+
+	hooks := make(log.LevelHooks)
+	if redact {
+		hooks.Add(RedactionHook{})
+	}
+	log.StandardLogger().ReplaceHooks(hooks)
+	return nil
+}
+
+// RedactionHook removes secrets and personal data from log entries. The
+// values of sensitive fields are replaced entirely, while anything that
+// looks like a Slack token is removed from the message and other fields.
+type RedactionHook struct{}
+
+// Levels returns all log levels.
+func (RedactionHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire redacts the entry before it is written.
+func (RedactionHook) Fire(entry *log.Entry) error {
+	// Entries may share their fields, so they're copied instead of changed
+	data := make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		switch value := v.(type) {
+		case string:
+			v = tokenPattern.ReplaceAllString(value, redacted)
+		case error:
+			v = tokenPattern.ReplaceAllString(value.Error(), redacted)
+		}
+		if sensitiveFields[k] {
+			v = redacted
+		}
+		data[k] = v
+	}
+	entry.Data = data
+	entry.Message = tokenPattern.ReplaceAllString(entry.Message, redacted)
+	return nil
+}
+
+// withRequestID assigns an ID to each request, which is logged along with
+// everything that happens while handling the request.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// logger returns the logger for a request, which includes its request ID.
+func logger(ctx context.Context) *log.Entry {
+	if id, ok := ctx.Value(requestIDKey).(string); ok {
+		return log.WithField("request_id", id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
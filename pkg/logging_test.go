@@ -0,0 +1,119 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRedactionHook(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   log.Fields
+		message  string
+		want     []string
+		wantGone []string
+	}{
+		{
+			name:     "sensitive fields",
+			fields:   log.Fields{"user": "U1234", "notes": "long review day", "token": "abcdef", "path": "/log"},
+			message:  "received request",
+			want:     []string{`"user":"[REDACTED]"`, `"notes":"[REDACTED]"`, `"path":"/log"`},
+			wantGone: []string{"U1234", "long review day", "abcdef"},
+		},
+		{
+			name:     "slack tokens in errors and messages",
+			fields:   log.Fields{"err": errors.New("invalid_auth for xoxb-123-abc")},
+			message:  "using token xoxp-456-def",
+			want:     []string{"invalid_auth for [REDACTED]"},
+			wantGone: []string{"xoxb-123-abc", "xoxp-456-def"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := log.New()
+			l.Out = &buf
+			l.Formatter = &log.JSONFormatter{}
+			l.AddHook(RedactionHook{})
+
+			entry := l.WithFields(tt.fields)
+			entry.Info(tt.message)
+
+			for _, w := range tt.want {
+				if !strings.Contains(buf.String(), w) {
+					t.Errorf("RedactionHook.Fire() = %s, want %s", buf.String(), w)
+				}
+			}
+			for _, w := range tt.wantGone {
+				if strings.Contains(buf.String(), w) {
+					t.Errorf("RedactionHook.Fire() = %s, must not contain %s", buf.String(), w)
+				}
+			}
+			if entry.Data["user"] != nil && entry.Data["user"] != tt.fields["user"] {
+				t.Errorf("RedactionHook.Fire() changed the fields of the entry")
+			}
+		})
+	}
+}
+
+func TestConfigureLogging(t *testing.T) {
+	defer ConfigureLogging("text", false)
+	tests := []struct {
+		format  string
+		wantErr bool
+	}{
+		{format: "text"},
+		{format: "json"},
+		{format: "logfmt", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if err := ConfigureLogging(tt.format, true); (err != nil) != tt.wantErr {
+				t.Errorf("ConfigureLogging() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string // empty if a new ID is expected
+	}{
+		{name: "from request", header: "abc-123", want: "abc-123"},
+		{name: "generated", header: ""},
+		{name: "forged log line", header: "abc\nlevel=error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged interface{}
+			h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				logged = logger(r.Context()).Data["request_id"]
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, tt.header)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(RequestIDHeader)
+			if (tt.want != "" && got != tt.want) || (tt.want == "" && (got == tt.header || !requestIDPattern.MatchString(got))) {
+				t.Errorf("withRequestID() = %q, want %q", got, tt.want)
+			}
+			if logged != got {
+				t.Errorf("logger() request_id = %v, want %v", logged, got)
+			}
+		})
+	}
+}
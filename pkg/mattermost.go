@@ -0,0 +1,73 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MattermostTransport receives logs through a Mattermost slash command. Its
+// requests look like Slack's, but without X-Slack-Request-Timestamp, and each
+// command has a token of its own, also sent as "Authorization: Token <token>".
+type MattermostTransport struct {
+	// Tokens is a comma-separated list of the tokens of the slash commands,
+	// e.g. one per team.
+	Tokens string
+}
+
+// Verify checks the token of the slash command, preferring the header.
+func (t *MattermostTransport) Verify(r *http.Request, body []byte) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Token ")
+	if token == "" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return err
+		}
+		token = form.Get("token")
+	}
+	return verifyTokens(token, t.Tokens)
+}
+
+// Parse extracts the log from the form of the slash command. The log is
+// timestamped when received, as Mattermost doesn't send the time.
+func (t *MattermostTransport) Parse(r *http.Request, body []byte) (*Submission, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	if form.Get("user_id") == "" {
+		return nil, fmt.Errorf("missing user")
+	}
+	return &Submission{
+		From: LogContext{
+			UserID:    "mattermost:" + form.Get("user_id"),
+			UserName:  form.Get("user_name"),
+			TeamID:    form.Get("team_id"),
+			ChannelID: form.Get("channel_id"),
+			TriggerID: form.Get("trigger_id"),
+		},
+		Text: form.Get("text"),
+	}, nil
+}
+
+// Reply responds with the message as is, since Mattermost supports Slack's
+// response_type and attachments.
+func (t *MattermostTransport) Reply(w http.ResponseWriter, msg *Message) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(msg)
+}
+
+// mattermost returns the Mattermost transport, or nil if it's not
+// configured. It must be called on a snapshot of the Server.
+func (s *Server) mattermost() Transport {
+	if s.Config.MattermostTokens == "" {
+		return nil
+	}
+	return &MattermostTransport{Tokens: s.Config.MattermostTokens}
+}
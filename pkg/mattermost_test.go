@@ -0,0 +1,82 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func mattermostForm(token string) string {
+	return url.Values{
+		"token":      {token},
+		"team_id":    {"team1"},
+		"channel_id": {"channel1"},
+		"user_id":    {"user1"},
+		"user_name":  {"jane"},
+		"command":    {"/barometer"},
+		"text":       {"3 long review day"},
+		"trigger_id": {"trigger1"},
+	}.Encode()
+}
+
+func TestMattermostTransport_Verify(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		body    string
+		wantErr bool
+	}{
+		{name: "header", header: "Token t2", body: mattermostForm(""), wantErr: false},
+		{name: "form", body: mattermostForm("t1"), wantErr: false},
+		{name: "wrong header", header: "Token t3", body: mattermostForm("t1"), wantErr: true},
+		{name: "missing token", body: mattermostForm(""), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/mattermost/command", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			tr := &MattermostTransport{Tokens: "t1,t2"}
+			if err := tr.Verify(req, []byte(tt.body)); (err != nil) != tt.wantErr {
+				t.Errorf("MattermostTransport.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServer_handleMattermost(t *testing.T) {
+	db := &memory{}
+	s := &Server{Config: &Configuration{Area: "Asia/Manila", MattermostTokens: "t1", ContextFields: "all"}, database: db}
+	req := httptest.NewRequest(http.MethodPost, "/mattermost/command", strings.NewReader(mattermostForm("t1")))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Token t1")
+	rec := httptest.NewRecorder()
+	s.handleTransport("/mattermost/command", s.mattermost)(rec, req)
+
+	got := Message{}
+	if err := json.NewDecoder(rec.Body).Decode(&got); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("handleMattermost() status = %d, error = %v", rec.Code, err)
+	}
+	if got.ResponseType != "ephemeral" || !strings.HasPrefix(got.Text, ackPrefix) {
+		t.Errorf("handleMattermost() = %+v, want an ephemeral acknowledgement", got)
+	}
+
+	items, _ := db.QueryDB(Query{})
+	if len(items) != 1 {
+		t.Fatalf("handleMattermost() stored %d logs, want 1", len(items))
+	}
+	want := LogItem{UserID: "mattermost:user1", UserName: "jane", TeamID: "team1", ChannelID: "channel1", TriggerID: "trigger1", Measure: 3, Notes: "long review day"}
+	if item := items[0]; item.Timestamp.IsZero() {
+		t.Errorf("handleMattermost() stored no timestamp")
+	} else if item.Timestamp = want.Timestamp; item != want {
+		t.Errorf("handleMattermost() stored %+v, want %+v", item, want)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The metrics are never labelled by user ID, both for privacy and to keep
+// the number of time series small.
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "barometer_http_requests_total",
+		Help: "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "barometer_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	insertsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "barometer_db_inserts_total",
+		Help: "Number of logs inserted into the database by scheme and result.",
+	}, []string{"scheme", "result"})
+
+	fetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "barometer_fetch_duration_seconds",
+		Help:    "Latency of fetching reply messages by provider.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	verificationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "barometer_verification_failures_total",
+		Help: "Number of requests that failed verification by route.",
+	}, []string{"route"})
+
+	rateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "barometer_rate_limited_total",
+		Help: "Number of requests rejected by limit, either ip, user or daily.",
+	}, []string{"limit"})
+
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "barometer_queue_depth",
+		Help: "Number of pending background jobs.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		insertsTotal,
+		fetchDuration,
+		verificationFailures,
+		rateLimitedTotal,
+		queueDepth,
+	)
+}
+
+// handleMetrics exposes the metrics in the Prometheus text format.
+func (s *Server) handleMetrics() http.HandlerFunc {
+	h := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.queue != nil {
+			queueDepth.Set(float64(s.queue.Len()))
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// instrument counts the requests to a route and measures their latency.
+func instrument(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h(rec, r)
+		requestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+		requestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(rec.code)).Inc()
+	}
+}
+
+// observeInsert counts the result of inserting into a database.
+func observeInsert(db DBInserter, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	insertsTotal.WithLabelValues(dbScheme(db), result).Inc()
+}
+
+// dbScheme returns the scheme of the database, as used in TABLE.
+func dbScheme(db DBInserter) string {
+	switch db.(type) {
+	case *bigQuery:
+		return "bigquery"
+	case *postgres:
+		return "postgres"
+	case *memory:
+		return "memory"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// failingDB is a database that rejects all inserts.
+type failingDB struct{ memory }
+
+func (t *failingDB) InsertDB(item LogItem) error {
+	return errors.New("insert failed")
+}
+
+func TestInstrument(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "unauthorized", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := requestsTotal.WithLabelValues("/test", http.MethodPost, strconv.Itoa(tt.status))
+			before := testutil.ToFloat64(counter)
+
+			h := instrument("/test", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(tt.status) })
+			h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/test", nil))
+
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("instrument() counted %v requests, want 1", got)
+			}
+		})
+	}
+}
+
+func TestLogItem_Insert_metrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		db     DBInserter
+		scheme string
+		result string
+	}{
+		{name: "success", db: &memory{}, scheme: "memory", result: "success"},
+		{name: "failure", db: &failingDB{}, scheme: "other", result: "failure"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := insertsTotal.WithLabelValues(tt.scheme, tt.result)
+			before := testutil.ToFloat64(counter)
+
+			item := &LogItem{UserID: "U1", Measure: 3}
+			item.Insert(context.Background(), tt.db)
+
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("LogItem.Insert() counted %v inserts, want 1", got)
+			}
+		})
+	}
+}
+
+func TestServer_handleMetrics(t *testing.T) {
+	s := &Server{queue: NewQueue(10, 0)}
+	s.queue.Push(func() {})
+	s.queue.Push(func() {})
+
+	rec := httptest.NewRecorder()
+	s.handleMetrics()(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	b, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("could not read response: %v", err)
+	}
+
+	for _, want := range []string{"barometer_queue_depth 2", "go_goroutines"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("handleMetrics() = %s, want %q", b, want)
+		}
+	}
+	if strings.Contains(string(b), "user_id") {
+		t.Errorf("handleMetrics() must not label metrics by user ID")
+	}
+}
@@ -0,0 +1,498 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	slackAuthorizeURL = "https://slack.com/oauth/v2/authorize"
+	slackBotScopes    = "commands,chat:write,im:write,users:read"
+
+	// The state of the OAuth flow is kept in a cookie until Slack redirects
+	// back to the callback, and expires after oauthStateTTL.
+	oauthStateCookie = "barometer_oauth_state"
+	oauthStateTTL    = 10 * time.Minute
+
+	// installationTTL is how long installations are cached. A workspace that
+	// uninstalled the app may still be served by other servers for as long.
+	installationTTL = 5 * time.Minute
+
+	// encryptedTokenPrefix marks the bot tokens encrypted with the key of
+	// the secrets file
+	encryptedTokenPrefix = "enc:"
+)
+
+// ErrNotInstalled is returned by an InstallationStore if the app hasn't been
+// installed into a workspace.
+var ErrNotInstalled = errors.New("the app is not installed in this workspace")
+
+// Installation contains the credentials of a workspace that installed the app
+// through the "Add to Slack" flow.
+type Installation struct {
+	tableName struct{} `sql:"slack_installations"`
+
+	TeamID       string    `sql:",pk" bigquery:"team_id"`
+	TeamName     string    `bigquery:"team_name"`
+	EnterpriseID string    `bigquery:"enterprise_id"` // Empty unless the workspace is part of an Enterprise Grid
+	AppID        string    `bigquery:"app_id"`
+	BotUserID    string    `bigquery:"bot_user_id"`
+	BotToken     string    `bigquery:"bot_token"` // Empty once uninstalled, and encrypted if BB_SECRETS_KEY is set
+	Scope        string    `bigquery:"scope"`
+	InstalledAt  time.Time `bigquery:"installed_at"`
+	UpdatedAt    time.Time `bigquery:"updated_at"` // Set by Workspaces.Save, so that the latest row wins in append-only stores
+}
+
+// InstallationStore is an interface for storing the credentials of each
+// workspace. GetInstallation returns ErrNotInstalled for unknown teams.
+type InstallationStore interface {
+	GetInstallation(teamID string) (*Installation, error)
+	SaveInstallation(i Installation) error
+	ListInstallations() ([]Installation, error)
+}
+
+// Workspaces keeps track of the workspaces that installed the app, and
+// creates Slack clients with the bot token of each.
+type Workspaces struct {
+	Store   InstallationStore
+	BaseURL string // Overrides the URL of the Slack Web API, e.g. for testing
+
+	// Key encrypts the bot tokens in the Store if set. It's the key of the
+	// secrets file, see SecretsKeyEnvVar. Tokens stored in plain text before
+	// the key was set can still be read.
+	Key []byte
+
+	// TTL is how long installations are cached, since they are looked up on
+	// every request. They are not cached if zero.
+	TTL time.Duration
+
+	once  sync.Once
+	cache *cache
+}
+
+// NewWorkspaces returns the workspaces of the installations stored in the
+// database, or nil if the app is only used in a single workspace, i.e.
+// SLACK_CLIENT_ID is not set. Bot tokens are encrypted with the key in
+// SecretsKeyEnvVar if it's set.
+func NewWorkspaces(cfg *Configuration, db DBInserter) (*Workspaces, error) {
+	if cfg.SlackClientID == "" {
+		return nil, nil
+	}
+	store, ok := db.(InstallationStore)
+	if !ok {
+		return nil, fmt.Errorf("the configured database cannot store installations")
+	}
+	w := &Workspaces{Store: store, TTL: installationTTL}
+	if key := os.Getenv(SecretsKeyEnvVar); key != "" {
+		k, err := parseSecretsKey(key)
+		if err != nil {
+			return nil, err
+		}
+		w.Key = k
+	}
+	return w, nil
+}
+
+// Installation returns the installation of a team. It returns
+// ErrNotInstalled if the app was uninstalled from the workspace.
+func (w *Workspaces) Installation(teamID string) (*Installation, error) {
+	if i, ok := w.installations().Get(teamID); ok {
+		found := i.(Installation)
+		return &found, nil
+	}
+
+	found, err := w.Store.GetInstallation(teamID)
+	if err != nil {
+		return nil, err
+	}
+	if found.BotToken == "" {
+		return nil, ErrNotInstalled
+	}
+	if found.BotToken, err = w.decryptToken(found.BotToken); err != nil {
+		return nil, err
+	}
+	w.installations().Set(teamID, *found)
+	return found, nil
+}
+
+// List returns the workspaces that the app is installed into.
+func (w *Workspaces) List() ([]Installation, error) {
+	all, err := w.Store.ListInstallations()
+	if err != nil {
+		return nil, err
+	}
+	installs := []Installation{}
+	for _, i := range all {
+		if i.BotToken == "" {
+			continue
+		}
+		if i.BotToken, err = w.decryptToken(i.BotToken); err != nil {
+			return nil, fmt.Errorf("cannot read the bot token of %s: %v", i.TeamID, err)
+		}
+		installs = append(installs, i)
+	}
+	return installs, nil
+}
+
+// Save stores the installation of a team, replacing any earlier one.
+func (w *Workspaces) Save(i Installation) error {
+	stored := i
+	stored.UpdatedAt = time.Now()
+	if w.Key != nil && i.BotToken != "" {
+		sealed, err := encrypt(w.Key, []byte(i.BotToken))
+		if err != nil {
+			return err
+		}
+		stored.BotToken = encryptedTokenPrefix + sealed
+	}
+	if err := w.Store.SaveInstallation(stored); err != nil {
+		return err
+	}
+	w.installations().Delete(i.TeamID)
+	return nil
+}
+
+// Uninstall forgets the bot token of a team, e.g. when the app is
+// uninstalled from its workspace. Its installation is kept without a token,
+// as API tokens are.
+func (w *Workspaces) Uninstall(teamID string) error {
+	i, err := w.Store.GetInstallation(teamID)
+	if err == ErrNotInstalled {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	i.BotToken = ""
+	return w.Save(*i)
+}
+
+func (w *Workspaces) installations() *cache {
+	w.once.Do(func() { w.cache = &cache{TTL: w.TTL} })
+	return w.cache
+}
+
+func (w *Workspaces) decryptToken(token string) (string, error) {
+	if !strings.HasPrefix(token, encryptedTokenPrefix) {
+		return token, nil
+	}
+	if w.Key == nil {
+		return "", fmt.Errorf("the bot token is encrypted, but %s is not set", SecretsKeyEnvVar)
+	}
+	plain, err := decrypt(w.Key, strings.TrimPrefix(token, encryptedTokenPrefix))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Client returns the Slack client for the bot of a team. The fallback is
+// returned if w is nil, i.e. the app is only used in a single workspace, or
+// if the team is empty. It returns nil if the team is not installed.
+func (w *Workspaces) Client(teamID string, fallback *SlackClient) *SlackClient {
+	if w == nil || teamID == "" {
+		return fallback
+	}
+	i, err := w.Installation(teamID)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err, "team": teamID}).Error("Workspaces.Installation")
+		return nil
+	}
+	return w.client(i.BotToken)
+}
+
+func (w *Workspaces) client(token string) *SlackClient {
+	c := NewSlackClient(token)
+	if w.BaseURL != "" {
+		c.BaseURL = w.BaseURL
+	}
+	return c
+}
+
+// OAuthAccess exchanges the code given to the OAuth callback for the bot
+// token of the workspace that installed the app.
+func (c *SlackClient) OAuthAccess(clientID, clientSecret, code, redirectURL string) (*Installation, error) {
+	type response struct {
+		AppID       string `json:"app_id"`
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		Scope       string `json:"scope"`
+		BotUserID   string `json:"bot_user_id"`
+		Team        struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"team"`
+		Enterprise *struct {
+			ID string `json:"id"`
+		} `json:"enterprise"`
+	}
+
+	form := url.Values{
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"code":          {code},
+	}
+	if redirectURL != "" {
+		form.Set("redirect_uri", redirectURL)
+	}
+
+	res := response{}
+	if err := c.call("oauth.v2.access", form, &res); err != nil {
+		return nil, err
+	}
+	if res.TokenType != "bot" || res.Team.ID == "" {
+		return nil, fmt.Errorf("oauth.v2.access returned no bot token")
+	}
+
+	i := &Installation{
+		TeamID:      res.Team.ID,
+		TeamName:    res.Team.Name,
+		AppID:       res.AppID,
+		BotUserID:   res.BotUserID,
+		BotToken:    res.AccessToken,
+		Scope:       res.Scope,
+		InstalledAt: time.Now(),
+	}
+	if res.Enterprise != nil {
+		i.EnterpriseID = res.Enterprise.ID
+	}
+	return i, nil
+}
+
+// handleSlackInstall starts the "Add to Slack" flow by redirecting to the
+// authorization page of Slack.
+func (s *Server) handleSlackInstall() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger(r.Context()).WithFields(log.Fields{"path": "/slack/install"}).Trace("received request")
+		if s.workspaces == nil {
+			w.Header().Set("Content-Type", "application/json")
+			e := errorMsg{Message: "installing into other workspaces is disabled", Code: http.StatusNotFound}
+			e.JSONError(w)
+			return
+		}
+
+		state, err := newOAuthState(s.Config.SlackClientSecret, time.Now())
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			e := errorMsg{Message: "cannot start the installation", Code: http.StatusInternalServerError}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": err}).Error("newOAuthState")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     oauthStateCookie,
+			Value:    state,
+			Path:     "/slack/oauth",
+			MaxAge:   int(oauthStateTTL.Seconds()),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		q := url.Values{
+			"client_id": {s.Config.SlackClientID},
+			"scope":     {slackBotScopes},
+			"state":     {state},
+		}
+		if s.Config.SlackRedirectURL != "" {
+			q.Set("redirect_uri", s.Config.SlackRedirectURL)
+		}
+		http.Redirect(w, r, slackAuthorizeURL+"?"+q.Encode(), http.StatusFound)
+	}
+}
+
+// handleSlackOAuthCallback completes the "Add to Slack" flow. It stores the
+// bot token of the workspace and sends the user back to Slack.
+func (s *Server) handleSlackOAuthCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger(r.Context()).WithFields(log.Fields{"path": "/slack/oauth/callback"}).Trace("received request")
+		w.Header().Set("Content-Type", "application/json")
+		if s.workspaces == nil {
+			e := errorMsg{Message: "installing into other workspaces is disabled", Code: http.StatusNotFound}
+			e.JSONError(w)
+			return
+		}
+
+		// Clear the state so that it can't be used again
+		http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/slack/oauth", MaxAge: -1})
+
+		q := r.URL.Query()
+		if reason := q.Get("error"); reason != "" {
+			e := errorMsg{Message: fmt.Sprintf("installation was cancelled: %s", reason), Code: http.StatusBadRequest}
+			e.JSONError(w)
+			return
+		}
+
+		state := q.Get("state")
+		cookie, err := r.Cookie(oauthStateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			e := errorMsg{Message: "state does not match, please start the installation again", Code: http.StatusBadRequest}
+			e.JSONError(w)
+			verificationFailures.WithLabelValues("/slack/oauth/callback").Inc()
+			return
+		}
+		if err := verifyOAuthState(s.Config.SlackClientSecret, state, time.Now()); err != nil {
+			e := errorMsg{Message: fmt.Sprintf("%s, please start the installation again", err), Code: http.StatusBadRequest}
+			e.JSONError(w)
+			verificationFailures.WithLabelValues("/slack/oauth/callback").Inc()
+			return
+		}
+
+		i, err := s.workspaces.client("").OAuthAccess(s.Config.SlackClientID, s.Config.SlackClientSecret, q.Get("code"), s.Config.SlackRedirectURL)
+		if err != nil {
+			e := errorMsg{Message: "cannot complete the installation", Code: http.StatusBadGateway}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": err}).Error("SlackClient.OAuthAccess")
+			return
+		}
+		if err := s.workspaces.Save(*i); err != nil {
+			e := errorMsg{Message: "cannot store the installation", Code: http.StatusInternalServerError}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": err}).Error("Workspaces.Save")
+			return
+		}
+		logger(r.Context()).WithFields(log.Fields{"team": i.TeamID, "team_name": i.TeamName}).Info("installed into workspace")
+
+		redirect := url.Values{"app": {i.AppID}, "team": {i.TeamID}}
+		http.Redirect(w, r, "https://slack.com/app_redirect?"+redirect.Encode(), http.StatusFound)
+	}
+}
+
+// handleSlackEvents handles the events of the Events API that concern the
+// installations, i.e. when a workspace uninstalls the app or revokes its bot
+// token, so that the token isn't used anymore. Subscribe to app_uninstalled
+// and tokens_revoked with /slack/events as the request URL.
+func (s *Server) handleSlackEvents() http.HandlerFunc {
+	type payload struct {
+		Token     string `json:"token"`
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		TeamID    string `json:"team_id"`
+		Event     struct {
+			Type   string `json:"type"`
+			Tokens struct {
+				Bot []string `json:"bot"`
+			} `json:"tokens"`
+		} `json:"event"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger(r.Context()).WithFields(log.Fields{"path": "/slack/events"}).Trace("received request")
+		w.Header().Set("Content-Type", "application/json")
+		if s.workspaces == nil {
+			e := errorMsg{Message: "installing into other workspaces is disabled", Code: http.StatusNotFound}
+			e.JSONError(w)
+			return
+		}
+
+		p := payload{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&p); err != nil {
+			e := errorMsg{Message: fmt.Sprintf("couldn't parse payload: %s", err), Code: http.StatusBadRequest}
+			e.JSONError(w)
+			return
+		}
+		if err := verifyTokens(p.Token, s.Config.Token); err != nil {
+			e := errorMsg{Message: fmt.Sprintf("token may be missing or invalid: %s", err), Code: http.StatusUnauthorized}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("verifyTokens")
+			verificationFailures.WithLabelValues("/slack/events").Inc()
+			return
+		}
+
+		// Slack checks the request URL when it's saved
+		if p.Type == "url_verification" {
+			json.NewEncoder(w).Encode(map[string]string{"challenge": p.Challenge})
+			return
+		}
+
+		uninstalled := p.Event.Type == "app_uninstalled" || (p.Event.Type == "tokens_revoked" && len(p.Event.Tokens.Bot) > 0)
+		if p.Type != "event_callback" || !uninstalled {
+			logger(r.Context()).WithFields(log.Fields{"type": p.Event.Type}).Debug("ignoring event")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err := s.workspaces.Uninstall(p.TeamID); err != nil {
+			// Slack retries the event if it isn't acknowledged
+			e := errorMsg{Message: "cannot remove the installation", Code: http.StatusInternalServerError}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": err}).Error("Workspaces.Uninstall")
+			return
+		}
+		logger(r.Context()).WithFields(log.Fields{"team": p.TeamID, "event": p.Event.Type}).Info("uninstalled from workspace")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// verifyTeam checks that the app was installed into the workspace that sent
+// a request. It returns the team to store with the logs, which is empty if
+// the app is only used in a single workspace.
+func (s *Server) verifyTeam(teamID string) (string, error) {
+	if s.workspaces == nil {
+		return "", nil
+	}
+	if teamID == "" {
+		return "", fmt.Errorf("missing team_id")
+	}
+	if _, err := s.workspaces.Installation(teamID); err != nil {
+		return "", err
+	}
+	return teamID, nil
+}
+
+// slackFor returns the Slack client of a team, or nil if none is available.
+func (s *Server) slackFor(teamID string) *SlackClient {
+	return s.workspaces.Client(teamID, s.slack)
+}
+
+// newOAuthState creates a state for the OAuth flow that expires after
+// oauthStateTTL. It is signed with the client secret so that forged states
+// are rejected.
+func newOAuthState(secret string, now time.Time) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	payload := fmt.Sprintf("%d.%s", now.Add(oauthStateTTL).Unix(), hex.EncodeToString(b))
+	return payload + "." + signOAuthState(secret, payload), nil
+}
+
+// verifyOAuthState checks the signature and expiry of a state.
+func verifyOAuthState(secret, state string, now time.Time) error {
+	i := strings.LastIndex(state, ".")
+	if i < 0 {
+		return fmt.Errorf("invalid state")
+	}
+	payload, sig := state[:i], state[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(signOAuthState(secret, payload))) {
+		return fmt.Errorf("invalid state")
+	}
+	expiry, err := strconv.ParseInt(strings.SplitN(payload, ".", 2)[0], 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return fmt.Errorf("state has expired")
+	}
+	return nil
+}
+
+func signOAuthState(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
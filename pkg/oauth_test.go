@@ -0,0 +1,451 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyOAuthState(t *testing.T) {
+	now := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	state, err := newOAuthState("secret", now)
+	if err != nil {
+		t.Fatalf("newOAuthState() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		secret  string
+		state   string
+		now     time.Time
+		wantErr bool
+	}{
+		{name: "valid", secret: "secret", state: state, now: now.Add(time.Minute)},
+		{name: "expired", secret: "secret", state: state, now: now.Add(oauthStateTTL + time.Second), wantErr: true},
+		{name: "other secret", secret: "other", state: state, now: now, wantErr: true},
+		{name: "tampered", secret: "secret", state: "9" + state, now: now, wantErr: true},
+		{name: "malformed", secret: "secret", state: "state", now: now, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyOAuthState(tt.secret, tt.state, tt.now); (err != nil) != tt.wantErr {
+				t.Errorf("verifyOAuthState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServer_handleSlackInstall(t *testing.T) {
+	tests := []struct {
+		name       string
+		workspaces *Workspaces
+		wantCode   int
+	}{
+		{name: "disabled", wantCode: http.StatusNotFound},
+		{name: "enabled", workspaces: &Workspaces{Store: &memory{}}, wantCode: http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				Config:     &Configuration{SlackClientID: "client", SlackClientSecret: "secret"},
+				workspaces: tt.workspaces,
+			}
+			rec := httptest.NewRecorder()
+			s.handleSlackInstall()(rec, httptest.NewRequest(http.MethodGet, "/slack/install", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("handleSlackInstall() status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusFound {
+				return
+			}
+			loc, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil || !strings.HasPrefix(loc.String(), slackAuthorizeURL) {
+				t.Fatalf("handleSlackInstall() redirected to %q", rec.Header().Get("Location"))
+			}
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Value != loc.Query().Get("state") {
+				t.Errorf("handleSlackInstall() set cookies %v, want the state %q", cookies, loc.Query().Get("state"))
+			}
+			if loc.Query().Get("client_id") != "client" || loc.Query().Get("scope") != slackBotScopes {
+				t.Errorf("handleSlackInstall() redirected with %v", loc.Query())
+			}
+		})
+	}
+}
+
+func TestServer_handleSlackOAuthCallback(t *testing.T) {
+	state, _ := newOAuthState("secret", time.Now())
+	tests := []struct {
+		name     string
+		query    url.Values
+		cookie   string
+		wantCode int
+	}{
+		{name: "installed", query: url.Values{"code": {"code"}, "state": {state}}, cookie: state, wantCode: http.StatusFound},
+		{name: "cancelled", query: url.Values{"error": {"access_denied"}, "state": {state}}, cookie: state, wantCode: http.StatusBadRequest},
+		{name: "missing cookie", query: url.Values{"code": {"code"}, "state": {state}}, wantCode: http.StatusBadRequest},
+		{name: "forged state", query: url.Values{"code": {"code"}, "state": {"1.2.3"}}, cookie: "1.2.3", wantCode: http.StatusBadRequest},
+		{name: "invalid code", query: url.Values{"code": {"stale"}, "state": {state}}, cookie: state, wantCode: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := newSlackStandIn("xoxb-team-one")
+			defer slack.Close()
+
+			db := &memory{}
+			s := &Server{
+				Config:     &Configuration{SlackClientID: "client", SlackClientSecret: "secret"},
+				workspaces: &Workspaces{Store: db, BaseURL: slack.URL},
+			}
+			req := httptest.NewRequest(http.MethodGet, "/slack/oauth/callback?"+tt.query.Encode(), nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			s.handleSlackOAuthCallback()(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("handleSlackOAuthCallback() status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			i, err := db.GetInstallation("T1")
+			if tt.wantCode != http.StatusFound {
+				if err != ErrNotInstalled {
+					t.Errorf("handleSlackOAuthCallback() stored %+v", i)
+				}
+				return
+			}
+			if err != nil || i.BotToken != "xoxb-team-one" || i.TeamName != "Team One" {
+				t.Errorf("handleSlackOAuthCallback() stored %+v, %v", i, err)
+			}
+			if loc := rec.Header().Get("Location"); !strings.Contains(loc, "team=T1") {
+				t.Errorf("handleSlackOAuthCallback() redirected to %q", loc)
+			}
+		})
+	}
+}
+
+func TestServer_handleSlackEvents(t *testing.T) {
+	tests := []struct {
+		name          string
+		disabled      bool
+		body          string
+		wantCode      int
+		wantBody      string
+		wantInstalled bool
+	}{
+		{name: "disabled", disabled: true, body: `{"token": "token", "type": "url_verification"}`, wantCode: http.StatusNotFound, wantInstalled: true},
+		{name: "forged", body: `{"token": "forged", "type": "event_callback", "team_id": "T1", "event": {"type": "app_uninstalled"}}`, wantCode: http.StatusUnauthorized, wantInstalled: true},
+		{name: "url verification", body: `{"token": "token", "type": "url_verification", "challenge": "c1"}`, wantCode: http.StatusOK, wantBody: `"challenge":"c1"`, wantInstalled: true},
+		{name: "uninstalled", body: `{"token": "token", "type": "event_callback", "team_id": "T1", "event": {"type": "app_uninstalled"}}`, wantCode: http.StatusOK},
+		{name: "bot token revoked", body: `{"token": "token", "type": "event_callback", "team_id": "T1", "event": {"type": "tokens_revoked", "tokens": {"bot": ["B1"]}}}`, wantCode: http.StatusOK},
+		{name: "user token revoked", body: `{"token": "token", "type": "event_callback", "team_id": "T1", "event": {"type": "tokens_revoked", "tokens": {"oauth": ["U1"]}}}`, wantCode: http.StatusOK, wantInstalled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memory{}
+			db.SaveInstallation(Installation{TeamID: "T1", BotToken: "xoxb-team-one"})
+			s := &Server{Config: &Configuration{Token: "token"}}
+			if !tt.disabled {
+				s.workspaces = &Workspaces{Store: db, TTL: time.Minute}
+				// Cache the installation, which the event must invalidate
+				if _, err := s.workspaces.Installation("T1"); err != nil {
+					t.Fatalf("Workspaces.Installation() error = %v", err)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			s.handleSlackEvents()(rec, httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("handleSlackEvents() = %d %s, want %d %s", rec.Code, rec.Body, tt.wantCode, tt.wantBody)
+			}
+			if tt.disabled {
+				return
+			}
+			if _, err := s.verifyTeam("T1"); (err == nil) != tt.wantInstalled {
+				t.Errorf("verifyTeam() error = %v, want installed %v", err, tt.wantInstalled)
+			}
+		})
+	}
+}
+
+func TestWorkspaces_Key(t *testing.T) {
+	key, _ := NewSecretsKey()
+	k, _ := parseSecretsKey(key)
+	db := &memory{}
+	db.SaveInstallation(Installation{TeamID: "T0", BotToken: "xoxb-plain"})
+	w := &Workspaces{Store: db, Key: k}
+	if err := w.Save(Installation{TeamID: "T1", BotToken: "xoxb-team-one"}); err != nil {
+		t.Fatalf("Workspaces.Save() error = %v", err)
+	}
+
+	stored, _ := db.GetInstallation("T1")
+	if !strings.HasPrefix(stored.BotToken, encryptedTokenPrefix) || strings.Contains(stored.BotToken, "xoxb") {
+		t.Errorf("Workspaces.Save() stored the bot token %q, want it encrypted", stored.BotToken)
+	}
+	for team, want := range map[string]string{"T0": "xoxb-plain", "T1": "xoxb-team-one"} {
+		if i, err := w.Installation(team); err != nil || i.BotToken != want {
+			t.Errorf("Workspaces.Installation(%s) = %+v, %v, want bot token %s", team, i, err, want)
+		}
+	}
+	if installs, err := w.List(); err != nil || len(installs) != 2 {
+		t.Errorf("Workspaces.List() = %+v, %v", installs, err)
+	}
+
+	// The token can't be read without the key
+	if _, err := (&Workspaces{Store: db}).Installation("T1"); err == nil {
+		t.Errorf("Workspaces.Installation() error = nil without the key")
+	}
+}
+
+// appendOnly stores installations the way BigQuery does: every save is a new
+// row, and the row updated last wins. Ties go to the row saved first.
+type appendOnly struct {
+	rows []Installation
+}
+
+func (t *appendOnly) GetInstallation(teamID string) (*Installation, error) {
+	var latest *Installation
+	for i, row := range t.rows {
+		if row.TeamID == teamID && (latest == nil || row.UpdatedAt.After(latest.UpdatedAt)) {
+			latest = &t.rows[i]
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotInstalled
+	}
+	found := *latest
+	return &found, nil
+}
+
+func (t *appendOnly) ListInstallations() ([]Installation, error) {
+	list := []Installation{}
+	seen := map[string]bool{}
+	for _, row := range t.rows {
+		if seen[row.TeamID] {
+			continue
+		}
+		seen[row.TeamID] = true
+		i, _ := t.GetInstallation(row.TeamID)
+		list = append(list, *i)
+	}
+	return list, nil
+}
+
+func (t *appendOnly) SaveInstallation(i Installation) error {
+	t.rows = append(t.rows, i)
+	return nil
+}
+
+func TestWorkspaces_Uninstall(t *testing.T) {
+	installed := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name  string
+		store InstallationStore
+	}{
+		{name: "upserted", store: &memory{}},
+		{name: "append-only", store: &appendOnly{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Workspaces{Store: tt.store}
+			if err := w.Save(Installation{TeamID: "T1", BotToken: "xoxb-team-one", InstalledAt: installed}); err != nil {
+				t.Fatalf("Workspaces.Save() error = %v", err)
+			}
+			if err := w.Uninstall("T1"); err != nil {
+				t.Fatalf("Workspaces.Uninstall() error = %v", err)
+			}
+
+			if i, err := w.Installation("T1"); err != ErrNotInstalled {
+				t.Errorf("Workspaces.Installation() = %+v, %v, want ErrNotInstalled", i, err)
+			}
+			if installs, err := w.List(); err != nil || len(installs) != 0 {
+				t.Errorf("Workspaces.List() = %+v, %v, want no installations", installs, err)
+			}
+			if i, _ := tt.store.GetInstallation("T1"); !i.InstalledAt.Equal(installed) {
+				t.Errorf("Workspaces.Uninstall() installed at %s, want %s", i.InstalledAt, installed)
+			}
+		})
+	}
+}
+
+func TestServer_handleLog_workspaces(t *testing.T) {
+	db := &memory{}
+	db.SaveInstallation(Installation{TeamID: "T1", BotToken: "xoxb-team-one"})
+	db.InsertDB(LogItem{TeamID: "T2", UserID: "U1", Timestamp: time.Now(), Measure: 3})
+
+	tests := []struct {
+		name     string
+		teamID   string
+		wantCode int
+	}{
+		{name: "installed workspace", teamID: "T1", wantCode: http.StatusOK},
+		{name: "unknown workspace", teamID: "T2", wantCode: http.StatusUnauthorized},
+		{name: "missing team", wantCode: http.StatusUnauthorized},
+	}
+
+	s := &Server{
+		Config:     &Configuration{Token: "token", Area: "Asia/Manila", DailyLogLimit: "1"},
+		database:   db,
+		workspaces: &Workspaces{Store: db},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"token": {"token"}, "team_id": {tt.teamID}, "user_id": {"U1"}, "text": {"3 long review day"}}
+			req := httptest.NewRequest(http.MethodPost, "/log", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Slack-Request-Timestamp", fmt.Sprint(time.Now().Unix()))
+			rec := httptest.NewRecorder()
+			s.handleLog()(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("handleLog() status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+		})
+	}
+
+	// The log of the other team doesn't count towards the daily limit
+	items, _ := db.QueryDB(Query{TeamID: "T1"})
+	if len(items) != 1 || items[0].UserID != "U1" {
+		t.Errorf("handleLog() stored %+v in team T1, want a single log", items)
+	}
+}
+
+func TestAlertEngine_Evaluate_workspaces(t *testing.T) {
+	srv := newSlackStandIn("xoxb-team-one")
+	defer srv.Close()
+
+	db := &memory{}
+	db.SaveInstallation(Installation{TeamID: "T1", BotToken: "xoxb-team-one"})
+	now := time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC)
+	for _, item := range logs("U1", now, 1) {
+		item.TeamID = "T2"
+		db.InsertDB(item)
+	}
+	for _, item := range logs("U1", now, 5) {
+		item.TeamID = "T1"
+		db.InsertDB(item)
+	}
+
+	e := &AlertEngine{
+		Rules:      []AlertRule{{Name: "low", Kind: RuleConsecutiveLow, Threshold: 2, Count: 1, Message: "hi {user}"}},
+		DB:         db,
+		Client:     srv.client("xoxb-default"),
+		Workspaces: &Workspaces{Store: db, BaseURL: srv.URL},
+	}
+
+	// The low mood was logged in another workspace
+	e.Evaluate("T1", "U1", now)
+	if got := len(srv.posted()); got != 0 {
+		t.Errorf("AlertEngine.Evaluate() sent %d alerts for team T1, want 0", got)
+	}
+
+	// Alerts are sent through the bot of the workspace, as the stand-in
+	// rejects the default bot token
+	db.InsertDB(LogItem{TeamID: "T1", UserID: "U1", Timestamp: now, Measure: 1})
+	e.Evaluate("T1", "U1", now.Add(time.Second))
+	if got := len(srv.posted()); got != 1 {
+		t.Errorf("AlertEngine.Evaluate() sent %d alerts for team T1, want 1", got)
+	}
+}
+
+func TestAlertEngine_CheckInactivity_workspaces(t *testing.T) {
+	srv := newSlackStandIn("xoxb-team-one")
+	defer srv.Close()
+
+	db := &memory{}
+	db.SaveInstallation(Installation{TeamID: "T1", BotToken: "xoxb-team-one"})
+	// U1 went quiet in T1, but still logs in T2 where the app is not
+	// installed anymore
+	for _, item := range logs("U1", time.Date(2020, 1, 13, 9, 0, 0, 0, time.UTC), 3) {
+		item.TeamID = "T1"
+		db.InsertDB(item)
+	}
+	for _, item := range logs("U1", time.Date(2020, 1, 16, 9, 0, 0, 0, time.UTC), 3) {
+		item.TeamID = "T2"
+		db.InsertDB(item)
+	}
+
+	e := &AlertEngine{
+		Rules:      []AlertRule{{Name: "inactive", Kind: RuleInactivity, Count: 3, Message: "hi {user}"}},
+		DB:         db,
+		Client:     srv.client("xoxb-default"),
+		Workspaces: &Workspaces{Store: db, BaseURL: srv.URL},
+	}
+	e.CheckInactivity(time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC))
+
+	// The stand-in rejects the default bot token, so the alert went through
+	// the bot of T1
+	if got := len(srv.posted()); got != 1 {
+		t.Errorf("AlertEngine.CheckInactivity() sent %d alerts, want 1", got)
+	}
+}
+
+func TestScheduler_Tick_workspaces(t *testing.T) {
+	srv := newSlackStandIn("xoxb-team-one")
+	defer srv.Close()
+
+	db := &memory{}
+	db.SaveInstallation(Installation{TeamID: "T1", BotToken: "xoxb-team-one"})
+	db.SavePreferences(Preferences{UserID: "U1", Reminder: "09:00", TeamID: "T1"})
+	db.SavePreferences(Preferences{UserID: "U2", Reminder: "09:00", TeamID: "T2"})
+
+	// Without SLACK_BOT_TOKEN, only the workspace bots can send reminders
+	s := &Scheduler{
+		Area:        "Asia/Manila",
+		Preferences: db,
+		Workspaces:  &Workspaces{Store: db, BaseURL: srv.URL},
+	}
+	s.Tick(time.Date(2020, 1, 18, 1, 0, 0, 0, time.UTC))
+
+	// T2 is not installed, so U2 isn't reminded
+	got := srv.posted()
+	if len(got) != 1 || got[0].Channel != "DU1" {
+		t.Errorf("Scheduler.Tick() sent %v, want a reminder to U1", got)
+	}
+}
+
+func TestSendReports_workspaces(t *testing.T) {
+	srv := newSlackStandIn("xoxb-team-one")
+	defer srv.Close()
+
+	until := time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)
+	db := &memory{}
+	db.SaveInstallation(Installation{TeamID: "T1", BotToken: "xoxb-team-one"})
+	for _, u := range []string{"U1", "U2", "U3"} {
+		for _, item := range logs(u, until.Add(-time.Hour), 4) {
+			item.TeamID = "T1"
+			db.InsertDB(item)
+		}
+	}
+	// T2 is not installed, so U4 doesn't get a digest
+	for _, item := range logs("U4", until.Add(-time.Hour), 2) {
+		item.TeamID = "T2"
+		db.InsertDB(item)
+	}
+
+	w := &Workspaces{Store: db, BaseURL: srv.URL}
+	sent, err := SendReports(db, nil, w, srv.client("xoxb-default"), "C123", until.AddDate(0, 0, -7), until)
+	if err != nil {
+		t.Fatalf("SendReports() error = %v", err)
+	}
+
+	// The stand-in rejects the default bot token, so the digests went
+	// through the bot of T1, and the channel didn't get a team digest
+	got := srv.posted()
+	if sent != 3 || len(got) != 3 {
+		t.Errorf("SendReports() sent %d digests and posted %v, want three digests", sent, got)
+	}
+	for _, m := range got {
+		if m.Channel == "C123" || m.Channel == "DU4" {
+			t.Errorf("SendReports() posted to %s", m.Channel)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"4d63.com/tz"
+)
+
+const (
+	// ReplyCare replies with a message from tinycarebot (if configured).
+	ReplyCare = "care"
+	// ReplyPlain replies with the acknowledgement only.
+	ReplyPlain = "plain"
+
+	settingsCallbackID = "barometer_settings"
+)
+
+// Preferences contains the per-user settings of the barometer. The zero value
+// means that the user hasn't changed anything and the defaults are used.
+type Preferences struct {
+	tableName struct{} `sql:"user_preferences"`
+
+	UserID          string    `sql:",pk" bigquery:"user_id"`
+	Timezone        string    `bigquery:"timezone"`                         // IANA-compliant area, empty to use the configured Area
+	Reminder        string    `bigquery:"reminder"`                         // Local time of the daily check-in, empty if disabled
+	ReplyStyle      string    `bigquery:"reply_style"`                      // Either ReplyCare (default) or ReplyPlain
+	HideNotes       bool      `sql:",notnull" bigquery:"hide_notes"`        // Don't store the notes of each log
+	HideFromReports bool      `sql:",notnull" bigquery:"hide_from_reports"` // Exclude logs from team reports
+	ScaleLabels     []string  `sql:",array" bigquery:"scale_labels"`        // Labels for each mood level from 1 to 5
+	Buddy           string    `bigquery:"buddy"`                            // User ID to notify when an alert is triggered
+	TeamID          string    `bigquery:"team_id"`                          // Workspace where the settings were saved, if installed into several
+	UpdatedAt       time.Time `bigquery:"updated_at"`
+}
+
+// PreferenceStore is an interface for storing user preferences. GetPreferences
+// returns the zero Preferences if the user hasn't saved anything yet.
+type PreferenceStore interface {
+	GetPreferences(userID string) (*Preferences, error)
+	SavePreferences(p Preferences) error
+	ListPreferences() ([]Preferences, error)
+}
+
+// settings maps the keys of the settings subcommand to their description.
+var settings = map[string]string{
+	"tz":       "IANA-compliant area, e.g. Europe/Berlin",
+	"reminder": "time of the daily check-in, e.g. 17:30, or off",
+	"reply":    "care or plain",
+	"notes":    "store or hide",
+	"reports":  "include or exclude",
+	"labels":   "five comma-separated labels from 1 to 5, or off",
+	"buddy":    "@someone to notify when you're having a rough time, or off",
+}
+
+// Set updates a single setting from its key and value as typed in the
+// settings subcommand, e.g. "reminder" and "17:30".
+func (p *Preferences) Set(key, value string) error {
+	switch key {
+	case "tz":
+		if _, err := tz.LoadLocation(value); err != nil {
+			return fmt.Errorf("unknown timezone %q, use an IANA-compliant area like Europe/Berlin", value)
+		}
+		p.Timezone = value
+	case "reminder":
+		if value == "" || value == "off" {
+			p.Reminder = ""
+			return nil
+		}
+		at, err := time.Parse("15:04", value)
+		if err != nil {
+			return fmt.Errorf("invalid reminder time %q, use the 24-hour format like 17:30", value)
+		}
+		p.Reminder = at.Format("15:04")
+	case "reply":
+		if value != ReplyCare && value != ReplyPlain {
+			return fmt.Errorf("reply should either be %s or %s", ReplyCare, ReplyPlain)
+		}
+		p.ReplyStyle = value
+	case "notes":
+		if value != "store" && value != "hide" {
+			return fmt.Errorf("notes should either be store or hide")
+		}
+		p.HideNotes = value == "hide"
+	case "reports":
+		if value != "include" && value != "exclude" {
+			return fmt.Errorf("reports should either be include or exclude")
+		}
+		p.HideFromReports = value == "exclude"
+	case "labels":
+		if value == "" || value == "off" {
+			p.ScaleLabels = nil
+			return nil
+		}
+		labels := strings.Split(value, ",")
+		if len(labels) != 5 {
+			return fmt.Errorf("labels should have exactly five values, got %d", len(labels))
+		}
+		for i := range labels {
+			labels[i] = strings.TrimSpace(labels[i])
+		}
+		p.ScaleLabels = labels
+	case "buddy":
+		if value == "" || value == "off" {
+			p.Buddy = ""
+			return nil
+		}
+		// Slack escapes mentions as <@U012AB3CD|name>
+		id := strings.TrimPrefix(strings.TrimSuffix(value, ">"), "<@")
+		id = strings.SplitN(id, "|", 2)[0]
+		if !strings.HasPrefix(id, "U") && !strings.HasPrefix(id, "W") {
+			return fmt.Errorf("buddy should be a mention like @someone, got %q", value)
+		}
+		p.Buddy = id
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return nil
+}
+
+// Label returns the user's label for a mood level, or an empty string.
+func (p *Preferences) Label(measure int) string {
+	if p == nil || len(p.ScaleLabels) != 5 || measure < 1 || measure > 5 {
+		return ""
+	}
+	return p.ScaleLabels[measure-1]
+}
+
+// Summary lists the current settings in a human-readable format.
+func (p *Preferences) Summary(defaultArea string) string {
+	area := p.Timezone
+	if area == "" {
+		area = fmt.Sprintf("%s (default)", defaultArea)
+	}
+	reminder := p.Reminder
+	if reminder == "" {
+		reminder = "off"
+	}
+	reply := p.ReplyStyle
+	if reply == "" {
+		reply = ReplyCare
+	}
+	notes := "store"
+	if p.HideNotes {
+		notes = "hide"
+	}
+	reports := "include"
+	if p.HideFromReports {
+		reports = "exclude"
+	}
+	labels := "off"
+	if len(p.ScaleLabels) > 0 {
+		labels = strings.Join(p.ScaleLabels, ",")
+	}
+	buddy := "off"
+	if p.Buddy != "" {
+		buddy = fmt.Sprintf("<@%s>", p.Buddy)
+	}
+
+	lines := []string{
+		fmt.Sprintf("• *tz*: %s", area),
+		fmt.Sprintf("• *reminder*: %s", reminder),
+		fmt.Sprintf("• *reply*: %s", reply),
+		fmt.Sprintf("• *notes*: %s", notes),
+		fmt.Sprintf("• *reports*: %s", reports),
+		fmt.Sprintf("• *labels*: %s", labels),
+		fmt.Sprintf("• *buddy*: %s", buddy),
+	}
+	return strings.Join(lines, "\n")
+}
+
+// settingsUsage lists the available settings for the settings subcommand.
+func settingsUsage() string {
+	keys := []string{}
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	lines := []string{"Use `settings <key> <value>` to change a setting:"}
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("• *%s*: %s", k, settings[k]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SettingsView creates the Slack modal for viewing and changing preferences.
+func SettingsView(p *Preferences, defaultArea string) *View {
+	input := func(id, label, hint, initial string) Block {
+		return Block{
+			Type:     "input",
+			BlockID:  id,
+			Optional: true,
+			Label:    &TextObject{Type: "plain_text", Text: label},
+			Hint:     &TextObject{Type: "plain_text", Text: hint},
+			Element: &BlockElement{
+				Type:         "plain_text_input",
+				ActionID:     "value",
+				InitialValue: initial,
+			},
+		}
+	}
+	choice := func(id, label, selected string, values ...string) Block {
+		options := []Option{}
+		var initial *Option
+		for _, v := range values {
+			o := Option{Text: &TextObject{Type: "plain_text", Text: v}, Value: v}
+			options = append(options, o)
+			if v == selected {
+				initial = &o
+			}
+		}
+		return Block{
+			Type:    "input",
+			BlockID: id,
+			Label:   &TextObject{Type: "plain_text", Text: label},
+			Element: &BlockElement{
+				Type:          "static_select",
+				ActionID:      "value",
+				Options:       options,
+				InitialOption: initial,
+			},
+		}
+	}
+
+	reply := p.ReplyStyle
+	if reply == "" {
+		reply = ReplyCare
+	}
+	notes, reports := "store", "include"
+	if p.HideNotes {
+		notes = "hide"
+	}
+	if p.HideFromReports {
+		reports = "exclude"
+	}
+
+	return &View{
+		Type:       "modal",
+		CallbackID: settingsCallbackID,
+		Title:      &TextObject{Type: "plain_text", Text: "Barometer settings"},
+		Submit:     &TextObject{Type: "plain_text", Text: "Save"},
+		Close:      &TextObject{Type: "plain_text", Text: "Cancel"},
+		Blocks: []Block{
+			input("tz", "Timezone", fmt.Sprintf("Leave empty to use %s", defaultArea), p.Timezone),
+			input("reminder", "Daily check-in reminder", "24-hour format like 17:30, leave empty to disable", p.Reminder),
+			choice("reply", "Reply style", reply, ReplyCare, ReplyPlain),
+			choice("notes", "Notes", notes, "store", "hide"),
+			choice("reports", "Team reports", reports, "include", "exclude"),
+			input("labels", "Mood labels", "Five comma-separated labels from 1 to 5", strings.Join(p.ScaleLabels, ",")),
+			{
+				Type:     "input",
+				BlockID:  "buddy",
+				Optional: true,
+				Label:    &TextObject{Type: "plain_text", Text: "Buddy"},
+				Hint:     &TextObject{Type: "plain_text", Text: "Someone to notify when you're having a rough time"},
+				Element: &BlockElement{
+					Type:        "users_select",
+					ActionID:    "value",
+					InitialUser: p.Buddy,
+				},
+			},
+		},
+	}
+}
+
+// ViewState contains the values submitted through a modal, keyed by block
+// ID and then by action ID.
+type ViewState struct {
+	Values map[string]map[string]struct {
+		Value          string  `json:"value"`
+		SelectedOption *Option `json:"selected_option"`
+		SelectedUser   string  `json:"selected_user"`
+	} `json:"values"`
+}
+
+// ApplyView updates the preferences from a submitted settings modal. It
+// returns the validation errors keyed by block ID, as expected by Slack.
+func (p *Preferences) ApplyView(state ViewState) map[string]string {
+	errs := make(map[string]string)
+	for _, key := range []string{"tz", "reminder", "reply", "notes", "reports", "labels", "buddy"} {
+		v, ok := state.Values[key]["value"]
+		if !ok {
+			continue
+		}
+		value := strings.TrimSpace(v.Value)
+		if v.SelectedOption != nil {
+			value = v.SelectedOption.Value
+		}
+		if key == "buddy" {
+			value = v.SelectedUser
+		}
+
+		// An empty timezone resets it to the default
+		if key == "tz" && value == "" {
+			p.Timezone = ""
+			continue
+		}
+		if err := p.Set(key, value); err != nil {
+			errs[key] = err.Error()
+		}
+	}
+	return errs
+}
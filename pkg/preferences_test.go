@@ -0,0 +1,67 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreferences_Set(t *testing.T) {
+	tests := []struct {
+		name       string
+		key, value string
+		want       Preferences
+		wantErr    bool
+	}{
+		{name: "timezone", key: "tz", value: "Europe/Berlin", want: Preferences{Timezone: "Europe/Berlin"}},
+		{name: "unknown timezone", key: "tz", value: "Europe/Manila", wantErr: true},
+		{name: "reminder", key: "reminder", value: "9:05", want: Preferences{Reminder: "09:05"}},
+		{name: "reminder off", key: "reminder", value: "off", want: Preferences{}},
+		{name: "invalid reminder", key: "reminder", value: "5pm", wantErr: true},
+		{name: "plain reply", key: "reply", value: "plain", want: Preferences{ReplyStyle: ReplyPlain}},
+		{name: "invalid reply", key: "reply", value: "loud", wantErr: true},
+		{name: "hide notes", key: "notes", value: "hide", want: Preferences{HideNotes: true}},
+		{name: "exclude from reports", key: "reports", value: "exclude", want: Preferences{HideFromReports: true}},
+		{name: "labels", key: "labels", value: "awful, bad, okay, good, great", want: Preferences{ScaleLabels: []string{"awful", "bad", "okay", "good", "great"}}},
+		{name: "too few labels", key: "labels", value: "bad,good", wantErr: true},
+		{name: "unknown setting", key: "color", value: "blue", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Preferences{}
+			err := p.Set(tt.key, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Preferences.Set() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(*p, tt.want) {
+				t.Errorf("Preferences.Set() = %+v, want %+v", *p, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreferences_Label(t *testing.T) {
+	labels := []string{"awful", "bad", "okay", "good", "great"}
+	tests := []struct {
+		name    string
+		prefs   *Preferences
+		measure int
+		want    string
+	}{
+		{name: "with labels", prefs: &Preferences{ScaleLabels: labels}, measure: 4, want: "good"},
+		{name: "without labels", prefs: &Preferences{}, measure: 4, want: ""},
+		{name: "nil preferences", prefs: nil, measure: 4, want: ""},
+		{name: "out of range", prefs: &Preferences{ScaleLabels: labels}, measure: 6, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.prefs.Label(tt.measure); got != tt.want {
+				t.Errorf("Preferences.Label() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrQueueFull is returned when a job is pushed into a full (or closed) Queue.
+var ErrQueueFull = errors.New("queue is full")
+
+// Queue runs jobs, such as evaluating alert rules and sending direct
+// messages, in the background. Slack expects a reply to slash commands within
+// three seconds, so slow work shouldn't happen while handling the request.
+type Queue struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewQueue creates a Queue that holds up to size pending jobs and starts the
+// workers that run them.
+func NewQueue(size, workers int) *Queue {
+	q := &Queue{jobs: make(chan func(), size)}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		job()
+	}
+}
+
+// Push adds a job to the queue without blocking.
+func (q *Queue) Push(job func()) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueFull
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		log.WithFields(log.Fields{"size": cap(q.jobs)}).Warn("queue is full, dropping job")
+		return ErrQueueFull
+	}
+}
+
+// Len returns the number of pending jobs.
+func (q *Queue) Len() int {
+	return len(q.jobs)
+}
+
+// Healthy returns an error if the queue is closed or full, as jobs pushed
+// into it would then be dropped.
+func (q *Queue) Healthy() error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errors.New("queue is closed")
+	}
+	if len(q.jobs) == cap(q.jobs) {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// Close stops accepting new jobs and waits until all pending jobs are done.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.jobs)
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		jobs     int
+		size     int
+		workers  int
+		wantDone int32
+		wantErr  bool
+	}{
+		{name: "all jobs are done", jobs: 10, size: 10, workers: 2, wantDone: 10, wantErr: false},
+		{name: "full queue", jobs: 3, size: 1, workers: 0, wantDone: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(tt.size, tt.workers)
+
+			var done int32
+			var err error
+			for i := 0; i < tt.jobs; i++ {
+				if e := q.Push(func() { atomic.AddInt32(&done, 1) }); e != nil {
+					err = e
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Queue.Push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.workers > 0 {
+				q.Close()
+			}
+			if got := atomic.LoadInt32(&done); got != tt.wantDone {
+				t.Errorf("Queue.Close() done = %d, want %d", got, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestQueue_PushAfterClose(t *testing.T) {
+	q := NewQueue(1, 1)
+	q.Close()
+	if err := q.Push(func() {}); err != ErrQueueFull {
+		t.Errorf("Queue.Push() error = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+func TestQueue_Healthy(t *testing.T) {
+	block := make(chan struct{})
+	q := NewQueue(1, 1)
+	if err := q.Healthy(); err != nil {
+		t.Errorf("Queue.Healthy() error = %v, want nil", err)
+	}
+
+	// One job keeps the worker busy while the other fills the queue
+	started := make(chan struct{})
+	q.Push(func() { close(started); <-block })
+	<-started
+	q.Push(func() {})
+	if err := q.Healthy(); err != ErrQueueFull {
+		t.Errorf("Queue.Healthy() error = %v, want %v", err, ErrQueueFull)
+	}
+
+	close(block)
+	q.Close()
+	if err := q.Healthy(); err == nil {
+		t.Errorf("Queue.Healthy() error = nil after Close")
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Default rate limits, in the format of USER_RATE_LIMIT and IP_RATE_LIMIT.
+// Requests from Slack come from a few shared addresses, so the limit per IP
+// address is much higher than the limit per user.
+const (
+	defaultUserRateLimit = "10/1m"
+	defaultIPRateLimit   = "300/1m"
+)
+
+// RateLimiter allows up to Limit requests per key within Per, with the
+// requests refilled continuously (a token bucket per key).
+type RateLimiter struct {
+	Limit int
+	Per   time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ParseRateLimit creates a RateLimiter from a limit such as "10/1m", i.e. 10
+// requests per minute. It returns nil if the limit is "0" or "off", which
+// disables rate limiting, and uses fallback if the limit is empty.
+func ParseRateLimit(limit, fallback string) (*RateLimiter, error) {
+	if limit == "" {
+		limit = fallback
+	}
+	if limit == "0" || limit == "off" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(limit, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%q is not in the form N/duration such as 10/1m", limit)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("%q is not in the form N/duration such as 10/1m", limit)
+	}
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return nil, fmt.Errorf("%q is not in the form N/duration such as 10/1m", limit)
+	}
+	return &RateLimiter{Limit: n, Per: per}, nil
+}
+
+// same reports whether both limiters allow the same number of requests, so
+// that one can take the place of the other. Nil limiters are never the same.
+func (l *RateLimiter) same(other *RateLimiter) bool {
+	return l != nil && other != nil && l.Limit == other.Limit && l.Per == other.Per
+}
+
+// Allow takes a request from the bucket of key. It returns false and how
+// long to wait for the next request if the bucket is empty. A nil
+// RateLimiter allows all requests.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	rate := float64(l.Limit) / float64(l.Per)
+	l.sweep(now, rate)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Limit), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.Limit), b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets the buckets that have been refilled, so that the limiter
+// doesn't grow with every key it has seen. The caller must hold l.mu.
+func (l *RateLimiter) sweep(now time.Time, rate float64) {
+	if now.Sub(l.lastSweep) < l.Per {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+float64(now.Sub(b.last))*rate >= float64(l.Limit) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimited rejects requests from IP addresses that exceed the IP rate
+// limit with 429 Too Many Requests. Behind trusted proxies, the address of
+// the client is taken from X-Forwarded-For. It must be called on a snapshot of
+// the Server.
+func (s *Server) rateLimited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, s.proxies)
+		if ok, wait := s.ips.Allow(ip); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			e := errorMsg{Message: "too many requests", Code: http.StatusTooManyRequests}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"ip": ip}).Warn("rate limited")
+			rateLimitedTotal.WithLabelValues("ip").Inc()
+			return
+		}
+		h(w, r)
+	}
+}
+
+// ParseTrustedProxies parses comma-separated IP addresses and CIDR ranges,
+// such as "10.0.0.0/8, 192.168.1.1".
+func ParseTrustedProxies(proxies string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, p := range strings.Split(proxies, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is neither an IP address nor a CIDR range", p)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP address nor a CIDR range", p)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// clientIP returns the address of the client that sent a request. If it was
+// forwarded by trusted proxies, that's the last address in X-Forwarded-For
+// that isn't one of the proxies, as the earlier ones can be forged.
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !trusted(ip, proxies) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !trusted(ip, proxies) {
+			break
+		}
+	}
+	return ip
+}
+
+func trusted(ip string, proxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	for _, n := range proxies {
+		if parsed != nil && n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitUser checks the rate limit and the daily limit of a user before a log
+// is stored, where the day ends at midnight in the user's area. If a limit is
+// reached, it returns the reply explaining why. It must be called on a
+// snapshot of the Server.
+func (s *Server) limitUser(teamID, userID, area string, now time.Time) (*Message, error) {
+	if ok, wait := s.users.Allow(userID); !ok {
+		rateLimitedTotal.WithLabelValues("user").Inc()
+		return &Message{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("You're logging too often :hourglass: Please try again in %s.", time.Duration(math.Ceil(wait.Seconds()))*time.Second),
+		}, nil
+	}
+
+	limit, _ := strconv.Atoi(s.Config.DailyLogLimit)
+	dq, ok := s.database.(DBQuerier)
+	if limit < 1 || !ok {
+		return nil, nil
+	}
+	_, today, err := LastDays(now, area, 0)
+	if err != nil {
+		return nil, err
+	}
+	items, err := dq.QueryDB(Query{TeamID: teamID, UserID: userID, Since: today})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) >= limit {
+		rateLimitedTotal.WithLabelValues("daily").Inc()
+		return &Message{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("You've reached the limit of %d logs for today. See you tomorrow! :wave:", limit),
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		limit    string
+		wantN    int
+		wantPer  time.Duration
+		wantNil  bool
+		wantErr  bool
+		fallback string
+	}{
+		{limit: "10/1m", wantN: 10, wantPer: time.Minute},
+		{limit: "", fallback: "5/1s", wantN: 5, wantPer: time.Second},
+		{limit: "off", wantNil: true},
+		{limit: "0", wantNil: true},
+		{limit: "10", wantErr: true},
+		{limit: "-1/1m", wantErr: true},
+		{limit: "10/soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.limit, func(t *testing.T) {
+			got, err := ParseRateLimit(tt.limit, tt.fallback)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRateLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("ParseRateLimit() = %v, want nil %v", got, tt.wantNil)
+			}
+			if got != nil && (got.Limit != tt.wantN || got.Per != tt.wantPer) {
+				t.Errorf("ParseRateLimit() = %d/%s, want %d/%s", got.Limit, got.Per, tt.wantN, tt.wantPer)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	l := &RateLimiter{Limit: 2, Per: time.Minute, now: func() time.Time { return now }}
+	tests := []struct {
+		name    string
+		key     string
+		advance time.Duration
+		want    bool
+	}{
+		{name: "first request", key: "U1", want: true},
+		{name: "burst", key: "U1", want: true},
+		{name: "bucket is empty", key: "U1", want: false},
+		{name: "other keys are independent", key: "U2", want: true},
+		{name: "not yet refilled", key: "U1", advance: 10 * time.Second, want: false},
+		{name: "refilled", key: "U1", advance: 20 * time.Second, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			got, wait := l.Allow(tt.key)
+			if got != tt.want {
+				t.Errorf("RateLimiter.Allow() = %v, want %v", got, tt.want)
+			}
+			if !got && wait <= 0 {
+				t.Errorf("RateLimiter.Allow() wait = %v, want a positive duration", wait)
+			}
+		})
+	}
+
+	// Idle buckets are forgotten
+	now = now.Add(time.Hour)
+	l.Allow("U3")
+	if len(l.buckets) != 1 {
+		t.Errorf("RateLimiter has %d buckets, want 1", len(l.buckets))
+	}
+}
+
+func TestServer_handleLog_limits(t *testing.T) {
+	db := &memory{}
+	db.InsertDB(LogItem{UserID: "U2", Timestamp: time.Now(), Measure: 3})
+
+	tests := []struct {
+		name     string
+		userID   string
+		ip       string
+		wantCode int
+		wantText string
+	}{
+		{name: "allowed", userID: "U1", ip: "10.0.0.1", wantCode: http.StatusOK, wantText: ackPrefix},
+		{name: "user rate limit", userID: "U1", ip: "10.0.0.1", wantCode: http.StatusOK, wantText: "too often"},
+		{name: "daily limit", userID: "U2", ip: "10.0.0.1", wantCode: http.StatusOK, wantText: "limit of 1 logs"},
+		{name: "ip rate limit", userID: "U3", ip: "10.0.0.1", wantCode: http.StatusTooManyRequests},
+		{name: "other ip", userID: "U3", ip: "10.0.0.2", wantCode: http.StatusOK, wantText: ackPrefix},
+	}
+
+	s := &Server{
+		Config:   &Configuration{Token: "token", Area: "Asia/Manila", DailyLogLimit: "1"},
+		database: db,
+		users:    &RateLimiter{Limit: 1, Per: time.Hour},
+		ips:      &RateLimiter{Limit: 3, Per: time.Hour},
+	}
+	h := s.rateLimited(s.handleLog())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"token": {"token"}, "user_id": {tt.userID}, "text": {"3 long review day"}}
+			req := httptest.NewRequest(http.MethodPost, "/log", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Slack-Request-Timestamp", fmt.Sprint(time.Now().Unix()))
+			req.RemoteAddr = tt.ip + ":1234"
+			rec := httptest.NewRecorder()
+			h(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("handleLog() status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				if rec.Header().Get("Retry-After") == "" {
+					t.Errorf("handleLog() is missing Retry-After")
+				}
+				return
+			}
+			var msg Message
+			if err := json.NewDecoder(rec.Body).Decode(&msg); err != nil {
+				t.Fatalf("cannot decode response: %v", err)
+			}
+			if !strings.Contains(msg.Text, tt.wantText) {
+				t.Errorf("handleLog() = %q, want %q", msg.Text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{name: "direct", remote: "203.0.113.7:4000", want: "203.0.113.7"},
+		{name: "untrusted proxy", remote: "203.0.113.7:4000", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted proxy", remote: "10.1.2.3:4000", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forged hops are skipped", remote: "10.1.2.3:4000", forwarded: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of proxies", remote: "192.168.1.1:4000", forwarded: []string{"198.51.100.1", "10.0.0.5"}, want: "198.51.100.1"},
+		{name: "trusted proxy without header", remote: "10.1.2.3:4000", want: "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/log", nil)
+			r.RemoteAddr = tt.remote
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if got := clientIP(r, proxies); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		proxies string
+		want    int
+		wantErr bool
+	}{
+		{proxies: "", want: 0},
+		{proxies: "10.0.0.0/8,192.168.1.1, ::1", want: 3},
+		{proxies: "10.0.0.0/33", wantErr: true},
+		{proxies: "proxy.local", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.proxies, func(t *testing.T) {
+			got, err := ParseTrustedProxies(tt.proxies)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTrustedProxies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseTrustedProxies() = %v, want %d ranges", got, tt.want)
+			}
+		})
+	}
+}
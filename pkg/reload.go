@@ -0,0 +1,340 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadDelay is how long to wait for further changes to the configuration
+// file before reloading, as editors often write a file in several steps.
+const reloadDelay = 500 * time.Millisecond
+
+// services are the clients that depend on the configuration. They are built
+// anew whenever the configuration is reloaded.
+type services struct {
+	database    DBInserter
+	preferences PreferenceStore
+	slack       *SlackClient
+	workspaces  *Workspaces
+	alerts      *AlertEngine
+	scheduler   *Scheduler
+	users       *RateLimiter
+	ips         *RateLimiter
+	proxies     []*net.IPNet
+	timezones   *cache
+	apiTokens   *cache
+}
+
+// build creates the services for a configuration.
+func (s *Server) build(cfg *Configuration) (*services, error) {
+	db, err := NewDBInserter(cfg.Table)
+	if err != nil {
+		return nil, err
+	}
+	svc := &services{
+		database:  db,
+		timezones: &cache{TTL: timezoneTTL},
+		apiTokens: &cache{TTL: apiTokenTTL},
+	}
+	if svc.users, err = ParseRateLimit(cfg.UserRateLimit, defaultUserRateLimit); err != nil {
+		return nil, err
+	}
+	if svc.ips, err = ParseRateLimit(cfg.IPRateLimit, defaultIPRateLimit); err != nil {
+		return nil, err
+	}
+	if svc.proxies, err = ParseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if ps, ok := db.(PreferenceStore); ok {
+		svc.preferences = ps
+	}
+	svc.scheduler = &Scheduler{
+		Reminders:   cfg.Reminders,
+		Area:        cfg.Area,
+		Preferences: svc.preferences,
+		TTL:         preferencesTTL,
+		Queue:       s.queue,
+	}
+
+	// Bot tokens of other workspaces are stored with the installations
+	if svc.workspaces, err = NewWorkspaces(cfg, db); err != nil {
+		return nil, err
+	}
+	svc.scheduler.Workspaces = svc.workspaces
+
+	// Send check-in reminders and alerts if a bot token is available
+	if cfg.BotToken != "" {
+		svc.slack = NewSlackClient(cfg.BotToken)
+		svc.scheduler.Client = svc.slack
+	} else if len(cfg.Reminders) > 0 {
+		log.Warn("reminders are configured but SLACK_BOT_TOKEN is empty, skipping")
+	}
+	if svc.slack == nil && svc.workspaces == nil {
+		return svc, nil
+	}
+
+	if dq, ok := db.(DBQuerier); ok && len(cfg.alertRules()) > 0 {
+		svc.alerts = &AlertEngine{
+			Rules:       cfg.alertRules(),
+			DB:          dq,
+			Preferences: svc.preferences,
+			Client:      svc.slack,
+			Workspaces:  svc.workspaces,
+			Queue:       s.queue,
+		}
+		if as, ok := db.(AlertStore); ok {
+			svc.alerts.Store = as
+		}
+		svc.scheduler.Jobs = append(svc.scheduler.Jobs, Job{
+			Name: "inactivity alerts",
+			Time: "10:00",
+			Days: weekdays,
+			Run:  svc.alerts.CheckInactivity,
+		})
+	}
+	if cfg.ReportDay != "" {
+		job, err := reportJob(cfg, svc)
+		if err != nil {
+			return nil, err
+		}
+		svc.scheduler.Jobs = append(svc.scheduler.Jobs, *job)
+	}
+	return svc, nil
+}
+
+// apply swaps in a configuration and its services. The caller must hold
+// the write lock of s.mu.
+func (s *Server) apply(cfg *Configuration, svc *services) {
+	if s.alerts != nil && svc.alerts != nil {
+		svc.alerts.inherit(s.alerts)
+	}
+	// Keep counting the requests of each user and IP address, unless their
+	// limit has changed, and keep what's cached unless it came from another
+	// database.
+	if s.users.same(svc.users) {
+		svc.users = s.users
+	}
+	if s.ips.same(svc.ips) {
+		svc.ips = s.ips
+	}
+	if s.timezones != nil {
+		svc.timezones = s.timezones
+	}
+	if s.apiTokens != nil && s.Config.Table == cfg.Table {
+		svc.apiTokens = s.apiTokens
+	}
+
+	if s.database != nil && s.database != svc.database {
+		// Requests and jobs may still use the previous database, so it is
+		// closed once they would have timed out
+		closeDB(s.database, duration(cfg.WriteTimeout, defaultWriteTimeout))
+	}
+
+	s.Config = cfg
+	s.database = svc.database
+	s.preferences = svc.preferences
+	s.slack = svc.slack
+	s.workspaces = svc.workspaces
+	s.alerts = svc.alerts
+	s.users = svc.users
+	s.ips = svc.ips
+	s.proxies = svc.proxies
+	s.timezones = svc.timezones
+	s.apiTokens = svc.apiTokens
+
+	if s.scheduler == nil {
+		s.scheduler = svc.scheduler
+		go s.scheduler.Run(s.stop)
+	} else {
+		s.scheduler.replace(svc.scheduler)
+	}
+}
+
+// closeDB closes a database after the given delay, if it holds a connection.
+func closeDB(db DBInserter, delay time.Duration) {
+	c, ok := db.(io.Closer)
+	if !ok {
+		return
+	}
+	closeNow := func() {
+		if err := c.Close(); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("DBInserter.Close")
+		}
+	}
+	if delay == 0 {
+		closeNow()
+		return
+	}
+	time.AfterFunc(delay, closeNow)
+}
+
+// locked serves each request with the handler that h builds on a snapshot of
+// the Server. The lock is only held while taking the snapshot, so a reload
+// never waits for slow requests, nor swaps the configuration out midway.
+func (s *Server) locked(h func(s *Server) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(s.snapshot())(w, r)
+	}
+}
+
+// snapshot copies the configuration and the clients built from it, along
+// with the rest of the Server that handlers use.
+func (s *Server) snapshot() *Server {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &Server{
+		Port:        s.Port,
+		Router:      s.Router,
+		Config:      s.Config,
+		Loader:      s.Loader,
+		ConfigPath:  s.ConfigPath,
+		database:    s.database,
+		preferences: s.preferences,
+		slack:       s.slack,
+		workspaces:  s.workspaces,
+		alerts:      s.alerts,
+		scheduler:   s.scheduler,
+		users:       s.users,
+		ips:         s.ips,
+		proxies:     s.proxies,
+		timezones:   s.timezones,
+		apiTokens:   s.apiTokens,
+		queue:       s.queue,
+		stop:        s.stop,
+		tracing:     s.tracing,
+		done:        s.done,
+		Debug:       s.Debug,
+		Version:     s.Version,
+	}
+}
+
+// CurrentConfig returns the configuration that is currently in use.
+func (s *Server) CurrentConfig() *Configuration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Config
+}
+
+// Reload loads the configuration again through the Loader, then swaps it in
+// along with the clients built from it. The current configuration is kept if
+// the new one cannot be loaded or is invalid.
+func (s *Server) Reload() error {
+	if s.Loader == nil {
+		return fmt.Errorf("no configuration loader to reload from")
+	}
+
+	cfg, err := s.Loader()
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("keeping the current configuration")
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("keeping the current configuration")
+		return err
+	}
+	svc, err := s.build(cfg)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("keeping the current configuration")
+		return err
+	}
+
+	s.mu.Lock()
+	if cfg.OTLPEndpoint != s.Config.OTLPEndpoint {
+		s.configureTracing(cfg)
+	}
+	s.apply(cfg, svc)
+	s.mu.Unlock()
+	log.Info("configuration reloaded")
+	return nil
+}
+
+// watch reloads the configuration on SIGHUP and whenever the file at
+// ConfigPath changes, until the stop channel is closed.
+func (s *Server) watch(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Watch the directory rather than the file, since editors and Kubernetes
+	// config maps replace the file instead of writing to it.
+	var events chan fsnotify.Event
+	var errs chan error
+	if s.ConfigPath != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("fsnotify.NewWatcher")
+		} else if err := watcher.Add(filepath.Dir(s.ConfigPath)); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("fsnotify.Watcher.Add")
+			watcher.Close()
+		} else {
+			defer watcher.Close()
+			events, errs = watcher.Events, watcher.Errors
+		}
+	}
+
+	file := statConfigFile(s.ConfigPath)
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-hup:
+			log.Info("received SIGHUP, reloading configuration")
+			s.Reload()
+		case e := <-events:
+			// Config maps swap a symlink elsewhere in the directory, so any
+			// event counts if the file behind ConfigPath has changed.
+			if filepath.Clean(e.Name) == filepath.Clean(s.ConfigPath) && e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				debounce = time.After(reloadDelay)
+			} else if statConfigFile(s.ConfigPath).changed(file) {
+				debounce = time.After(reloadDelay)
+			}
+		case err := <-errs:
+			log.WithFields(log.Fields{"err": err}).Error("fsnotify.Watcher")
+		case <-debounce:
+			debounce = nil
+			file = statConfigFile(s.ConfigPath)
+			log.WithFields(log.Fields{"path": s.ConfigPath}).Info("configuration file changed, reloading")
+			s.Reload()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// configFile identifies the file that ConfigPath resolves to.
+type configFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// statConfigFile resolves the symlinks of a path and stats its target. The
+// zero configFile is returned if the file cannot be found.
+func statConfigFile(path string) configFile {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return configFile{}
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return configFile{}
+	}
+	return configFile{path: target, modTime: info.ModTime(), size: info.Size()}
+}
+
+func (f configFile) changed(old configFile) bool {
+	return f.path != old.path || !f.modTime.Equal(old.modTime) || f.size != old.size
+}
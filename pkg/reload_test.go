@@ -0,0 +1,302 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newReloadServer starts the services of a server without listening.
+func newReloadServer(t *testing.T, cfg *Configuration, loader func() (*Configuration, error)) *Server {
+	s := &Server{Config: cfg, Loader: loader, stop: make(chan struct{}), queue: NewQueue(1, 1)}
+	svc, err := s.build(cfg)
+	if err != nil {
+		t.Fatalf("Server.build() error = %v", err)
+	}
+	s.apply(cfg, svc)
+	return s
+}
+
+func TestServer_Reload(t *testing.T) {
+	current := &Configuration{Table: "memory://", Token: "old-token", Area: "Asia/Manila"}
+	tests := []struct {
+		name      string
+		next      *Configuration
+		loadErr   error
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:      "valid configuration is swapped in",
+			next:      &Configuration{Table: "memory://", Token: "new-token", Area: "Asia/Manila", BotToken: "xoxb-new"},
+			wantToken: "new-token",
+		},
+		{
+			name:      "invalid configuration is rejected",
+			next:      &Configuration{Table: "mysql://localhost", Token: "new-token", Area: "Asia/Manila"},
+			wantToken: "old-token",
+			wantErr:   true,
+		},
+		{
+			name:      "unreadable configuration is rejected",
+			loadErr:   fmt.Errorf("cannot read file"),
+			wantToken: "old-token",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newReloadServer(t, current, func() (*Configuration, error) { return tt.next, tt.loadErr })
+			defer close(s.stop)
+			db, scheduler := s.database, s.scheduler
+
+			if err := s.Reload(); (err != nil) != tt.wantErr {
+				t.Fatalf("Server.Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := s.CurrentConfig().Token; got != tt.wantToken {
+				t.Errorf("Server.Reload() token = %s, want %s", got, tt.wantToken)
+			}
+			if swapped := s.database != db; swapped == tt.wantErr {
+				t.Errorf("Server.Reload() swapped database = %v, want %v", swapped, !tt.wantErr)
+			}
+			if s.scheduler != scheduler {
+				t.Errorf("Server.Reload() replaced the scheduler, want it to be kept")
+			}
+			if !tt.wantErr && (s.slack == nil || s.scheduler.Client != s.slack) {
+				t.Errorf("Server.Reload() did not rebuild the Slack client")
+			}
+		})
+	}
+}
+
+func TestServer_Reload_state(t *testing.T) {
+	current := &Configuration{Token: "token", Table: "memory://", Area: "Asia/Manila", UserRateLimit: "1/1h"}
+	tests := []struct {
+		name          string
+		next          *Configuration
+		wantLimited   bool // whether the user is still limited after reloading
+		wantAPITokens bool // whether cached API tokens are kept
+	}{
+		{
+			name:          "same limit",
+			next:          &Configuration{Token: "token", Table: "memory://", Area: "Europe/Berlin", UserRateLimit: "1/1h"},
+			wantLimited:   true,
+			wantAPITokens: true,
+		},
+		{
+			name:          "changed limit",
+			next:          &Configuration{Token: "token", Table: "memory://", Area: "Asia/Manila", UserRateLimit: "2/1h"},
+			wantAPITokens: true,
+		},
+		{
+			name:        "other database",
+			next:        &Configuration{Token: "token", Table: "memory://other", Area: "Asia/Manila", UserRateLimit: "1/1h"},
+			wantLimited: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newReloadServer(t, current, func() (*Configuration, error) { return tt.next, nil })
+			defer close(s.stop)
+			s.users.Allow("U1")
+			s.timezones.Set("T1/U1", "Europe/Berlin")
+			s.apiTokens.Set("U1", &APIToken{UserID: "U1"})
+
+			if err := s.Reload(); err != nil {
+				t.Fatalf("Server.Reload() error = %v", err)
+			}
+			if ok, _ := s.users.Allow("U1"); ok == tt.wantLimited {
+				t.Errorf("Server.Reload() allowed U1 = %v, want %v", ok, !tt.wantLimited)
+			}
+			if _, ok := s.timezones.Get("T1/U1"); !ok {
+				t.Errorf("Server.Reload() forgot the cached timezones")
+			}
+			if _, ok := s.apiTokens.Get("U1"); ok != tt.wantAPITokens {
+				t.Errorf("Server.Reload() kept the cached API tokens = %v, want %v", ok, tt.wantAPITokens)
+			}
+		})
+	}
+}
+
+// closingDB records when the database is closed.
+type closingDB struct {
+	memory
+	closed int32
+}
+
+func (c *closingDB) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func (c *closingDB) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+func TestServer_closeDB(t *testing.T) {
+	current := &Configuration{Table: "memory://", Token: "token", Area: "Asia/Manila"}
+	next := &Configuration{Table: "memory://", Token: "token", Area: "Asia/Manila", WriteTimeout: "50ms"}
+	s := &Server{Config: current, Loader: func() (*Configuration, error) { return next, nil }}
+	if err := s.setup(); err != nil {
+		t.Fatalf("Server.setup() error = %v", err)
+	}
+
+	// The previous database is closed once pending requests would time out
+	previous := &closingDB{}
+	s.database = previous
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Server.Reload() error = %v", err)
+	}
+	if previous.isClosed() {
+		t.Errorf("Server.Reload() closed the previous database right away")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !previous.isClosed() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !previous.isClosed() {
+		t.Errorf("Server.Reload() never closed the previous database")
+	}
+
+	db := &closingDB{}
+	s.mu.Lock()
+	s.database = db
+	s.mu.Unlock()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Server.Shutdown() error = %v", err)
+	}
+	if !db.isClosed() {
+		t.Errorf("Server.Shutdown() didn't close the database")
+	}
+}
+
+func TestServer_locked(t *testing.T) {
+	current := &Configuration{Table: "memory://", Token: "old-token", Area: "Asia/Manila"}
+	next := &Configuration{Table: "memory://", Token: "new-token", Area: "Asia/Manila"}
+	s := newReloadServer(t, current, func() (*Configuration, error) { return next, nil })
+	defer close(s.stop)
+
+	// A slow request keeps the configuration it started with
+	started, release := make(chan struct{}), make(chan struct{})
+	got := make(chan string)
+	h := s.locked(func(s *Server) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			got <- s.Config.Token
+		}
+	})
+	go h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	reloaded := make(chan error)
+	go func() { reloaded <- s.Reload() }()
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("Server.Reload() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Server.Reload() is blocked by a request in flight")
+	}
+
+	close(release)
+	if token := <-got; token != "old-token" {
+		t.Errorf("Server.locked() token = %s, want old-token", token)
+	}
+}
+
+func TestServer_watch(t *testing.T) {
+	tests := []struct {
+		name    string
+		symlink bool // Whether path is a symlink to ..data/config.json
+		// write replaces the configuration file in dir, at path
+		write func(dir, path string, cfg *Configuration) error
+	}{
+		{
+			name: "file is written",
+			write: func(dir, path string, cfg *Configuration) error {
+				return cfg.WriteConfiguration(path)
+			},
+		},
+		{
+			// Kubernetes config maps point path to ..data/config.json and
+			// swap the ..data symlink to a new directory
+			name:    "symlink is swapped",
+			symlink: true,
+			write: func(dir, path string, cfg *Configuration) error {
+				next := filepath.Join(dir, "..next")
+				if err := os.Mkdir(next, 0755); err != nil {
+					return err
+				}
+				if err := cfg.WriteConfiguration(filepath.Join(next, "config.json")); err != nil {
+					return err
+				}
+				if err := os.Symlink(next, filepath.Join(dir, "..data_tmp")); err != nil {
+					return err
+				}
+				return os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "config")
+			if err != nil {
+				t.Fatalf("cannot create temporary directory: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "config.json")
+			file := path
+			if tt.symlink {
+				first := filepath.Join(dir, "..first")
+				if err := os.Mkdir(first, 0755); err != nil {
+					t.Fatalf("cannot create directory: %v", err)
+				}
+				os.Symlink(first, filepath.Join(dir, "..data"))
+				os.Symlink(filepath.Join("..data", "config.json"), path)
+				file = filepath.Join(first, "config.json")
+			}
+			cfg := &Configuration{Table: "memory://", Token: "b2xkLXRva2Vu", Area: "Asia/Manila"}
+			if err := cfg.WriteConfiguration(file); err != nil {
+				t.Fatalf("Configuration.WriteConfiguration() error = %v", err)
+			}
+
+			current, err := ReadConfiguration(path)
+			if err != nil {
+				t.Fatalf("ReadConfiguration() error = %v", err)
+			}
+
+			s := newReloadServer(t, current, func() (*Configuration, error) { return ReadConfiguration(path) })
+			s.ConfigPath = path
+			defer close(s.stop)
+			go s.watch(s.stop)
+			time.Sleep(100 * time.Millisecond)
+
+			cfg.Token = "bmV3LXRva2Vu"
+			if err := tt.write(dir, path, cfg); err != nil {
+				t.Fatalf("cannot replace the configuration: %v", err)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for s.CurrentConfig().Token != "new-token" {
+				if time.Now().After(deadline) {
+					t.Fatalf("Server.watch() token = %s, want new-token", s.CurrentConfig().Token)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		})
+	}
+}
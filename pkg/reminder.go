@@ -0,0 +1,360 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"4d63.com/tz"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	reminderText     = "Hi! How are you feeling right now?"
+	moodActionPrefix = "log_measure_"
+
+	// preferencesTTL is how long the Scheduler caches the preferences of all
+	// users. Preferences saved on this server are picked up right away.
+	preferencesTTL = 5 * time.Minute
+)
+
+// Reminder is a user's opt-in for a daily check-in message. Reminders can be
+// listed in the Configuration or set by each user through their Preferences.
+type Reminder struct {
+	UserID string `json:"USER_ID" yaml:"user_id" toml:"user_id"`            // Slack user ID to send the reminder to
+	Time   string `json:"TIME" yaml:"time" toml:"time"`                     // Local time of day in 24-hour format, e.g. 17:30
+	Area   string `json:"AREA" yaml:"area,omitempty" toml:"area,omitempty"` // IANA-compliant area, defaults to the user's timezone
+
+	teamID string // Workspace of a reminder set through the Preferences
+}
+
+// Job is a task that the Scheduler runs at a given local time.
+type Job struct {
+	Name string
+	Time string         // Local time in the Scheduler's Area in 24-hour format
+	Days []time.Weekday // Days when the job runs, or every day if empty
+	Run  func(now time.Time)
+}
+
+var weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// Scheduler periodically checks the list of reminders and sends a direct
+// message with the mood picker once the user's local time is reached. It
+// also runs other periodic jobs such as checking alerts.
+type Scheduler struct {
+	Reminders   []Reminder
+	Jobs        []Job
+	Area        string          // Fallback IANA-compliant area for reminders without one
+	Preferences PreferenceStore // Optional store of per-user reminders and timezones
+	Client      *SlackClient
+	Workspaces  *Workspaces // Optional, to remind users through the bot of their workspace
+
+	// Interval is how often the reminders are checked. Defaults to 30 seconds.
+	Interval time.Duration
+
+	// TTL is how long the preferences are cached, or 0 to list them on every
+	// check.
+	TTL time.Duration
+
+	// Queue runs the jobs in the background, so that a slow job doesn't
+	// delay the reminders. If nil, the jobs run right away.
+	Queue *Queue
+
+	once   sync.Once
+	cached *cache
+
+	mu      sync.Mutex
+	started time.Time         // Minute of the first tick
+	sent    map[string]string // user ID to the local date of the last reminder
+	ran     map[string]string // job name to the local date of the last run
+}
+
+// Run checks the reminders on every interval until the stop channel is closed.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	interval := s.Interval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	log.Info("starting reminder scheduler")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			s.Tick(t)
+		case <-stop:
+			log.Debug("stopping reminder scheduler")
+			return
+		}
+	}
+}
+
+// Tick sends the reminders and runs the jobs that are due at the given time.
+// Each user receives at most one reminder per local day, and each job runs at
+// most once per day. A reminder or job is due once its local time has passed,
+// so that a delayed tick still catches up, but not if it passed before the
+// first tick, e.g. before the server was started.
+func (s *Scheduler) Tick(now time.Time) {
+	s.mu.Lock()
+	if s.started.IsZero() {
+		s.started = now.Truncate(time.Minute)
+	}
+	if s.sent == nil {
+		s.sent = make(map[string]string)
+	}
+	if s.ran == nil {
+		s.ran = make(map[string]string)
+	}
+
+	jobs := []Job{}
+	for _, j := range s.Jobs {
+		r := Reminder{Time: j.Time, Area: s.Area}
+		due, err := r.due(now, s.started, s.Area)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err, "job": j.Name}).Error("Reminder.due")
+			continue
+		}
+		date, _ := r.localDate(now, s.Area)
+		if !due || !j.runsOn(now, s.Area) || s.ran[j.Name] == date {
+			continue
+		}
+		s.ran[j.Name] = date
+		jobs = append(jobs, j)
+	}
+	queue := s.Queue
+	s.mu.Unlock()
+
+	for _, j := range jobs {
+		log.WithFields(log.Fields{"job": j.Name}).Debug("running job")
+		run := j.Run
+		if queue == nil {
+			run(now)
+			continue
+		}
+		if err := queue.Push(func() { run(now) }); err != nil {
+			log.WithFields(log.Fields{"err": err, "job": j.Name}).Error("Queue.Push")
+		}
+	}
+
+	s.remind(now)
+}
+
+// dueReminder is a due reminder and the client to send it through.
+type dueReminder struct {
+	Reminder
+	client *SlackClient
+	msg    *Message
+	date   string // Local date of the reminder
+}
+
+// remind sends the reminders that are due. The due reminders are collected
+// while holding the lock, but sent without it so that a slow Slack API
+// doesn't hold up a reload or the next tick.
+func (s *Scheduler) remind(now time.Time) {
+	s.mu.Lock()
+	if s.Client == nil && s.Workspaces == nil {
+		s.mu.Unlock()
+		return
+	}
+	prefs := s.preferences()
+	due := []dueReminder{}
+	for _, r := range s.reminders(prefs) {
+		area := s.Area
+		if p, ok := prefs[r.UserID]; ok && p.Timezone != "" {
+			area = p.Timezone
+		}
+		ok, err := r.due(now, s.started, area)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err, "user": r.UserID}).Error("Reminder.due")
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		date, _ := r.localDate(now, area)
+		if s.sent[r.UserID] == date {
+			continue
+		}
+
+		client := s.Workspaces.Client(r.teamID, s.Client)
+		if client == nil {
+			continue
+		}
+		// Claim the reminder so that a concurrent tick doesn't send it too
+		s.sent[r.UserID] = date
+		msg := MoodPicker(reminderText, prefs[r.UserID].ScaleLabels)
+		due = append(due, dueReminder{Reminder: r, client: client, msg: msg, date: date})
+	}
+	s.mu.Unlock()
+
+	for _, r := range due {
+		if err := r.client.SendDirectMessage(r.UserID, r.msg); err != nil {
+			log.WithFields(log.Fields{"err": err, "user": r.UserID}).Error("SlackClient.SendDirectMessage")
+			// Release the claim so that the next tick tries again
+			s.mu.Lock()
+			if s.sent[r.UserID] == r.date {
+				delete(s.sent, r.UserID)
+			}
+			s.mu.Unlock()
+			continue
+		}
+		log.WithFields(log.Fields{"user": r.UserID}).Debug("sent reminder")
+	}
+}
+
+// replace takes over the reminders, jobs and clients of another Scheduler,
+// e.g. after the configuration was reloaded. Reminders and jobs that already
+// ran today are not repeated.
+func (s *Scheduler) replace(next *Scheduler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Reminders = next.Reminders
+	s.Jobs = next.Jobs
+	s.Area = next.Area
+	s.Preferences = next.Preferences
+	s.Client = next.Client
+	s.Workspaces = next.Workspaces
+	s.Queue = next.Queue
+	s.forgetPreferences()
+}
+
+// forgetPreferences drops the cached preferences, e.g. after a user saved
+// theirs. It is safe to call forgetPreferences on a nil Scheduler.
+func (s *Scheduler) forgetPreferences() {
+	if s == nil {
+		return
+	}
+	s.preferenceCache().Delete("")
+}
+
+func (s *Scheduler) preferenceCache() *cache {
+	s.once.Do(func() { s.cached = &cache{TTL: s.TTL} })
+	return s.cached
+}
+
+func (j Job) runsOn(now time.Time, area string) bool {
+	if len(j.Days) == 0 {
+		return true
+	}
+	loc, err := tz.LoadLocation(area)
+	if err != nil {
+		return false
+	}
+	for _, d := range j.Days {
+		if now.In(loc).Weekday() == d {
+			return true
+		}
+	}
+	return false
+}
+
+// preferences fetches the preferences of all users, keyed by user ID. They
+// are cached for TTL.
+func (s *Scheduler) preferences() map[string]Preferences {
+	prefs := make(map[string]Preferences)
+	if s.Preferences == nil {
+		return prefs
+	}
+	if cached, ok := s.preferenceCache().Get(""); ok {
+		return cached.(map[string]Preferences)
+	}
+	list, err := s.Preferences.ListPreferences()
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("PreferenceStore.ListPreferences")
+		return prefs
+	}
+	for _, p := range list {
+		prefs[p.UserID] = p
+	}
+	s.preferenceCache().Set("", prefs)
+	return prefs
+}
+
+// reminders combines the configured reminders with the ones set by users.
+// A user's own reminder takes precedence over the configured one.
+func (s *Scheduler) reminders(prefs map[string]Preferences) []Reminder {
+	reminders := []Reminder{}
+	for _, r := range s.Reminders {
+		if p, ok := prefs[r.UserID]; ok && p.Reminder != "" {
+			continue
+		}
+		reminders = append(reminders, r)
+	}
+	for _, p := range prefs {
+		if p.Reminder != "" {
+			reminders = append(reminders, Reminder{UserID: p.UserID, Time: p.Reminder, teamID: p.TeamID})
+		}
+	}
+	return reminders
+}
+
+// due checks if the reminder time at the reminder's area has passed today,
+// but not before the given time, e.g. the first tick of the Scheduler.
+func (r Reminder) due(now, since time.Time, defaultArea string) (bool, error) {
+	at, err := time.Parse("15:04", r.Time)
+	if err != nil {
+		return false, fmt.Errorf("invalid reminder time %q: %v", r.Time, err)
+	}
+	loc, err := r.location(defaultArea)
+	if err != nil {
+		return false, err
+	}
+	local := now.In(loc)
+	scheduled := time.Date(local.Year(), local.Month(), local.Day(), at.Hour(), at.Minute(), 0, 0, loc)
+	return !scheduled.After(local) && !scheduled.Before(since), nil
+}
+
+func (r Reminder) localDate(now time.Time, defaultArea string) (string, error) {
+	loc, err := r.location(defaultArea)
+	if err != nil {
+		return "", err
+	}
+	return now.In(loc).Format("2006-01-02"), nil
+}
+
+func (r Reminder) location(defaultArea string) (*time.Location, error) {
+	area := r.Area
+	if area == "" {
+		area = defaultArea
+	}
+	loc, err := tz.LoadLocation(area)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find location: %s", area)
+	}
+	return loc, nil
+}
+
+// MoodPicker creates an interactive message where the user can log their
+// mood by clicking a button from 1 to 5. If five labels are given, they are
+// shown alongside the numbers.
+func MoodPicker(prompt string, labels []string) *Message {
+	buttons := []BlockElement{}
+	for i := 1; i <= 5; i++ {
+		v := strconv.Itoa(i)
+		text := v
+		if len(labels) == 5 {
+			text = fmt.Sprintf("%s %s", v, labels[i-1])
+		}
+		buttons = append(buttons, BlockElement{
+			Type:     "button",
+			ActionID: moodActionPrefix + v,
+			Text:     &TextObject{Type: "plain_text", Text: text},
+			Value:    v,
+		})
+	}
+
+	return &Message{
+		Text: prompt,
+		Blocks: []Block{
+			{Type: "section", Text: &TextObject{Type: "mrkdwn", Text: prompt}},
+			{Type: "actions", BlockID: "mood_picker", Elements: buttons},
+		},
+	}
+}
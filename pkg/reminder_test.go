@@ -0,0 +1,223 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduler_Tick(t *testing.T) {
+	// 2020-01-18 01:00:00 UTC is 09:00 in Asia/Manila and 10:00 in Europe/Berlin
+	now := time.Date(2020, 1, 18, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		reminders []Reminder
+		prefs     map[string]Preferences
+		ticks     []time.Time
+		want      int
+	}{
+		{
+			name:      "due in default area",
+			reminders: []Reminder{{UserID: "U1", Time: "09:00"}},
+			ticks:     []time.Time{now},
+			want:      1,
+		},
+		{
+			name:      "due in user area",
+			reminders: []Reminder{{UserID: "U1", Time: "02:00", Area: "Europe/Berlin"}},
+			ticks:     []time.Time{now},
+			want:      1,
+		},
+		{
+			name:      "due in stored user timezone",
+			reminders: []Reminder{{UserID: "U1", Time: "02:00"}},
+			prefs:     map[string]Preferences{"U1": {UserID: "U1", Timezone: "Europe/Berlin"}},
+			ticks:     []time.Time{now},
+			want:      1,
+		},
+		{
+			name:  "user opted in through preferences",
+			prefs: map[string]Preferences{"U1": {UserID: "U1", Reminder: "09:00"}},
+			ticks: []time.Time{now},
+			want:  1,
+		},
+		{
+			name:      "user reminder overrides configured reminder",
+			reminders: []Reminder{{UserID: "U1", Time: "09:00"}},
+			prefs:     map[string]Preferences{"U1": {UserID: "U1", Reminder: "17:30"}},
+			ticks:     []time.Time{now},
+			want:      0,
+		},
+		{
+			name:      "not yet due",
+			reminders: []Reminder{{UserID: "U1", Time: "17:30"}},
+			ticks:     []time.Time{now},
+			want:      0,
+		},
+		{
+			name:      "delayed tick",
+			reminders: []Reminder{{UserID: "U1", Time: "09:00"}},
+			ticks:     []time.Time{now.Add(-30 * time.Second), now.Add(2 * time.Minute)},
+			want:      1,
+		},
+		{
+			name:      "due before the first tick",
+			reminders: []Reminder{{UserID: "U1", Time: "09:00"}},
+			ticks:     []time.Time{now.Add(time.Hour)},
+			want:      0,
+		},
+		{
+			name:      "sent only once per day",
+			reminders: []Reminder{{UserID: "U1", Time: "09:00"}},
+			ticks:     []time.Time{now, now.Add(30 * time.Second)},
+			want:      1,
+		},
+		{
+			name:      "sent again on the next day",
+			reminders: []Reminder{{UserID: "U1", Time: "09:00"}},
+			ticks:     []time.Time{now, now.Add(24 * time.Hour)},
+			want:      2,
+		},
+		{
+			name:      "invalid reminder is skipped",
+			reminders: []Reminder{{UserID: "U1", Time: "9am"}, {UserID: "U2", Time: "09:00"}},
+			ticks:     []time.Time{now},
+			want:      1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSlackStandIn("xoxb-test")
+			defer srv.Close()
+
+			s := &Scheduler{
+				Reminders:   tt.reminders,
+				Area:        "Asia/Manila",
+				Preferences: &memory{preferences: tt.prefs},
+				Client:      srv.client("xoxb-test"),
+			}
+			for _, tick := range tt.ticks {
+				s.Tick(tick)
+			}
+
+			got := srv.posted()
+			if len(got) != tt.want {
+				t.Fatalf("Scheduler.Tick() sent %d reminders, want %d", len(got), tt.want)
+			}
+			for _, msg := range got {
+				if len(msg.Blocks) != 2 || len(msg.Blocks[1].Elements) != 5 {
+					t.Errorf("Scheduler.Tick() sent %v, want a mood picker", msg)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduler_Tick_jobs(t *testing.T) {
+	// 2020-01-18 02:00:00 UTC is 10:00 on a Saturday in Asia/Manila
+	saturday := time.Date(2020, 1, 18, 2, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		job   Job
+		ticks []time.Time
+		want  int
+	}{
+		{name: "due", job: Job{Time: "10:00"}, ticks: []time.Time{saturday}, want: 1},
+		{name: "not yet due", job: Job{Time: "11:00"}, ticks: []time.Time{saturday}, want: 0},
+		{name: "not on weekends", job: Job{Time: "10:00", Days: weekdays}, ticks: []time.Time{saturday}, want: 0},
+		{name: "runs once per day", job: Job{Time: "10:00"}, ticks: []time.Time{saturday, saturday.Add(30 * time.Second)}, want: 1},
+		{name: "delayed tick", job: Job{Time: "10:00"}, ticks: []time.Time{saturday.Add(-time.Minute), saturday.Add(3 * time.Minute)}, want: 1},
+		{name: "due before the first tick", job: Job{Time: "10:00"}, ticks: []time.Time{saturday.Add(time.Hour)}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs := 0
+			tt.job.Name = tt.name
+			tt.job.Run = func(now time.Time) { runs++ }
+
+			s := &Scheduler{Jobs: []Job{tt.job}, Area: "Asia/Manila"}
+			for _, tick := range tt.ticks {
+				s.Tick(tick)
+			}
+			if runs != tt.want {
+				t.Errorf("Scheduler.Tick() ran job %d times, want %d", runs, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduler_Tick_queue(t *testing.T) {
+	srv := newSlackStandIn("xoxb-test")
+	defer srv.Close()
+
+	q := NewQueue(1, 1)
+	defer q.Close()
+	release := make(chan struct{})
+	defer close(release)
+
+	// 2020-01-18 01:00:00 UTC is 09:00 in Asia/Manila
+	now := time.Date(2020, 1, 18, 1, 0, 0, 0, time.UTC)
+	s := &Scheduler{
+		Reminders: []Reminder{{UserID: "U1", Time: "09:00"}},
+		Jobs:      []Job{{Name: "digest", Time: "09:00", Run: func(now time.Time) { <-release }}},
+		Area:      "Asia/Manila",
+		Client:    srv.client("xoxb-test"),
+		Queue:     q,
+	}
+
+	// The slow job doesn't hold up the reminder that is due at the same time
+	done := make(chan struct{})
+	go func() {
+		s.Tick(now)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Scheduler.Tick() waited for the job to finish")
+	}
+	if got := len(srv.posted()); got != 1 {
+		t.Errorf("Scheduler.Tick() sent %d reminders, want 1", got)
+	}
+}
+
+func TestScheduler_preferences(t *testing.T) {
+	// 2020-01-18 01:00:00 UTC is 09:00 in Asia/Manila
+	now := time.Date(2020, 1, 18, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		forget bool
+		want   int
+	}{
+		{name: "cached", forget: false, want: 0},
+		{name: "forgotten after saving", forget: true, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSlackStandIn("xoxb-test")
+			defer srv.Close()
+
+			db := &memory{}
+			s := &Scheduler{
+				Area:        "Asia/Manila",
+				Preferences: db,
+				Client:      srv.client("xoxb-test"),
+				TTL:         time.Minute,
+			}
+			s.Tick(now.Add(-time.Minute))
+
+			db.SavePreferences(Preferences{UserID: "U1", Reminder: "09:00"})
+			if tt.forget {
+				s.forgetPreferences()
+			}
+			s.Tick(now)
+
+			if got := len(srv.posted()); got != tt.want {
+				t.Errorf("Scheduler.Tick() sent %d reminders, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"4d63.com/tz"
+	log "github.com/sirupsen/logrus"
+)
+
+// minTeamSize is the least number of participants needed before a team
+// digest is produced, so that no one can be singled out.
+const minTeamSize = 3
+
+// Report contains the digests of all logs within a period.
+type Report struct {
+	Since, Until time.Time
+	Users        []UserDigest
+	Team         *TeamDigest // nil if there are too few participants
+}
+
+// UserDigest summarizes the logs of a single user. This is only ever sent
+// privately to the user.
+type UserDigest struct {
+	UserID  string
+	Count   int
+	Average float64
+	Best    LogItem
+	Worst   LogItem
+	Notes   []string // Highlights from the best and worst logs
+}
+
+// TeamDigest summarizes the logs of everyone without referring to anyone.
+type TeamDigest struct {
+	Participants int
+	Count        int
+	Average      float64
+	Distribution [5]int         // Number of logs for each mood level
+	Daily        []DailyAverage // Average mood level for each day
+}
+
+// DailyAverage is the average mood level within a day.
+type DailyAverage struct {
+	Day     time.Time
+	Average float64
+}
+
+// LastDays returns the period covering the given number of full days before
+// the day of now, in the given IANA-compliant area.
+func LastDays(now time.Time, area string, days int) (time.Time, time.Time, error) {
+	loc, err := tz.LoadLocation(area)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("cannot find location: %s", area)
+	}
+	now = now.In(loc)
+	until := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	return until.AddDate(0, 0, -days), until, nil
+}
+
+// ParseWeekday converts the name of a day, e.g. Monday, into a time.Weekday.
+func ParseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), s) {
+			return d, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("unknown day: %s", s)
+}
+
+// BuildReport queries the logs within [since, until) and creates the digests.
+// Users who opted out of team reports through their preferences are left out
+// of the team digest.
+func BuildReport(db DBQuerier, prefs PreferenceStore, since, until time.Time) (*Report, error) {
+	items, err := db.QueryDB(Query{Since: since, Until: until})
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := make(map[string]bool)
+	if prefs != nil {
+		list, err := prefs.ListPreferences()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range list {
+			hidden[p.UserID] = p.HideFromReports
+		}
+	}
+
+	byUser := make(map[string][]LogItem)
+	team := []LogItem{}
+	skipped := 0
+	for _, item := range items {
+		// Rows written outside the barometer may have any measure
+		if item.Measure < 1 || item.Measure > 5 {
+			skipped++
+			continue
+		}
+		byUser[item.UserID] = append(byUser[item.UserID], item)
+		if !hidden[item.UserID] {
+			team = append(team, item)
+		}
+	}
+
+	if skipped > 0 {
+		log.WithFields(log.Fields{"count": skipped}).Warn("skipped logs with a measure outside [1, 5]")
+	}
+
+	report := &Report{Since: since, Until: until}
+	for userID, items := range byUser {
+		report.Users = append(report.Users, userDigest(userID, items))
+	}
+	sort.Slice(report.Users, func(i, j int) bool { return report.Users[i].UserID < report.Users[j].UserID })
+
+	report.Team = teamDigest(team)
+	return report, nil
+}
+
+func userDigest(userID string, items []LogItem) UserDigest {
+	d := UserDigest{UserID: userID, Count: len(items), Average: average(items)}
+	d.Best, d.Worst = items[0], items[0]
+	for _, item := range items {
+		if item.Measure > d.Best.Measure {
+			d.Best = item
+		}
+		if item.Measure < d.Worst.Measure {
+			d.Worst = item
+		}
+	}
+	for _, item := range []LogItem{d.Best, d.Worst} {
+		if item.Notes != "" && (len(d.Notes) == 0 || d.Notes[0] != item.Notes) {
+			d.Notes = append(d.Notes, item.Notes)
+		}
+	}
+	return d
+}
+
+func teamDigest(items []LogItem) *TeamDigest {
+	participants := make(map[string]bool)
+	for _, item := range items {
+		participants[item.UserID] = true
+	}
+	if len(participants) < minTeamSize {
+		return nil
+	}
+
+	d := &TeamDigest{Participants: len(participants), Count: len(items), Average: average(items)}
+	days := make(map[string][]LogItem)
+	for _, item := range items {
+		d.Distribution[item.Measure-1]++
+		key := item.Timestamp.Format("2006-01-02")
+		days[key] = append(days[key], item)
+	}
+	for _, items := range days {
+		t := items[0].Timestamp
+		d.Daily = append(d.Daily, DailyAverage{
+			Day:     time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()),
+			Average: average(items),
+		})
+	}
+	sort.Slice(d.Daily, func(i, j int) bool { return d.Daily[i].Day.Before(d.Daily[j].Day) })
+	return d
+}
+
+// Formats for writing reports
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+var (
+	reportFuncs = map[string]interface{}{
+		"date":  func(t time.Time) string { return t.Format("Mon, Jan 2") },
+		"level": func(i int) int { return i + 1 },
+	}
+	userMarkdown = template.Must(template.New("user").Funcs(reportFuncs).Parse(
+		`# Your week in Burnout Barometer
+
+*{{date .Since}} to {{date .Last}}*
+
+- **Logs**: {{.User.Count}}
+- **Average mood**: {{printf "%.1f" .User.Average}}
+- **Best day**: {{date .User.Best.Timestamp}} ({{.User.Best.Measure}})
+- **Toughest day**: {{date .User.Worst.Timestamp}} ({{.User.Worst.Measure}})
+{{if .User.Notes}}
+## Highlights
+{{range .User.Notes}}
+> {{.}}
+{{end}}{{end}}`))
+	teamMarkdown = template.Must(template.New("team").Funcs(reportFuncs).Parse(
+		`# Team week in Burnout Barometer
+
+*{{date .Since}} to {{date .Last}}*
+
+- **Participants**: {{.Team.Participants}}
+- **Logs**: {{.Team.Count}}
+- **Average mood**: {{printf "%.1f" .Team.Average}}
+
+| Day | Average mood |
+|-----|--------------|
+{{range .Team.Daily}}| {{date .Day}} | {{printf "%.1f" .Average}} |
+{{end}}
+| Mood level | Logs |
+|------------|------|
+{{range $i, $n := .Team.Distribution}}| {{level $i}} | {{$n}} |
+{{end}}`))
+	userHTML = htmltemplate.Must(htmltemplate.New("user").Funcs(reportFuncs).Parse(
+		`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Your week in Burnout Barometer</title></head>
+<body>
+<h1>Your week in Burnout Barometer</h1>
+<p><em>{{date .Since}} to {{date .Last}}</em></p>
+<ul>
+<li><strong>Logs</strong>: {{.User.Count}}</li>
+<li><strong>Average mood</strong>: {{printf "%.1f" .User.Average}}</li>
+<li><strong>Best day</strong>: {{date .User.Best.Timestamp}} ({{.User.Best.Measure}})</li>
+<li><strong>Toughest day</strong>: {{date .User.Worst.Timestamp}} ({{.User.Worst.Measure}})</li>
+</ul>
+{{if .User.Notes}}<h2>Highlights</h2>
+{{range .User.Notes}}<blockquote>{{.}}</blockquote>
+{{end}}{{end}}</body></html>
+`))
+	teamHTML = htmltemplate.Must(htmltemplate.New("team").Funcs(reportFuncs).Parse(
+		`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Team week in Burnout Barometer</title></head>
+<body>
+<h1>Team week in Burnout Barometer</h1>
+<p><em>{{date .Since}} to {{date .Last}}</em></p>
+<ul>
+<li><strong>Participants</strong>: {{.Team.Participants}}</li>
+<li><strong>Logs</strong>: {{.Team.Count}}</li>
+<li><strong>Average mood</strong>: {{printf "%.1f" .Team.Average}}</li>
+</ul>
+<table>
+<tr><th>Day</th><th>Average mood</th></tr>
+{{range .Team.Daily}}<tr><td>{{date .Day}}</td><td>{{printf "%.1f" .Average}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Mood level</th><th>Logs</th></tr>
+{{range $i, $n := .Team.Distribution}}<tr><td>{{level $i}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+)
+
+type reportData struct {
+	Since, Last time.Time
+	User        UserDigest
+	Team        *TeamDigest
+}
+
+// RenderUser writes the digest of a user in the given format.
+func (r *Report) RenderUser(w io.Writer, d UserDigest, format string) error {
+	data := reportData{Since: r.Since, Last: r.Until.Add(-time.Nanosecond), User: d}
+	switch format {
+	case FormatMarkdown:
+		return userMarkdown.Execute(w, data)
+	case FormatHTML:
+		return userHTML.Execute(w, data)
+	}
+	return fmt.Errorf("unknown report format: %s", format)
+}
+
+// RenderTeam writes the team digest in the given format.
+func (r *Report) RenderTeam(w io.Writer, format string) error {
+	if r.Team == nil {
+		return fmt.Errorf("not enough participants for a team digest, need at least %d", minTeamSize)
+	}
+	data := reportData{Since: r.Since, Last: r.Until.Add(-time.Nanosecond), Team: r.Team}
+	switch format {
+	case FormatMarkdown:
+		return teamMarkdown.Execute(w, data)
+	case FormatHTML:
+		return teamHTML.Execute(w, data)
+	}
+	return fmt.Errorf("unknown report format: %s", format)
+}
+
+// WriteFiles writes each digest into its own file inside a directory.
+func (r *Report) WriteFiles(dir, format string) ([]string, error) {
+	ext := "md"
+	if format == FormatHTML {
+		ext = "html"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	date := r.Until.Format("2006-01-02")
+	paths := []string{}
+	write := func(name string, render func(w io.Writer) error) error {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%s.%s", name, date, ext))
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := render(file); err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	}
+
+	for _, d := range r.Users {
+		d := d
+		if err := write(d.UserID, func(w io.Writer) error { return r.RenderUser(w, d, format) }); err != nil {
+			return paths, err
+		}
+	}
+	if r.Team != nil {
+		if err := write("team", func(w io.Writer) error { return r.RenderTeam(w, format) }); err != nil {
+			return paths, err
+		}
+	}
+	return paths, nil
+}
+
+// Send delivers the digests through Slack. Each user receives their own
+// digest by direct message, and the team digest is posted to the channel if
+// one is given.
+func (r *Report) Send(client *SlackClient, channel string) error {
+	for _, d := range r.Users {
+		if platformUser(d.UserID) {
+			continue
+		}
+		var b bytes.Buffer
+		if err := r.RenderUser(&b, d, FormatMarkdown); err != nil {
+			return err
+		}
+		if err := client.SendDirectMessage(d.UserID, &Message{Text: slackMarkdown(b.String())}); err != nil {
+			log.WithFields(log.Fields{"err": err, "user": d.UserID}).Error("SlackClient.SendDirectMessage")
+		}
+	}
+
+	if channel == "" {
+		return nil
+	}
+	if r.Team == nil {
+		log.Info("not enough participants for a team digest, skipping")
+		return nil
+	}
+	var b bytes.Buffer
+	if err := r.RenderTeam(&b, FormatMarkdown); err != nil {
+		return err
+	}
+	return client.PostMessage(channel, &Message{Text: slackMarkdown(b.String())})
+}
+
+// SendReports builds the digests within [since, until) and delivers them
+// through Slack, as in Report.Send. If the app is installed into several
+// workspaces, each gets the digests of its own team through its bot. The
+// channel belongs to a single workspace, so no team digest is posted then.
+// It returns the number of user digests that were built.
+func SendReports(db DBQuerier, prefs PreferenceStore, w *Workspaces, client *SlackClient, channel string, since, until time.Time) (int, error) {
+	if w == nil {
+		report, err := BuildReport(db, prefs, since, until)
+		if err != nil {
+			return 0, err
+		}
+		return len(report.Users), report.Send(client, channel)
+	}
+
+	installs, err := w.List()
+	if err != nil {
+		return 0, err
+	}
+	sent, failed := 0, 0
+	for _, i := range installs {
+		report, err := BuildReport(teamQuerier{db, i.TeamID}, prefs, since, until)
+		if err == nil {
+			err = report.Send(w.client(i.BotToken), "")
+			sent += len(report.Users)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"err": err, "team": i.TeamID}).Error("Report.Send")
+			failed++
+		}
+	}
+	if failed > 0 {
+		return sent, fmt.Errorf("cannot send the digests of %d workspaces", failed)
+	}
+	return sent, nil
+}
+
+// slackMarkdown converts the Markdown reports into Slack's mrkdwn. Headers
+// become bold text and tables are wrapped in code blocks since Slack can't
+// render them.
+func slackMarkdown(s string) string {
+	out := []string{}
+	inTable := false
+	for _, line := range strings.Split(s, "\n") {
+		isRow := strings.HasPrefix(line, "|")
+		if isRow != inTable {
+			out = append(out, "```")
+			inTable = isRow
+		}
+		if strings.HasPrefix(line, "#") {
+			line = fmt.Sprintf("*%s*", strings.TrimSpace(strings.TrimLeft(line, "#")))
+		}
+		out = append(out, strings.Replace(line, "**", "*", -1))
+	}
+	if inTable {
+		out = append(out, "```")
+	}
+	return strings.Join(out, "\n")
+}
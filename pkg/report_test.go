@@ -0,0 +1,174 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	until := time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)
+	since := until.AddDate(0, 0, -7)
+	last := until.Add(-time.Hour)
+
+	items := []LogItem{}
+	items = append(items, logs("U1", last, 4, 2, 5)...)
+	items = append(items, logs("U2", last, 3, 3)...)
+	items = append(items, logs("U3", last, 1)...)
+	items = append(items, LogItem{UserID: "U1", Measure: 1, Timestamp: since.Add(-time.Hour)})
+
+	tests := []struct {
+		name      string
+		prefs     map[string]Preferences
+		extra     []LogItem
+		wantUsers int
+		wantTeam  int // number of participants, or 0 if no team digest
+	}{
+		{name: "happy path", wantUsers: 3, wantTeam: 3},
+		{name: "measures out of range are skipped", extra: logs("U4", last, 0, 9), wantUsers: 3, wantTeam: 3},
+		{
+			name:      "opted out of team reports",
+			prefs:     map[string]Preferences{"U3": {UserID: "U3", HideFromReports: true}},
+			wantUsers: 3,
+			wantTeam:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memory{items: append(append([]LogItem{}, items...), tt.extra...), preferences: tt.prefs}
+			got, err := BuildReport(db, db, since, until)
+			if err != nil {
+				t.Fatalf("BuildReport() error = %v", err)
+			}
+			if len(got.Users) != tt.wantUsers {
+				t.Errorf("BuildReport() got %d user digests, want %d", len(got.Users), tt.wantUsers)
+			}
+			if tt.wantTeam == 0 && got.Team != nil {
+				t.Errorf("BuildReport() got team digest %+v, want none", *got.Team)
+			}
+			if tt.wantTeam > 0 && (got.Team == nil || got.Team.Participants != tt.wantTeam) {
+				t.Errorf("BuildReport() got team digest %+v, want %d participants", got.Team, tt.wantTeam)
+			}
+
+			// Logs outside the period are not included
+			u1 := got.Users[0]
+			if u1.Count != 3 || u1.Best.Measure != 5 || u1.Worst.Measure != 2 {
+				t.Errorf("BuildReport() got user digest %+v", u1)
+			}
+		})
+	}
+}
+
+func TestReport_Render(t *testing.T) {
+	until := time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)
+	items := []LogItem{}
+	items = append(items, logs("U1", until.Add(-time.Hour), 4, 2)...)
+	items[0].Notes = "<b>shipped it</b>"
+	items = append(items, logs("U2", until.Add(-time.Hour), 3)...)
+	items = append(items, logs("U3", until.Add(-time.Hour), 5)...)
+
+	report, err := BuildReport(&memory{items: items}, nil, until.AddDate(0, 0, -7), until)
+	if err != nil {
+		t.Fatalf("BuildReport() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{name: "markdown", format: FormatMarkdown, want: "> <b>shipped it</b>"},
+		{name: "html", format: FormatHTML, want: "<blockquote>&lt;b&gt;shipped it&lt;/b&gt;</blockquote>"},
+		{name: "unknown format", format: "pdf", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := report.RenderUser(&b, report.Users[0], tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Report.RenderUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !strings.Contains(b.String(), tt.want) {
+				t.Errorf("Report.RenderUser() = %s, want %s", b.String(), tt.want)
+			}
+
+			// The team digest never mentions anyone
+			b.Reset()
+			err = report.RenderTeam(&b, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Report.RenderTeam() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Contains(b.String(), "U1") || strings.Contains(b.String(), "shipped") {
+				t.Errorf("Report.RenderTeam() = %s, want an anonymised digest", b.String())
+			}
+		})
+	}
+}
+
+func TestReport_WriteFiles(t *testing.T) {
+	until := time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)
+	report, err := BuildReport(&memory{items: logs("U1", until.Add(-time.Hour), 4)}, nil, until.AddDate(0, 0, -7), until)
+	if err != nil {
+		t.Fatalf("BuildReport() error = %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "reports")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths, err := report.WriteFiles(dir, FormatHTML)
+	if err != nil {
+		t.Fatalf("Report.WriteFiles() error = %v", err)
+	}
+	if len(paths) != 1 || !strings.HasSuffix(paths[0], "U1-2020-01-20.html") {
+		t.Errorf("Report.WriteFiles() = %v, want a single user digest", paths)
+	}
+}
+
+func TestReport_Send(t *testing.T) {
+	until := time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)
+	items := []LogItem{}
+	for _, u := range []string{"U1", "U2", "U3"} {
+		items = append(items, logs(u, until.Add(-time.Hour), 4)...)
+	}
+	report, err := BuildReport(&memory{items: items}, nil, until.AddDate(0, 0, -7), until)
+	if err != nil {
+		t.Fatalf("BuildReport() error = %v", err)
+	}
+
+	srv := newSlackStandIn("xoxb-test")
+	defer srv.Close()
+	if err := report.Send(srv.client("xoxb-test"), "C123"); err != nil {
+		t.Fatalf("Report.Send() error = %v", err)
+	}
+
+	got := srv.posted()
+	if len(got) != 4 || got[3].Channel != "C123" {
+		t.Errorf("Report.Send() posted %v, want three digests and the team digest", got)
+	}
+}
+
+func TestLastDays(t *testing.T) {
+	// 2020-01-19 20:00 UTC is already 2020-01-20 in Asia/Manila
+	now := time.Date(2020, 1, 19, 20, 0, 0, 0, time.UTC)
+	since, until, err := LastDays(now, "Asia/Manila", 7)
+	if err != nil {
+		t.Fatalf("LastDays() error = %v", err)
+	}
+	if got := until.Format("2006-01-02 15:04"); got != "2020-01-20 00:00" {
+		t.Errorf("LastDays() until = %s", got)
+	}
+	if got := since.Format("2006-01-02 15:04"); got != "2020-01-13 00:00" {
+		t.Errorf("LastDays() since = %s", got)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RocketChatTransport receives logs through a Rocket.Chat outgoing webhook,
+// triggered by a word such as "/barometer". The token is part of the JSON
+// body instead of a form.
+type RocketChatTransport struct {
+	// Tokens is a comma-separated list of the tokens of the webhooks.
+	Tokens string
+}
+
+// rocketChatMessage is the part of an outgoing webhook request that the
+// barometer needs. See https://docs.rocket.chat/use-rocket.chat/workspace-administration/integrations
+type rocketChatMessage struct {
+	Token       string          `json:"token"`
+	Bot         json.RawMessage `json:"bot"`
+	ChannelID   string          `json:"channel_id"`
+	UserID      string          `json:"user_id"`
+	UserName    string          `json:"user_name"`
+	Text        string          `json:"text"`
+	TriggerWord string          `json:"trigger_word"`
+	Timestamp   time.Time       `json:"timestamp"`
+}
+
+// Verify checks the token of the webhook.
+func (t *RocketChatTransport) Verify(r *http.Request, body []byte) error {
+	m := rocketChatMessage{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return err
+	}
+	return verifyTokens(m.Token, t.Tokens)
+}
+
+// Parse extracts the log from the message, without its trigger word.
+// Messages of bots are rejected, so that the barometer never replies to
+// itself.
+func (t *RocketChatTransport) Parse(r *http.Request, body []byte) (*Submission, error) {
+	m := rocketChatMessage{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	if bot := string(m.Bot); bot != "" && bot != "false" && bot != "null" {
+		return nil, fmt.Errorf("message sent by a bot")
+	}
+	if m.UserID == "" {
+		return nil, fmt.Errorf("missing user")
+	}
+	return &Submission{
+		From: LogContext{
+			UserID:    "rocketchat:" + m.UserID,
+			UserName:  m.UserName,
+			ChannelID: m.ChannelID,
+		},
+		Text:      strings.TrimPrefix(strings.TrimSpace(m.Text), m.TriggerWord),
+		Timestamp: m.Timestamp,
+	}, nil
+}
+
+// Reply responds with the text and attachments of the message. Rocket.Chat
+// posts the reply in the channel, as webhooks can't reply privately.
+func (t *RocketChatTransport) Reply(w http.ResponseWriter, msg *Message) error {
+	type response struct {
+		Text        string       `json:"text"`
+		Attachments []Attachment `json:"attachments,omitempty"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response{Text: msg.Text, Attachments: msg.Attachments})
+}
+
+// rocketChat returns the Rocket.Chat transport, or nil if it's not
+// configured. It must be called on a snapshot of the Server.
+func (s *Server) rocketChat() Transport {
+	if s.Config.RocketChatTokens == "" {
+		return nil
+	}
+	return &RocketChatTransport{Tokens: s.Config.RocketChatTokens}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const rocketChatWebhook = `{
+	"token": "t1",
+	"bot": false,
+	"channel_id": "GENERAL",
+	"message_id": "m1",
+	"timestamp": "2020-05-04T03:02:01.000Z",
+	"user_id": "user1",
+	"user_name": "jane",
+	"text": "/barometer 3 long review day",
+	"trigger_word": "/barometer"
+}`
+
+func TestRocketChatTransport_Verify(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  string
+		body    string
+		wantErr bool
+	}{
+		{name: "token", tokens: "t0,t1", body: rocketChatWebhook, wantErr: false},
+		{name: "wrong token", tokens: "t2", body: rocketChatWebhook, wantErr: true},
+		{name: "malformed", tokens: "t1", body: `{"token": `, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &RocketChatTransport{Tokens: tt.tokens}
+			if err := tr.Verify(httptest.NewRequest(http.MethodPost, "/rocketchat/webhook", nil), []byte(tt.body)); (err != nil) != tt.wantErr {
+				t.Errorf("RocketChatTransport.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRocketChatTransport_Parse(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantText string
+		wantErr  bool
+	}{
+		{name: "message", body: rocketChatWebhook, wantText: "3 long review day"},
+		{name: "without trigger word", body: `{"user_id": "user1", "text": "4"}`, wantText: "4"},
+		{name: "bot", body: `{"user_id": "bot1", "bot": {"i": "abc"}, "text": "3"}`, wantErr: true},
+		{name: "missing user", body: `{"text": "3"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &RocketChatTransport{}
+			got, err := tr.Parse(httptest.NewRequest(http.MethodPost, "/rocketchat/webhook", nil), []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RocketChatTransport.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && strings.TrimSpace(got.Text) != tt.wantText {
+				t.Errorf("RocketChatTransport.Parse() text = %q, want %q", got.Text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestServer_handleRocketChat(t *testing.T) {
+	db := &memory{}
+	s := &Server{Config: &Configuration{Area: "Asia/Manila", RocketChatTokens: "t1"}, database: db}
+	rec := httptest.NewRecorder()
+	s.handleTransport("/rocketchat/webhook", s.rocketChat)(rec, httptest.NewRequest(http.MethodPost, "/rocketchat/webhook", strings.NewReader(rocketChatWebhook)))
+
+	got := map[string]interface{}{}
+	if err := json.NewDecoder(rec.Body).Decode(&got); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("handleRocketChat() status = %d, error = %v", rec.Code, err)
+	}
+	if text, _ := got["text"].(string); !strings.HasPrefix(text, ackPrefix) {
+		t.Errorf("handleRocketChat() text = %q, want %q", text, ackPrefix)
+	}
+	if _, ok := got["response_type"]; ok {
+		t.Errorf("handleRocketChat() = %v, want no response_type", got)
+	}
+
+	items, _ := db.QueryDB(Query{})
+	if len(items) != 1 || items[0].UserID != "rocketchat:user1" || items[0].Measure != 3 || items[0].Notes != "long review day" {
+		t.Fatalf("handleRocketChat() stored %+v", items)
+	}
+	if items[0].Timestamp.UTC().Format("2006-01-02 15:04:05") != "2020-05-04 03:02:01" {
+		t.Errorf("handleRocketChat() stored timestamp %v", items[0].Timestamp)
+	}
+}
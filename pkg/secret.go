@@ -0,0 +1,300 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SecretsKeyEnvVar is the environment variable holding the base64-encoded
+// 256-bit key of the encrypted secrets file.
+const SecretsKeyEnvVar = "BB_SECRETS_KEY"
+
+// secretFields are the fields of the Configuration that hold secrets. Their
+// values are either references to a SecretSource, such as
+// "file:///run/secrets/slack_token", or base64-encoded secrets for backward
+// compatibility.
+var secretFields = func() []string {
+	fields := []string{}
+	for _, o := range ConfigurationOptions() {
+		if o.Secret {
+			fields = append(fields, o.field)
+		}
+	}
+	return fields
+}()
+
+// EncodeSecret prepares a secret for storing in the configuration file.
+// References such as "env:SLACK_TOKEN" are kept as-is, and other secrets are
+// base64-encoded.
+func EncodeSecret(secret string) string {
+	sources := DefaultSecretSources(os.LookupEnv)
+	sources["secret"] = nil
+	if _, _, ok := sources.parseReference(secret); ok || secret == "" {
+		return secret
+	}
+	return base64.StdEncoding.EncodeToString([]byte(secret))
+}
+
+// SecretSource looks up a secret by its name.
+type SecretSource interface {
+	Secret(name string) (string, error)
+}
+
+// SecretSourceFunc adapts an ordinary function to a SecretSource.
+type SecretSourceFunc func(name string) (string, error)
+
+// Secret calls f(name).
+func (f SecretSourceFunc) Secret(name string) (string, error) {
+	return f(name)
+}
+
+// SecretSources maps the scheme of a secret reference to its source. The
+// "secret" scheme, for the encrypted secrets file, is added by the
+// Configuration when SECRETS_FILE is set.
+type SecretSources map[string]SecretSource
+
+// DefaultSecretSources returns the sources for "env:NAME" references, which
+// are read from the environment through lookup, and "file:///path"
+// references, which are read from the file system (e.g. Docker or Kubernetes
+// secrets).
+func DefaultSecretSources(lookup func(string) (string, bool)) SecretSources {
+	return SecretSources{
+		"env": SecretSourceFunc(func(name string) (string, error) {
+			value, ok := lookup(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			return value, nil
+		}),
+		"file": SecretSourceFunc(func(path string) (string, error) {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(string(b)), nil
+		}),
+	}
+}
+
+// parseReference splits a secret reference into its scheme and name. It
+// returns false if value is not a reference to one of the sources.
+func (s SecretSources) parseReference(value string) (string, string, bool) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	if _, ok := s[parts[0]]; !ok {
+		return "", "", false
+	}
+
+	name := strings.TrimPrefix(parts[1], "//")
+	if u, err := url.Parse(value); err == nil && parts[0] == "file" && u.Path != "" {
+		name = u.Path
+	}
+	return parts[0], name, true
+}
+
+// Resolve returns the secret that value refers to. Values that are not
+// references are returned as-is.
+func (s SecretSources) Resolve(value string) (string, error) {
+	scheme, name, ok := s.parseReference(value)
+	if !ok {
+		return value, nil
+	}
+	secret, err := s[scheme].Secret(name)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve secret %s: %v", value, err)
+	}
+	return secret, nil
+}
+
+// decodeSecrets base64-decodes the secrets of the configuration that are
+// not references, as they're stored by `barometer init`.
+func (cfg *Configuration) decodeSecrets(sources SecretSources) error {
+	for _, field := range secretFields {
+		enc := reflect.ValueOf(*cfg).FieldByName(field).String()
+		if _, _, ok := sources.parseReference(enc); ok {
+			continue
+		}
+		dec, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return err
+		}
+		cfg.update(field, string(dec))
+	}
+	return nil
+}
+
+// resolveSecrets replaces the secret references of the configuration with
+// the secrets they refer to.
+func (cfg *Configuration) resolveSecrets(sources SecretSources) error {
+	for _, field := range secretFields {
+		secret, err := sources.Resolve(reflect.ValueOf(*cfg).FieldByName(field).String())
+		if err != nil {
+			return err
+		}
+		cfg.update(field, secret)
+	}
+	return nil
+}
+
+// secretSources returns the sources for resolving the secrets of the
+// configuration, including its encrypted secrets file if any.
+func (cfg *Configuration) secretSources(lookup func(string) (string, bool)) SecretSources {
+	sources := DefaultSecretSources(lookup)
+	if cfg.SecretsFile != "" {
+		sources["secret"] = SecretSourceFunc(func(name string) (string, error) {
+			key, _ := lookup(SecretsKeyEnvVar)
+			f, err := OpenSecretsFile(cfg.SecretsFile, key)
+			if err != nil {
+				return "", err
+			}
+			return f.Secret(name)
+		})
+	}
+	return sources
+}
+
+// SecretsFile is a file of named secrets encrypted with AES-256-GCM. Secrets
+// in it are referred to as "secret:NAME" in the configuration.
+type SecretsFile struct {
+	Path    string
+	key     []byte
+	secrets map[string]string
+}
+
+// NewSecretsKey generates a random base64-encoded key for a SecretsFile.
+func NewSecretsKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// OpenSecretsFile decrypts the secrets file at path with the base64-encoded
+// key. A file that does not exist yet is opened as empty.
+func OpenSecretsFile(path string, key string) (*SecretsFile, error) {
+	k, err := parseSecretsKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f := &SecretsFile{Path: path, key: k, secrets: map[string]string{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := decrypt(f.key, strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt secrets file %s: %v", path, err)
+	}
+	if err := json.Unmarshal(plain, &f.secrets); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Secret returns the secret with the given name.
+func (f *SecretsFile) Secret(name string) (string, error) {
+	secret, ok := f.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("no secret named %s in %s", name, f.Path)
+	}
+	return secret, nil
+}
+
+// Set adds or replaces a secret. Call Save to write it to the file.
+func (f *SecretsFile) Set(name, secret string) {
+	f.secrets[name] = secret
+}
+
+// Names returns the sorted names of all secrets in the file.
+func (f *SecretsFile) Names() []string {
+	names := []string{}
+	for name := range f.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the secrets and writes them to the file.
+func (f *SecretsFile) Save() error {
+	plain, err := json.Marshal(f.secrets)
+	if err != nil {
+		return err
+	}
+	sealed, err := encrypt(f.key, plain)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.Path, []byte(sealed+"\n"), 0600)
+}
+
+// parseSecretsKey decodes a key generated by NewSecretsKey.
+func parseSecretsKey(key string) ([]byte, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) != 32 {
+		return nil, fmt.Errorf("%s must be a base64-encoded 256-bit key", SecretsKeyEnvVar)
+	}
+	return k, nil
+}
+
+// encrypt seals data with AES-256-GCM, and returns the nonce and ciphertext
+// base64-encoded.
+func encrypt(key, plain []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// decrypt opens data sealed by encrypt.
+func decrypt(key []byte, data string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("malformed ciphertext")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong key or corrupted data")
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
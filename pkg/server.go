@@ -5,17 +5,25 @@
 package pkg
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"4d63.com/tz"
 	"github.com/dghubble/go-twitter/twitter"
 	"github.com/julienschmidt/httprouter"
 	log "github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
@@ -26,127 +34,580 @@ type Server struct {
 	Router *httprouter.Router
 	Config *Configuration
 
-	database DBInserter
+	// Loader loads the configuration again on SIGHUP or whenever the file at
+	// ConfigPath changes. Reloading is disabled if Loader is nil.
+	Loader     func() (*Configuration, error)
+	ConfigPath string
+
+	// mu guards Config and the clients built from it
+	mu          sync.RWMutex
+	database    DBInserter
+	preferences PreferenceStore
+	slack       *SlackClient
+	workspaces  *Workspaces
+	alerts      *AlertEngine
+	scheduler   *Scheduler
+	users       *RateLimiter
+	ips         *RateLimiter
+	proxies     []*net.IPNet
+	timezones   *cache
+	apiTokens   *cache
+	queue       *Queue
+	stop        chan struct{}
+
+	// http serves the requests until Shutdown, which closes done. If TLS is
+	// enabled, redirect sends plain HTTP requests to HTTPS.
+	http         *http.Server
+	redirect     *http.Server
+	tracing      *sdktrace.TracerProvider
+	shutdownOnce sync.Once
+	shutdownErr  error
+	done         chan struct{}
 
 	// If true, then message will not insert into the database. Useful for testing.
 	Debug bool
+
+	// Version is reported by the /version endpoint.
+	Version string
 }
 
 // Routes contain all handler functions that respond to GET or POST requests.
 func (s *Server) Routes() {
 	log.Debug("serving routes")
-	s.Router.HandlerFunc(http.MethodPost, "/log", s.handleLog())
-	s.Router.HandlerFunc(http.MethodGet, "/", s.handleIndex())
+
+	// Endpoints that log moods are rate-limited, and served from a snapshot
+	// of the configuration like the other endpoints that depend on it
+	limited := func(h func(s *Server) http.HandlerFunc) http.HandlerFunc {
+		return s.locked(func(s *Server) http.HandlerFunc { return s.rateLimited(h(s)) })
+	}
+	transport := func(route string, t func(s *Server) Transport) func(s *Server) http.HandlerFunc {
+		return func(s *Server) http.HandlerFunc {
+			return s.handleTransport(route, func() Transport { return t(s) })
+		}
+	}
+
+	s.Router.HandlerFunc(http.MethodPost, "/log", instrument("/log", traced("handleLog", limited((*Server).handleLog))))
+	s.Router.HandlerFunc(http.MethodPost, "/interactions", instrument("/interactions", traced("handleInteraction", limited((*Server).handleInteraction))))
+	s.Router.HandlerFunc(http.MethodPost, "/teams/messages", instrument("/teams/messages", traced("handleTeams", limited(transport("/teams/messages", (*Server).teams)))))
+	s.Router.HandlerFunc(http.MethodPost, "/discord/interactions", instrument("/discord/interactions", traced("handleDiscord", limited(transport("/discord/interactions", (*Server).discord)))))
+	s.Router.HandlerFunc(http.MethodPost, "/mattermost/command", instrument("/mattermost/command", traced("handleMattermost", limited(transport("/mattermost/command", (*Server).mattermost)))))
+	s.Router.HandlerFunc(http.MethodPost, "/rocketchat/webhook", instrument("/rocketchat/webhook", traced("handleRocketChat", limited(transport("/rocketchat/webhook", (*Server).rocketChat)))))
+	s.Router.HandlerFunc(http.MethodPost, "/api/v1/logs", instrument("/api/v1/logs", traced("handleAPICreateLog", limited((*Server).handleAPICreateLog))))
+	s.Router.HandlerFunc(http.MethodGet, "/api/v1/logs", instrument("/api/v1/logs", traced("handleAPIListLogs", limited((*Server).handleAPIListLogs))))
+	s.Router.HandlerFunc(http.MethodGet, "/", instrument("/", s.handleIndex()))
+	s.Router.HandlerFunc(http.MethodGet, "/slack/install", instrument("/slack/install", s.locked((*Server).handleSlackInstall)))
+	s.Router.HandlerFunc(http.MethodGet, "/slack/oauth/callback", instrument("/slack/oauth/callback", s.locked((*Server).handleSlackOAuthCallback)))
+	s.Router.HandlerFunc(http.MethodPost, "/slack/events", instrument("/slack/events", s.locked((*Server).handleSlackEvents)))
+	s.Router.HandlerFunc(http.MethodGet, "/healthz", s.handleHealthz())
+	s.Router.HandlerFunc(http.MethodGet, "/readyz", s.locked((*Server).handleReadyz))
+	s.Router.HandlerFunc(http.MethodGet, "/version", s.handleVersion())
+	s.Router.HandlerFunc(http.MethodGet, "/metrics", s.handleMetrics())
 }
 
-// Start command starts a server on a specific port.
+// Start command starts a server on a specific port. It blocks until the
+// server is shut down through SIGINT, SIGTERM or Shutdown, and returns an
+// error if the server cannot be started.
 func (s *Server) Start() error {
+	if err := s.setup(); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.Port))
+	if err != nil {
+		s.Shutdown(context.Background())
+		return err
+	}
 	log.Infof("listening to port %d", s.Port)
+	return s.serve(ln)
+}
+
+// setup validates the configuration, builds the clients that depend on it,
+// starts the background jobs and prepares the HTTP server.
+func (s *Server) setup() error {
+	// Fail early on configuration mistakes instead of on the first request
+	if err := s.Config.Validate(); err != nil {
+		return err
+	}
 
-	// Identify the scheme for the database connection
-	db, err := NewDBInserter(s.Config.Table)
+	// Prepare the HTTP server first, as nothing needs to be stopped if its
+	// certificates can't be loaded
+	s.http = &http.Server{
+		Handler:      withRequestID(s.Router),
+		ReadTimeout:  duration(s.Config.ReadTimeout, defaultReadTimeout),
+		WriteTimeout: duration(s.Config.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:  duration(s.Config.IdleTimeout, defaultIdleTimeout),
+	}
+	if err := s.configureTLS(); err != nil {
+		return err
+	}
+
+	// Build the database and Slack clients, and start the scheduler. Traces
+	// are exported if a collector is configured, otherwise spans are no-ops.
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	s.queue = NewQueue(100, 2)
+	svc, err := s.build(s.Config)
 	if err != nil {
+		s.queue.Close()
 		return err
 	}
-	s.database = db
+	s.mu.Lock()
+	s.configureTracing(s.Config)
+	s.apply(s.Config, svc)
+	s.mu.Unlock()
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", s.Port), s.Router))
+	if s.Loader != nil {
+		go s.watch(s.stop)
+	}
 	return nil
 }
 
+// serve handles requests from the listener until the server is shut down.
+func (s *Server) serve(ln net.Listener) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			log.Info("shutting down, waiting for pending requests")
+			ctx, cancel := context.WithTimeout(context.Background(), duration(s.CurrentConfig().ShutdownTimeout, defaultShutdownTimeout))
+			defer cancel()
+			s.Shutdown(ctx)
+		case <-s.done:
+		}
+	}()
+
+	tls, err := s.serveTLS(ln)
+	if !tls {
+		err = s.http.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		s.Shutdown(context.Background())
+		return err
+	}
+	<-s.done
+	return s.shutdownErr
+}
+
+// Shutdown stops accepting requests and waits until the pending requests and
+// queued jobs are done, or until the context expires. The scheduler and the
+// configuration watcher are stopped as well, and the database is closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		if s.redirect != nil {
+			s.redirect.Shutdown(ctx)
+		}
+		if s.http != nil {
+			s.shutdownErr = s.http.Shutdown(ctx)
+		}
+		close(s.stop)
+
+		flushed := make(chan struct{})
+		go func() {
+			s.queue.Close()
+			close(flushed)
+		}()
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			log.WithFields(log.Fields{"pending": s.queue.Len()}).Warn("dropping queued jobs")
+			if s.shutdownErr == nil {
+				s.shutdownErr = ctx.Err()
+			}
+		}
+		s.mu.RLock()
+		tracing, db := s.tracing, s.database
+		s.mu.RUnlock()
+		if tracing != nil {
+			if err := tracing.Shutdown(ctx); err != nil {
+				log.WithFields(log.Fields{"err": err}).Error("TracerProvider.Shutdown")
+			}
+		}
+		closeDB(db, 0)
+		close(s.done)
+	})
+	return s.shutdownErr
+}
+
+// reportJob creates the job for sending the weekly digests.
+func reportJob(cfg *Configuration, svc *services) (*Job, error) {
+	day, err := ParseWeekday(cfg.ReportDay)
+	if err != nil {
+		return nil, err
+	}
+	dq, ok := svc.database.(DBQuerier)
+	if !ok {
+		return nil, fmt.Errorf("the configured database cannot be queried for reports")
+	}
+	at := cfg.ReportTime
+	if at == "" {
+		at = "09:00"
+	}
+
+	job := &Job{
+		Name: "weekly digest",
+		Time: at,
+		Days: []time.Weekday{day},
+		Run: func(now time.Time) {
+			since, until, err := LastDays(now, cfg.Area, 7)
+			if err != nil {
+				log.WithFields(log.Fields{"err": err}).Error("LastDays")
+				return
+			}
+			if _, err := SendReports(dq, svc.preferences, svc.workspaces, svc.slack, cfg.ReportChannel, since, until); err != nil {
+				log.WithFields(log.Fields{"err": err}).Error("SendReports")
+			}
+		},
+	}
+	return job, nil
+}
+
 func (s *Server) handleIndex() http.HandlerFunc {
 	type response struct {
 		Message string `json:"message"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.WithFields(log.Fields{"path": "/"}).Trace("received request")
+		logger(r.Context()).WithFields(log.Fields{"path": "/"}).Trace("received request")
 		res := response{Message: "PONG"}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(&res)
 	}
 }
 
+// handleLog handles the /log slash command of Slack through a SlackTransport.
 func (s *Server) handleLog() http.HandlerFunc {
+	return s.handleTransport("/log", s.slackCommand)
+}
+
+func (s *Server) handleInteraction() http.HandlerFunc {
+	type action struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	}
+	type payload struct {
+		Type        string   `json:"type"`
+		Token       string   `json:"token"`
+		ResponseURL string   `json:"response_url"`
+		TriggerID   string   `json:"trigger_id"`
+		Actions     []action `json:"actions"`
+		Team        struct {
+			ID string `json:"id"`
+		} `json:"team"`
+		Enterprise *struct {
+			ID string `json:"id"`
+		} `json:"enterprise"`
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+		User struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		} `json:"user"`
+		View struct {
+			CallbackID string    `json:"callback_id"`
+			State      ViewState `json:"state"`
+		} `json:"view"`
+	}
+	type viewResponse struct {
+		ResponseAction string            `json:"response_action"`
+		Errors         map[string]string `json:"errors"`
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.WithFields(log.Fields{"path": "/log"}).Trace("received request")
+		logger(r.Context()).WithFields(log.Fields{"path": "/interactions"}).Trace("received request")
 		w.Header().Set("Content-Type", "application/json")
 
+		// Slack is disabled without a token, like the other transports
+		if s.Config.Token == "" {
+			e := errorMsg{Message: "/interactions is disabled", Code: http.StatusNotFound}
+			e.JSONError(w)
+			return
+		}
+
 		if err := r.ParseForm(); err != nil {
 			e := errorMsg{
 				Message: fmt.Sprintf("couldn't parse form: %s", err),
 				Code:    http.StatusBadRequest,
 			}
 			e.JSONError(w)
-			log.WithFields(log.Fields{"err": e}).Error("http.Request.ParseForm")
+			logger(r.Context()).WithFields(log.Fields{"err": e}).Error("http.Request.ParseForm")
+			return
+		}
+
+		p := payload{}
+		if err := json.Unmarshal([]byte(r.FormValue("payload")), &p); err != nil {
+			e := errorMsg{
+				Message: fmt.Sprintf("couldn't parse payload: %s", err),
+				Code:    http.StatusBadRequest,
+			}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("json.Unmarshal")
 			return
 		}
 
-		// Check if authentication token is correct
-		if err := VerifyWebhook(r.Form, s.Config.Token); err != nil {
+		// Interactive payloads carry the verification token inside the JSON body
+		if err := VerifyWebhook(url.Values{"token": {p.Token}}, s.Config.Token); err != nil {
 			e := errorMsg{
 				Message: fmt.Sprintf("token may be missing or invalid: %s", err),
 				Code:    http.StatusUnauthorized,
 			}
 			e.JSONError(w)
-			log.WithFields(log.Fields{"err": e.Message}).Error("VerifyWebhook")
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("VerifyWebhook")
+			verificationFailures.WithLabelValues("/interactions").Inc()
 			return
 		}
-
-		// Check if text exists
-		if len(r.Form["text"]) == 0 {
+		team, err := s.verifyTeam(p.Team.ID)
+		if err != nil {
 			e := errorMsg{
-				Message: "empty text in form",
-				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("workspace is not allowed: %s", err),
+				Code:    http.StatusUnauthorized,
 			}
 			e.JSONError(w)
-			log.Error(e.Message)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("verifyTeam")
+			verificationFailures.WithLabelValues("/interactions").Inc()
 			return
 		}
 
-		// Create Twitter client
-		var client *twitter.Client
-		if tc := s.Config; ContainsEmpty(
-			tc.TwitterConsumerKey,
-			tc.TwitterConsumerSecret,
-			tc.TwitterAccessKey,
-			tc.TwitterAccessSecret,
-		) {
-			client = nil
-		} else {
-			config := &clientcredentials.Config{
-				ClientID:     s.Config.TwitterConsumerKey,
-				ClientSecret: s.Config.TwitterConsumerSecret,
-				TokenURL:     "https://api.twitter.com/oauth2/token",
+		// Submissions of the settings modal
+		if p.Type == "view_submission" && p.View.CallbackID == settingsCallbackID {
+			prefs := s.userPreferences(p.User.ID)
+			if errs := prefs.ApplyView(p.View.State); len(errs) > 0 {
+				json.NewEncoder(w).Encode(viewResponse{ResponseAction: "errors", Errors: errs})
+				return
 			}
-			httpClient := config.Client(oauth2.NoContext)
-			client = twitter.NewClient(httpClient)
+			if err := s.savePreferences(*prefs, team); err != nil {
+				json.NewEncoder(w).Encode(viewResponse{
+					ResponseAction: "errors",
+					Errors:         map[string]string{"tz": fmt.Sprintf("cannot save settings: %s", err)},
+				})
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
 		}
 
-		// Prepare and process the request
-		text := r.FormValue("text")
-		userID := r.FormValue("user_id")
-		timestamp, err := FetchTimestamp(r.Header.Get("X-Slack-Request-Timestamp"), s.Config.Area)
+		if p.Type != "block_actions" || len(p.Actions) == 0 || !strings.HasPrefix(p.Actions[0].ActionID, moodActionPrefix) {
+			logger(r.Context()).WithFields(log.Fields{"type": p.Type}).Debug("ignoring interaction")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		timestamp, err := slackTimestamp(r)
 		if err != nil {
-			e := errorMsg{
-				Message: fmt.Sprintf("cannot convert timestamp properly: %s", err),
-				Code:    http.StatusBadRequest,
-			}
+			e := errorMsg{Message: err.Error(), Code: http.StatusBadRequest}
 			e.JSONError(w)
-			log.WithFields(log.Fields{"err": e.Message}).Error("FetchTimestamp")
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("slackTimestamp")
 			return
 		}
-		resp, err := UpdateLog(userID, text, *timestamp, s.database, client, s.Debug)
+		sub := &Submission{
+			From: LogContext{
+				UserID:    p.User.ID,
+				UserName:  p.User.Username,
+				TeamID:    p.Team.ID,
+				ChannelID: p.Channel.ID,
+				TriggerID: p.TriggerID,
+			},
+			Text:      p.Actions[0].Value,
+			Timestamp: timestamp,
+			Workspace: team,
+		}
+		if p.Enterprise != nil {
+			sub.From.EnterpriseID = p.Enterprise.ID
+		}
+
+		item, prefs, resp, err := s.store(r.Context(), sub)
+		if err == nil && item != nil {
+			resp, err = replyTo(r.Context(), item, s.twitterClient(), prefs)
+		}
 		if err != nil {
 			e := errorMsg{
 				Message: fmt.Sprintf("error in processing request: %s", err),
 				Code:    http.StatusBadRequest,
 			}
 			e.JSONError(w)
-			log.WithFields(log.Fields{"err": e.Message}).Error("UpdateLog")
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("Server.store")
 			return
 		}
-		json.NewEncoder(w).Encode(resp)
+
+		// Replace the mood picker with the acknowledgement, but keep it if a
+		// limit was reached so that the user can try again later
+		if p.ResponseURL != "" {
+			resp.ReplaceOriginal = item != nil
+			if err := respond(p.ResponseURL, resp); err != nil {
+				logger(r.Context()).WithFields(log.Fields{"err": err}).Error("respond")
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// subcommand returns the handler of a slash command keyword, or nil if the
+// text should be logged as a mood instead.
+func (s *Server) subcommand(name string) func(form url.Values, args []string) (*Message, error) {
+	switch name {
+	case "tz":
+		return s.commandTimezone
+	case "settings":
+		return s.commandSettings
+	case "token":
+		return s.commandToken
+	}
+	return nil
+}
+
+// commandTimezone shows or sets the timezone of the user. This is a shortcut
+// for `settings tz <area>`.
+func (s *Server) commandTimezone(form url.Values, args []string) (*Message, error) {
+	if len(args) == 0 {
+		prefs := s.userPreferences(form.Get("user_id"))
+		msg := &Message{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("Your logs are stamped in %s. Use `tz <IANA area>` to change it.", s.area(form.Get("team_id"), prefs)),
+		}
+		return msg, nil
+	}
+	return s.commandSettings(form, append([]string{"tz"}, args...))
+}
+
+// commandSettings shows or changes the preferences of the user. Without any
+// arguments, the settings modal is opened if a bot token is available.
+func (s *Server) commandSettings(form url.Values, args []string) (*Message, error) {
+	userID := form.Get("user_id")
+	prefs := s.userPreferences(userID)
+
+	if len(args) == 0 {
+		if client := s.slackFor(form.Get("team_id")); client != nil && form.Get("trigger_id") != "" {
+			err := client.OpenView(form.Get("trigger_id"), SettingsView(prefs, s.Config.Area))
+			if err == nil {
+				return &Message{ResponseType: "ephemeral", Text: "Opening your settings..."}, nil
+			}
+			log.WithFields(log.Fields{"err": err}).Error("SlackClient.OpenView")
+		}
+		msg := &Message{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("Your settings:\n%s\n\n%s", prefs.Summary(s.Config.Area), settingsUsage()),
+		}
+		return msg, nil
+	}
+
+	if len(args) < 2 {
+		return nil, fmt.Errorf("missing value for %q, use `settings <key> <value>`", args[0])
+	}
+	if err := prefs.Set(args[0], strings.Join(args[1:], " ")); err != nil {
+		return nil, err
+	}
+	if err := s.savePreferences(*prefs, form.Get("team_id")); err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		ResponseType: "ephemeral",
+		Text:         fmt.Sprintf("Got it, your settings are now:\n%s", prefs.Summary(s.Config.Area)),
 	}
+	return msg, nil
+}
+
+// userPreferences fetches the preferences of a user. The defaults are
+// returned if the preferences can't be retrieved.
+func (s *Server) userPreferences(userID string) *Preferences {
+	if s.preferences == nil {
+		return &Preferences{UserID: userID}
+	}
+	prefs, err := s.preferences.GetPreferences(userID)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("PreferenceStore.GetPreferences")
+		return &Preferences{UserID: userID}
+	}
+	return prefs
+}
+
+// savePreferences stores the preferences of a user. When installed into
+// several workspaces, the team is kept so that reminders are sent through the
+// bot of the workspace where they were set.
+func (s *Server) savePreferences(prefs Preferences, teamID string) error {
+	if s.preferences == nil {
+		return fmt.Errorf("the configured database cannot store preferences")
+	}
+	if s.workspaces != nil {
+		prefs.TeamID = teamID
+	}
+	if err := s.preferences.SavePreferences(prefs); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("PreferenceStore.SavePreferences")
+		return err
+	}
+	s.scheduler.forgetPreferences()
+	return nil
+}
+
+// logContext clears the fields of the context that CONTEXT_FIELDS doesn't
+// allow to be stored. The team verified by verifyTeam is always kept, since
+// the logs of each workspace are kept apart by it.
+func (s *Server) logContext(c LogContext, team string) LogContext {
+	fields, err := s.Config.contextFields()
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Configuration.contextFields")
+	}
+	c = c.Only(fields)
+	if team != "" {
+		c.TeamID = team
+	}
+	return c
+}
+
+// timezoneTTL is how long the timezones of the users' Slack profiles are
+// cached, since users.info is rate limited.
+const timezoneTTL = time.Hour
+
+// area returns the IANA-compliant area of the user. A timezone set in the
+// user's preferences takes precedence over the user's Slack profile, and the
+// configured Area is used if neither is available.
+func (s *Server) area(teamID string, prefs *Preferences) string {
+	if prefs.Timezone != "" {
+		return prefs.Timezone
+	}
+	if area := s.profileTimezone(teamID, prefs.UserID); area != "" {
+		return area
+	}
+	return s.Config.Area
+}
+
+// profileTimezone returns the timezone of the user's Slack profile, or an
+// empty string if it's not available.
+func (s *Server) profileTimezone(teamID, userID string) string {
+	key := teamID + "/" + userID
+	if area, ok := s.timezones.Get(key); ok {
+		return area.(string)
+	}
+	client := s.slackFor(teamID)
+	if client == nil || platformUser(userID) {
+		return ""
+	}
+	area, err := client.UserTimezone(userID)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("SlackClient.UserTimezone")
+		return ""
+	}
+	s.timezones.Set(key, area)
+	return area
+}
+
+// twitterClient creates a Twitter client if all API keys are configured.
+func (s *Server) twitterClient() *twitter.Client {
+	if tc := s.Config; ContainsEmpty(
+		tc.TwitterConsumerKey,
+		tc.TwitterConsumerSecret,
+		tc.TwitterAccessKey,
+		tc.TwitterAccessSecret,
+	) {
+		return nil
+	}
+	config := &clientcredentials.Config{
+		ClientID:     s.Config.TwitterConsumerKey,
+		ClientSecret: s.Config.TwitterConsumerSecret,
+		TokenURL:     "https://api.twitter.com/oauth2/token",
+	}
+	httpClient := config.Client(oauth2.NoContext)
+	return twitter.NewClient(httpClient)
 }
 
 // FetchTimestamp obtains the timestamp value from the request and location.
@@ -177,15 +638,10 @@ func ContainsEmpty(ss ...string) bool {
 
 // VerifyWebhook checks if the submitted request matches the token provided by Slack
 func VerifyWebhook(form url.Values, token string) error {
-	t := form.Get("token")
-	if len(t) == 0 {
+	if len(form.Get("token")) == 0 {
 		return fmt.Errorf("empty form token")
 	}
-
-	if t != token {
-		return fmt.Errorf("invalid request/credentials: %q", t[0])
-	}
-	return nil
+	return verifyTokens(form.Get("token"), token)
 }
 
 type errorMsg struct {
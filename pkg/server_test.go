@@ -5,13 +5,17 @@
 package pkg
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -65,20 +69,22 @@ func TestServer_handleIndex(t *testing.T) {
 
 func TestServer_handleLog(t *testing.T) {
 	type fields struct {
-		Port      int
-		Router    *httprouter.Router
-		Config    *Configuration
-		DebugOnly bool
-		database  DBInserter
+		Port        int
+		Router      *httprouter.Router
+		Config      *Configuration
+		DebugOnly   bool
+		database    DBInserter
+		preferences PreferenceStore
 	}
 	type data struct {
 		text, userID, token string
 	}
 	tests := []struct {
-		name    string
-		data    data
-		fields  fields
-		wantErr bool
+		name     string
+		data     data
+		fields   fields
+		wantErr  bool
+		wantText string // substring of the reply, if any
 	}{
 		{
 			name: "happy path",
@@ -110,15 +116,101 @@ func TestServer_handleLog(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "slack disabled",
+			data: data{text: "4 hello world", userID: "testUser", token: ""},
+			fields: fields{
+				Port:      8080,
+				DebugOnly: true,
+				Config:    &Configuration{Area: "Asia/Manila"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty text",
+			data: data{text: "", userID: "testUser", token: "testToken"},
+			fields: fields{
+				Port:      8080,
+				DebugOnly: true,
+				Config:    &Configuration{Token: "testToken", Area: "Asia/Manila"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "set timezone",
+			data: data{text: "tz Europe/Berlin", userID: "testUser", token: "testToken"},
+			fields: fields{
+				Port:        8080,
+				DebugOnly:   true,
+				Config:      &Configuration{Token: "testToken", Area: "Asia/Manila"},
+				preferences: &memory{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "set unknown timezone",
+			data: data{text: "tz Europe/Manila", userID: "testUser", token: "testToken"},
+			fields: fields{
+				Port:        8080,
+				DebugOnly:   true,
+				Config:      &Configuration{Token: "testToken", Area: "Asia/Manila"},
+				preferences: &memory{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "show settings",
+			data: data{text: "settings", userID: "testUser", token: "testToken"},
+			fields: fields{
+				Port:        8080,
+				DebugOnly:   true,
+				Config:      &Configuration{Token: "testToken", Area: "Asia/Manila"},
+				preferences: &memory{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "change a setting",
+			data: data{text: "settings reminder 17:30", userID: "testUser", token: "testToken"},
+			fields: fields{
+				Port:        8080,
+				DebugOnly:   true,
+				Config:      &Configuration{Token: "testToken", Area: "Asia/Manila"},
+				preferences: &memory{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "change an unknown setting",
+			data: data{text: "settings color blue", userID: "testUser", token: "testToken"},
+			fields: fields{
+				Port:        8080,
+				DebugOnly:   true,
+				Config:      &Configuration{Token: "testToken", Area: "Asia/Manila"},
+				preferences: &memory{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "set timezone without a store",
+			data: data{text: "tz Europe/Berlin", userID: "testUser", token: "testToken"},
+			fields: fields{
+				Port:      8080,
+				DebugOnly: true,
+				Config:    &Configuration{Token: "testToken", Area: "Asia/Manila"},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Server{
-				Port:     tt.fields.Port,
-				Router:   tt.fields.Router,
-				Config:   tt.fields.Config,
-				Debug:    tt.fields.DebugOnly,
-				database: tt.fields.database,
+				Port:        tt.fields.Port,
+				Router:      tt.fields.Router,
+				Config:      tt.fields.Config,
+				Debug:       tt.fields.DebugOnly,
+				database:    tt.fields.database,
+				preferences: tt.fields.preferences,
 			}
 
 			srv := httptest.NewServer(s.handleLog())
@@ -166,10 +258,326 @@ func TestServer_handleLog(t *testing.T) {
 				t.Fatalf("could not read response: %v", err)
 			}
 			t.Logf("received response: %s", string(b))
+			if !strings.Contains(string(b), tt.wantText) {
+				t.Errorf("received response %s, want %q", b, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestServer_handleLog_context(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     string
+		workspaces bool
+		want       LogItem
+	}{
+		{name: "user only", want: LogItem{UserID: "U1"}},
+		{name: "some fields", fields: "channel_id, user_name", want: LogItem{UserID: "U1", ChannelID: "C1", UserName: "jane"}},
+		{
+			name:   "all fields",
+			fields: "all",
+			want:   LogItem{UserID: "U1", TeamID: "T1", EnterpriseID: "E1", ChannelID: "C1", UserName: "jane", TriggerID: "1.2.3"},
+		},
+		{name: "team of workspace", workspaces: true, want: LogItem{UserID: "U1", TeamID: "T1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memory{}
+			s := &Server{
+				Config:   &Configuration{Token: "token", Area: "Asia/Manila", ContextFields: tt.fields},
+				database: db,
+			}
+			if tt.workspaces {
+				db.SaveInstallation(Installation{TeamID: "T1", BotToken: "xoxb-team-one"})
+				s.workspaces = &Workspaces{Store: db}
+			}
+
+			form := url.Values{
+				"token":         {"token"},
+				"text":          {"3 long review day"},
+				"user_id":       {"U1"},
+				"user_name":     {"jane"},
+				"team_id":       {"T1"},
+				"enterprise_id": {"E1"},
+				"channel_id":    {"C1"},
+				"trigger_id":    {"1.2.3"},
+			}
+			req := httptest.NewRequest(http.MethodPost, "/log", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Slack-Request-Timestamp", fmt.Sprint(time.Now().Unix()))
+			rec := httptest.NewRecorder()
+			s.handleLog()(rec, req)
+
+			items, _ := db.QueryDB(Query{})
+			if rec.Code != http.StatusOK || len(items) != 1 {
+				t.Fatalf("handleLog() status = %d and stored %d logs: %s", rec.Code, len(items), rec.Body)
+			}
+			got := items[0]
+			got.Timestamp, got.Measure, got.Notes = time.Time{}, 0, ""
+			if got != tt.want {
+				t.Errorf("handleLog() stored %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_handleInteraction(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		payload  string
+		wantCode int
+		wantText string
+	}{
+		{
+			name:     "happy path",
+			token:    "testToken",
+			payload:  `{"type":"block_actions","user":{"id":"testUser"},"actions":[{"action_id":"log_measure_4","value":"4"}]}`,
+			wantCode: http.StatusOK,
+			wantText: fmt.Sprintf("%s: 4 ()", ackPrefix),
+		},
+		{
+			name:     "non-matching webhook",
+			token:    "diffToken",
+			payload:  `{"type":"block_actions","user":{"id":"testUser"},"actions":[{"action_id":"log_measure_4","value":"4"}]}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "unknown action is ignored",
+			token:    "testToken",
+			payload:  `{"type":"block_actions","user":{"id":"testUser"},"actions":[{"action_id":"other","value":"4"}]}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "malformed payload",
+			token:    "testToken",
+			payload:  `{"type":`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				Config: &Configuration{Token: "testToken", Area: "Asia/Manila"},
+				Debug:  true,
+			}
+
+			// Stand-in for the response_url given by Slack
+			replies := make(chan Message, 1)
+			responseSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				msg := Message{}
+				json.NewDecoder(r.Body).Decode(&msg)
+				replies <- msg
+			}))
+			defer responseSrv.Close()
+
+			srv := httptest.NewServer(s.handleInteraction())
+			defer srv.Close()
+
+			// Inject the token and response_url into the payload
+			p := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(tt.payload), &p); err == nil {
+				p["token"] = tt.token
+				p["response_url"] = responseSrv.URL
+				b, _ := json.Marshal(p)
+				tt.payload = string(b)
+			}
+
+			data := url.Values{}
+			data.Add("payload", tt.payload)
+			req, err := http.NewRequest("POST", fmt.Sprintf("%s/interactions", srv.URL), strings.NewReader(data.Encode()))
+			if err != nil {
+				t.Fatalf("cannot create request: %v", err)
+			}
+			req.Header.Add("X-Slack-Request-Timestamp", "1579324284")
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("could not send POST request: %v", err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tt.wantCode {
+				t.Errorf("expected status %d; got %v", tt.wantCode, res.Status)
+			}
+
+			if tt.wantText != "" {
+				select {
+				case msg := <-replies:
+					if msg.Text != tt.wantText || !msg.ReplaceOriginal {
+						t.Errorf("handleInteraction() replied %v, want %s", msg, tt.wantText)
+					}
+				case <-time.After(time.Second):
+					t.Errorf("handleInteraction() did not reply to response_url")
+				}
+			}
+		})
+	}
+}
+
+func TestServer_handleInteraction_settings(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     string
+		wantErrors bool
+		want       Preferences
+	}{
+		{
+			name:   "happy path",
+			values: `{"tz":{"value":{"value":"Europe/Berlin"}},"reminder":{"value":{"value":"17:30"}},"reply":{"value":{"selected_option":{"value":"plain"}}}}`,
+			want:   Preferences{UserID: "testUser", Timezone: "Europe/Berlin", Reminder: "17:30", ReplyStyle: ReplyPlain},
+		},
+		{
+			name:       "invalid reminder",
+			values:     `{"reminder":{"value":{"value":"5pm"}}}`,
+			wantErrors: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := &memory{}
+			s := &Server{
+				Config:      &Configuration{Token: "testToken", Area: "Asia/Manila"},
+				preferences: prefs,
+			}
+			srv := httptest.NewServer(s.handleInteraction())
+			defer srv.Close()
+
+			payload := fmt.Sprintf(
+				`{"type":"view_submission","token":"testToken","user":{"id":"testUser"},"view":{"callback_id":"%s","state":{"values":%s}}}`,
+				settingsCallbackID, tt.values,
+			)
+			res, err := http.PostForm(srv.URL, url.Values{"payload": {payload}})
+			if err != nil {
+				t.Fatalf("could not send POST request: %v", err)
+			}
+			defer res.Body.Close()
+
+			b, _ := ioutil.ReadAll(res.Body)
+			if gotErrors := strings.Contains(string(b), `"errors"`); gotErrors != tt.wantErrors {
+				t.Fatalf("handleInteraction() response = %s, wantErrors %v", string(b), tt.wantErrors)
+			}
+			if tt.wantErrors {
+				return
+			}
+
+			got, _ := prefs.GetPreferences("testUser")
+			if got.Timezone != tt.want.Timezone || got.Reminder != tt.want.Reminder || got.ReplyStyle != tt.want.ReplyStyle {
+				t.Errorf("handleInteraction() saved %+v, want %+v", *got, tt.want)
+			}
 		})
 	}
 }
 
+func TestServer_area(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefs   *Preferences
+		profile map[string]string
+		want    string
+	}{
+		{name: "preferred timezone", prefs: &Preferences{UserID: "U1", Timezone: "Europe/Berlin"}, profile: map[string]string{"U1": "America/New_York"}, want: "Europe/Berlin"},
+		{name: "slack profile", prefs: &Preferences{UserID: "U1"}, profile: map[string]string{"U1": "America/New_York"}, want: "America/New_York"},
+		{name: "configured area", prefs: &Preferences{UserID: "U1"}, want: "Asia/Manila"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := newSlackStandIn("xoxb-test")
+			defer slack.Close()
+			for k, v := range tt.profile {
+				slack.tz[k] = v
+			}
+
+			s := &Server{
+				Config:    &Configuration{Area: "Asia/Manila"},
+				slack:     slack.client("xoxb-test"),
+				timezones: &cache{TTL: time.Hour},
+			}
+			if got := s.area("", tt.prefs); got != tt.want {
+				t.Errorf("Server.area() = %s, want %s", got, tt.want)
+			}
+
+			// The profile is cached, so later changes aren't seen right away
+			if len(tt.profile) == 0 {
+				return
+			}
+			slack.mu.Lock()
+			slack.tz["U1"] = "Europe/Paris"
+			slack.mu.Unlock()
+			if got := s.area("", tt.prefs); got != tt.want {
+				t.Errorf("Server.area() = %s after a change, want the cached %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Start(t *testing.T) {
+	s := &Server{Router: httprouter.New(), Config: &Configuration{Table: "mysql://localhost", Token: "token", Area: "Asia/Manila"}}
+	if err := s.Start(); err == nil {
+		t.Errorf("Server.Start() error = nil, want invalid configuration")
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	started := make(chan struct{})
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, "done")
+	})
+
+	s := &Server{Router: router, Config: &Configuration{Table: "memory://", Token: "token", Area: "Asia/Manila"}}
+	if err := s.setup(); err != nil {
+		t.Fatalf("Server.setup() error = %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.serve(ln) }()
+
+	var flushed int32
+	s.queue.Push(func() {
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&flushed, 1)
+	})
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Server.Shutdown() error = %v", err)
+	}
+	if got := <-body; got != "done" {
+		t.Errorf("Server.Shutdown() dropped the pending request: %s", got)
+	}
+	if atomic.LoadInt32(&flushed) != 1 {
+		t.Errorf("Server.Shutdown() dropped the queued job")
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Server.serve() error = %v", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String() + "/slow"); err == nil {
+		t.Errorf("Server.Shutdown() still accepts requests")
+	}
+}
+
 func TestFetchTimestamp(t *testing.T) {
 	type args struct {
 		requestTimestamp, area string
@@ -241,9 +649,13 @@ func TestVerifyWebhook(t *testing.T) {
 			if err != nil {
 				t.Fatalf("cannot parse query: %s", tt.args.query)
 			}
-			if err := VerifyWebhook(v, tt.args.token); (err != nil) != tt.wantErr {
+			err = VerifyWebhook(v, tt.args.token)
+			if (err != nil) != tt.wantErr {
 				t.Errorf("VerifyWebhook() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tok := v.Get("token"); err != nil && tok != "" && (strings.Contains(err.Error(), tok) || strings.Contains(err.Error(), fmt.Sprintf("%q", tok[0]))) {
+				t.Errorf("VerifyWebhook() error = %v, must not echo the token", err)
+			}
 		})
 	}
 }
@@ -0,0 +1,290 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const slackAPIURL = "https://slack.com/api"
+
+// SlackClient is a minimal client for the Slack Web API methods that the
+// barometer needs. The BaseURL can be pointed to a local stand-in for testing.
+type SlackClient struct {
+	Token      string // Bot token (xoxb-*) obtained when installing the app
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewSlackClient creates a SlackClient that talks to the Slack Web API.
+func NewSlackClient(token string) *SlackClient {
+	return &SlackClient{
+		Token:      token,
+		BaseURL:    slackAPIURL,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SendDirectMessage opens a direct message channel with the user and posts the
+// message into it.
+func (c *SlackClient) SendDirectMessage(userID string, msg *Message) error {
+	channel, err := c.OpenConversation(userID)
+	if err != nil {
+		return err
+	}
+	return c.PostMessage(channel, msg)
+}
+
+// OpenConversation opens (or resumes) a direct message with a user and
+// returns the channel ID.
+func (c *SlackClient) OpenConversation(userID string) (string, error) {
+	type request struct {
+		Users string `json:"users"`
+	}
+	type response struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+
+	res := response{}
+	if err := c.call("conversations.open", request{Users: userID}, &res); err != nil {
+		return "", err
+	}
+	return res.Channel.ID, nil
+}
+
+// PostMessage sends a message to a channel.
+func (c *SlackClient) PostMessage(channel string, msg *Message) error {
+	m := *msg
+	m.Channel = channel
+	return c.call("chat.postMessage", m, nil)
+}
+
+// OpenView opens a modal using the trigger_id of a slash command or
+// interaction. The trigger_id expires three seconds after it was issued.
+func (c *SlackClient) OpenView(triggerID string, view *View) error {
+	type request struct {
+		TriggerID string `json:"trigger_id"`
+		View      *View  `json:"view"`
+	}
+	return c.call("views.open", request{TriggerID: triggerID, View: view}, nil)
+}
+
+// UserTimezone fetches the IANA-compliant area set in the user's Slack profile.
+func (c *SlackClient) UserTimezone(userID string) (string, error) {
+	type response struct {
+		User struct {
+			TZ string `json:"tz"`
+		} `json:"user"`
+	}
+
+	res := response{}
+	if err := c.call("users.info", url.Values{"user": {userID}}, &res); err != nil {
+		return "", err
+	}
+	return res.User.TZ, nil
+}
+
+// call sends a payload to a Slack Web API method and decodes the response
+// into out if provided. Read methods such as users.info don't accept JSON, so
+// url.Values payloads are sent as a form instead.
+func (c *SlackClient) call(method string, payload interface{}, out interface{}) error {
+	var (
+		body        []byte
+		contentType string
+		err         error
+	)
+	if form, ok := payload.(url.Values); ok {
+		body = []byte(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	} else {
+		if body, err = json.Marshal(payload); err != nil {
+			return err
+		}
+		contentType = "application/json; charset=utf-8"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s", c.BaseURL, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	log.WithFields(log.Fields{"method": method}).Trace("calling Slack API")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error in calling %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %s", method, resp.Status)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("cannot decode %s response: %v", method, err)
+	}
+
+	status := struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return err
+	}
+	if !status.OK {
+		return fmt.Errorf("%s failed: %s", method, status.Error)
+	}
+
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+// respond posts a message to the response_url given by Slack in slash
+// commands and interactive payloads.
+func respond(responseURL string, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response_url returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// SlackTransport receives logs through the /log slash command of Slack. Its
+// subcommands, such as `/log tz Europe/Berlin`, don't log a mood.
+type SlackTransport struct {
+	Token string // Verification token of the app
+
+	// Workspace returns the team to store the logs of a workspace under, or
+	// an error if the app isn't installed into it. See Server.verifyTeam.
+	Workspace func(teamID string) (string, error)
+	// Subcommand returns the handler of a keyword, or nil if the text should
+	// be logged as a mood. See Server.subcommand.
+	Subcommand func(name string) func(form url.Values, args []string) (*Message, error)
+}
+
+// Verify checks the verification token and the workspace of the command.
+func (t *SlackTransport) Verify(r *http.Request, body []byte) error {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return err
+	}
+	if err := VerifyWebhook(form, t.Token); err != nil {
+		return err
+	}
+	if _, err := t.Workspace(form.Get("team_id")); err != nil {
+		return fmt.Errorf("workspace is not allowed: %v", err)
+	}
+	return nil
+}
+
+// Acknowledge runs the command if it's a subcommand.
+func (t *SlackTransport) Acknowledge(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	form, _ := url.ParseQuery(string(body))
+	args := strings.Fields(form.Get("text"))
+	if len(args) == 0 {
+		return false
+	}
+	cmd := t.Subcommand(args[0])
+	if cmd == nil {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp, err := cmd(form, args[1:])
+	if err != nil {
+		e := errorMsg{Message: fmt.Sprintf("error in processing request: %s", err), Code: http.StatusBadRequest}
+		e.JSONError(w)
+		logger(r.Context()).WithFields(log.Fields{"err": e.Message, "subcommand": args[0]}).Error("subcommand")
+		return true
+	}
+	json.NewEncoder(w).Encode(resp)
+	return true
+}
+
+// Parse extracts the log from the command, which Slack timestamps in the
+// X-Slack-Request-Timestamp header.
+func (t *SlackTransport) Parse(r *http.Request, body []byte) (*Submission, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := slackTimestamp(r)
+	if err != nil {
+		return nil, err
+	}
+	team, err := t.Workspace(form.Get("team_id"))
+	if err != nil {
+		return nil, err
+	}
+	return &Submission{
+		From: LogContext{
+			UserID:       form.Get("user_id"),
+			UserName:     form.Get("user_name"),
+			TeamID:       form.Get("team_id"),
+			EnterpriseID: form.Get("enterprise_id"),
+			ChannelID:    form.Get("channel_id"),
+			TriggerID:    form.Get("trigger_id"),
+		},
+		Text:      form.Get("text"),
+		Timestamp: timestamp,
+		Workspace: team,
+	}, nil
+}
+
+// Reply responds with the message as is, since messages are in the format of
+// Slack.
+func (t *SlackTransport) Reply(w http.ResponseWriter, msg *Message) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(msg)
+}
+
+// slackTimestamp returns when Slack sent a request.
+func slackTimestamp(r *http.Request) (time.Time, error) {
+	i, err := strconv.ParseInt(r.Header.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot convert timestamp properly: %v", err)
+	}
+	return time.Unix(i, 0), nil
+}
+
+// slackCommand returns the Slack transport, or nil if it's not configured.
+// It must be called on a snapshot of the Server.
+func (s *Server) slackCommand() Transport {
+	if s.Config.Token == "" {
+		return nil
+	}
+	return &SlackTransport{Token: s.Config.Token, Workspace: s.verifyTeam, Subcommand: s.subcommand}
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// slackStandIn is a local stand-in for the Slack Web API. It records all
+// messages posted through chat.postMessage, and installs the app into team T1
+// through oauth.v2.access.
+type slackStandIn struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	messages []Message
+	tz       map[string]string // user ID to the timezone in their profile
+}
+
+func newSlackStandIn(token string) *slackStandIn {
+	s := &slackStandIn{tz: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// The OAuth flow authenticates with the credentials of the app and
+		// issues the bot token of the workspace
+		if r.URL.Path == "/oauth.v2.access" {
+			r.ParseForm()
+			if r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "secret" || r.FormValue("code") != "code" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_code"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ok":           true,
+				"app_id":       "A1",
+				"access_token": token,
+				"token_type":   "bot",
+				"scope":        slackBotScopes,
+				"bot_user_id":  "B1",
+				"team":         map[string]string{"id": "T1", "name": "Team One"},
+			})
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_auth"})
+			return
+		}
+
+		switch strings.TrimPrefix(r.URL.Path, "/") {
+		case "conversations.open":
+			req := struct {
+				Users string `json:"users"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ok":      true,
+				"channel": map[string]string{"id": "D" + req.Users},
+			})
+		case "users.info":
+			r.ParseForm()
+			s.mu.Lock()
+			tz, ok := s.tz[r.FormValue("user")]
+			s.mu.Unlock()
+			if !ok {
+				json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "user_not_found"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ok":   true,
+				"user": map[string]string{"id": r.FormValue("user"), "tz": tz},
+			})
+		case "chat.postMessage":
+			msg := Message{}
+			json.NewDecoder(r.Body).Decode(&msg)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "unknown_method"})
+		}
+	}))
+	return s
+}
+
+func (s *slackStandIn) client(token string) *SlackClient {
+	c := NewSlackClient(token)
+	c.BaseURL = s.URL
+	return c
+}
+
+func (s *slackStandIn) posted() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message{}, s.messages...)
+}
+
+func TestSlackClient_SendDirectMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		userID  string
+		want    string
+		wantErr bool
+	}{
+		{name: "happy path", token: "xoxb-test", userID: "U123", want: "DU123", wantErr: false},
+		{name: "invalid token", token: "xoxb-wrong", userID: "U123", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSlackStandIn("xoxb-test")
+			defer srv.Close()
+
+			err := srv.client(tt.token).SendDirectMessage(tt.userID, &Message{Text: "hello"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SlackClient.SendDirectMessage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := srv.posted()
+			if len(got) != 1 || got[0].Channel != tt.want {
+				t.Errorf("SlackClient.SendDirectMessage() posted = %v, want channel %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlackClient_UserTimezone(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  string
+		want    string
+		wantErr bool
+	}{
+		{name: "happy path", userID: "U123", want: "Europe/Berlin", wantErr: false},
+		{name: "user not found", userID: "U456", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSlackStandIn("xoxb-test")
+			defer srv.Close()
+			srv.tz["U123"] = "Europe/Berlin"
+
+			got, err := srv.client("xoxb-test").UserTimezone(tt.userID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SlackClient.UserTimezone() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("SlackClient.UserTimezone() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,11 +8,15 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/go-pg/pg"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
 )
 
 // DBInserter is an interface for storing barometer logs.
@@ -20,6 +24,52 @@ type DBInserter interface {
 	InsertDB(item LogItem) error // Insert a log into the Database
 }
 
+// DBQuerier is an interface for retrieving barometer logs.
+type DBQuerier interface {
+	QueryDB(q Query) ([]LogItem, error) // Fetch logs ordered by their timestamp
+}
+
+// DBPinger is an interface for checking that the database can be reached.
+type DBPinger interface {
+	PingDB() error
+}
+
+// Query filters the logs retrieved from the database.
+type Query struct {
+	TeamID string    // Only fetch the logs of this workspace, or all if empty
+	UserID string    // Only fetch the logs of this user, or everyone if empty
+	Since  time.Time // Only fetch logs on or after this time, if non-zero
+	Until  time.Time // Only fetch logs before this time, if non-zero
+}
+
+func (q Query) match(item LogItem) bool {
+	if q.TeamID != "" && item.TeamID != q.TeamID {
+		return false
+	}
+	if q.UserID != "" && item.UserID != q.UserID {
+		return false
+	}
+	if !q.Since.IsZero() && item.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !item.Timestamp.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// teamQuerier only fetches the logs of a single workspace, so that reports
+// and alerts never mix the data of different teams.
+type teamQuerier struct {
+	DBQuerier
+	teamID string
+}
+
+func (t teamQuerier) QueryDB(q Query) ([]LogItem, error) {
+	q.TeamID = t.teamID
+	return t.DBQuerier.QueryDB(q)
+}
+
 // NewDBInserter creates a DBInserter based on the detected scheme of the URL.
 func NewDBInserter(dburl string) (DBInserter, error) {
 	u, err := url.Parse(dburl)
@@ -36,9 +86,11 @@ func NewDBInserter(dburl string) (DBInserter, error) {
 	case "postgres":
 		log.WithFields(log.Fields{"scheme": u.Scheme}).Info("detected scheme")
 		db = &postgres{URL: dburl, Config: u}
+	case "memory":
+		log.WithFields(log.Fields{"scheme": u.Scheme}).Warn("detected scheme, logs will not be persisted")
+		db = &memory{}
 	default:
-		msg := fmt.Sprintf("unknown database scheme: %s", u.Scheme)
-		log.Fatal(msg)
+		return nil, fmt.Errorf("unknown database scheme: %s", u.Scheme)
 	}
 
 	return db, nil
@@ -49,14 +101,46 @@ func NewDBInserter(dburl string) (DBInserter, error) {
 type bigQuery struct {
 	URL    string
 	Config *url.URL
+
+	mu     sync.Mutex
+	client *bigquery.Client
+}
+
+// connect returns the client of the store, which is created on first use and
+// shared by all calls until the store is closed.
+func (t *bigQuery) connect() (*bigquery.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		return t.client, nil
+	}
+	project, _, _ := t.splitBQPath(t.Config.Host)
+	client, err := bigquery.NewClient(context.Background(), project)
+	if err != nil {
+		return nil, fmt.Errorf("error in bigquery.NewClient: %v", err)
+	}
+	t.client = client
+	return client, nil
+}
+
+// Close closes the client of the store, if it was ever used.
+func (t *bigQuery) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == nil {
+		return nil
+	}
+	err := t.client.Close()
+	t.client = nil
+	return err
 }
 
 func (t *bigQuery) InsertDB(item LogItem) error {
 	ctx := context.Background()
-	project, dataset, table := t.splitBQPath(t.Config.Host)
-	client, err := bigquery.NewClient(ctx, project)
+	_, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
 	if err != nil {
-		return fmt.Errorf("error in bigquery.NewClient: %v", err)
+		return err
 	}
 
 	inserter := client.Dataset(dataset).Table(table).Inserter()
@@ -68,11 +152,301 @@ func (t *bigQuery) InsertDB(item LogItem) error {
 	return nil
 }
 
+func (t *bigQuery) PingDB() error {
+	ctx := context.Background()
+	_, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.Dataset(dataset).Table(table).Metadata(ctx); err != nil {
+		return fmt.Errorf("error in Table.Metadata: %v", err)
+	}
+	return nil
+}
+
+func (t *bigQuery) QueryDB(q Query) ([]LogItem, error) {
+	ctx := context.Background()
+	project, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	// The optional columns may not exist, but team_id does if it is queried
+	where := []string{"TRUE"}
+	params := []bigquery.QueryParameter{}
+	if q.TeamID != "" {
+		where = append(where, "team_id = @team_id")
+		params = append(params, bigquery.QueryParameter{Name: "team_id", Value: q.TeamID})
+	}
+	if q.UserID != "" {
+		where = append(where, "user_id = @user_id")
+		params = append(params, bigquery.QueryParameter{Name: "user_id", Value: q.UserID})
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "timestamp >= @since")
+		params = append(params, bigquery.QueryParameter{Name: "since", Value: q.Since})
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "timestamp < @until")
+		params = append(params, bigquery.QueryParameter{Name: "until", Value: q.Until})
+	}
+
+	query := client.Query(fmt.Sprintf(
+		"SELECT timestamp, user_id, log_measure, notes FROM `%s.%s.%s` WHERE %s ORDER BY timestamp",
+		project, dataset, table, strings.Join(where, " AND "),
+	))
+	query.Parameters = params
+	it, err := query.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []LogItem{}
+	for {
+		var row struct {
+			Timestamp time.Time `bigquery:"timestamp"`
+			UserID    string    `bigquery:"user_id"`
+			Measure   int       `bigquery:"log_measure"`
+			Notes     string    `bigquery:"notes"`
+		}
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, LogItem{
+			Timestamp: row.Timestamp,
+			TeamID:    q.TeamID,
+			UserID:    row.UserID,
+			Measure:   row.Measure,
+			Notes:     row.Notes,
+		})
+	}
+	return items, nil
+}
+
 func (t *bigQuery) splitBQPath(p string) (string, string, string) {
 	s := strings.Split(p, ".")
 	return s[0], s[1], s[2]
 }
 
+// Preferences are stored in a separate table, suffixed with _preferences, in
+// the same dataset. Since streaming inserts can't be updated, the latest row
+// for each user wins.
+func (t *bigQuery) GetPreferences(userID string) (*Preferences, error) {
+	prefs, err := t.queryPreferences("WHERE user_id = @user_id", bigquery.QueryParameter{Name: "user_id", Value: userID})
+	if err != nil {
+		return nil, err
+	}
+	if len(prefs) == 0 {
+		return &Preferences{UserID: userID}, nil
+	}
+	return &prefs[0], nil
+}
+
+func (t *bigQuery) ListPreferences() ([]Preferences, error) {
+	return t.queryPreferences("")
+}
+
+func (t *bigQuery) SavePreferences(p Preferences) error {
+	ctx := context.Background()
+	_, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return err
+	}
+
+	p.UpdatedAt = time.Now()
+	inserter := client.Dataset(dataset).Table(table + "_preferences").Inserter()
+	return inserter.Put(ctx, &p)
+}
+
+func (t *bigQuery) queryPreferences(where string, params ...bigquery.QueryParameter) ([]Preferences, error) {
+	ctx := context.Background()
+	project, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	q := client.Query(fmt.Sprintf(
+		"SELECT * EXCEPT(row_number) FROM ("+
+			"SELECT *, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY updated_at DESC) AS row_number "+
+			"FROM `%s.%s.%s_preferences` %s) WHERE row_number = 1",
+		project, dataset, table, where,
+	))
+	q.Parameters = params
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := []Preferences{}
+	for {
+		var p Preferences
+		err := it.Next(&p)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		prefs = append(prefs, p)
+	}
+	return prefs, nil
+}
+
+// Installations are stored like preferences, in a table suffixed with
+// _installations where the latest row for each team wins.
+func (t *bigQuery) GetInstallation(teamID string) (*Installation, error) {
+	list, err := t.queryInstallations("WHERE team_id = @team_id", bigquery.QueryParameter{Name: "team_id", Value: teamID})
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotInstalled
+	}
+	return &list[0], nil
+}
+
+func (t *bigQuery) ListInstallations() ([]Installation, error) {
+	return t.queryInstallations("")
+}
+
+func (t *bigQuery) SaveInstallation(i Installation) error {
+	ctx := context.Background()
+	_, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return err
+	}
+
+	inserter := client.Dataset(dataset).Table(table + "_installations").Inserter()
+	return inserter.Put(ctx, &i)
+}
+
+func (t *bigQuery) queryInstallations(where string, params ...bigquery.QueryParameter) ([]Installation, error) {
+	ctx := context.Background()
+	project, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	q := client.Query(fmt.Sprintf(
+		"SELECT * EXCEPT(row_number) FROM ("+
+			"SELECT *, ROW_NUMBER() OVER (PARTITION BY team_id ORDER BY updated_at DESC, installed_at DESC) AS row_number "+
+			"FROM `%s.%s.%s_installations` %s) WHERE row_number = 1",
+		project, dataset, table, where,
+	))
+	q.Parameters = params
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Installation{}
+	for {
+		var i Installation
+		err := it.Next(&i)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, i)
+	}
+	return list, nil
+}
+
+// API tokens are stored like installations, in a table suffixed with
+// _api_tokens where the latest row for each user wins.
+func (t *bigQuery) GetAPIToken(userID string) (*APIToken, error) {
+	ctx := context.Background()
+	project, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	q := client.Query(fmt.Sprintf(
+		"SELECT * FROM `%s.%s.%s_api_tokens` WHERE user_id = @user_id ORDER BY issued_at DESC LIMIT 1",
+		project, dataset, table,
+	))
+	q.Parameters = []bigquery.QueryParameter{{Name: "user_id", Value: userID}}
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var token APIToken
+	if err := it.Next(&token); err == iterator.Done {
+		return nil, ErrNoToken
+	} else if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (t *bigQuery) SaveAPIToken(token APIToken) error {
+	ctx := context.Background()
+	_, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return err
+	}
+
+	inserter := client.Dataset(dataset).Table(table + "_api_tokens").Inserter()
+	return inserter.Put(ctx, &token)
+}
+
+// Alerts are stored like API tokens, in a table suffixed with _alerts where
+// the latest row for each user and rule wins.
+func (t *bigQuery) LastAlert(userID, rule string) (*Alert, error) {
+	ctx := context.Background()
+	project, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	q := client.Query(fmt.Sprintf(
+		"SELECT * FROM `%s.%s.%s_alerts` WHERE user_id = @user_id AND rule = @rule ORDER BY sent_at DESC LIMIT 1",
+		project, dataset, table,
+	))
+	q.Parameters = []bigquery.QueryParameter{{Name: "user_id", Value: userID}, {Name: "rule", Value: rule}}
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var a Alert
+	if err := it.Next(&a); err == iterator.Done {
+		return nil, ErrNoAlert
+	} else if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (t *bigQuery) SaveAlert(a Alert) error {
+	ctx := context.Background()
+	_, dataset, table := t.splitBQPath(t.Config.Host)
+	client, err := t.connect()
+	if err != nil {
+		return err
+	}
+
+	inserter := client.Dataset(dataset).Table(table + "_alerts").Inserter()
+	return inserter.Put(ctx, &a)
+}
+
 // Postgres
 
 type postgres struct {
@@ -89,9 +463,404 @@ func (t *postgres) InsertDB(item LogItem) error {
 	db := pg.Connect(opts)
 	defer db.Close()
 
-	if err := db.Insert(&item); err != nil {
+	// The optional columns may not exist unless they are set
+	query := db.Model(&item)
+	for column, value := range item.optionalColumns() {
+		if value == "" {
+			query = query.ExcludeColumn(column)
+		}
+	}
+	if _, err := query.Insert(); err != nil {
+		return fmt.Errorf("error in db.Insert: %v", err)
+	}
+
+	return nil
+}
+
+func (t *postgres) PingDB() error {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	if _, err := db.Exec("SELECT 1"); err != nil {
+		return fmt.Errorf("error in db.Exec: %v", err)
+	}
+	return nil
+}
+
+func (t *postgres) QueryDB(q Query) ([]LogItem, error) {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	items := []LogItem{}
+	// The optional columns may not exist, but team_id does if it is queried
+	query := db.Model(&items).Order("timestamp ASC")
+	for _, column := range ContextFields {
+		if column != "team_id" || q.TeamID == "" {
+			query = query.ExcludeColumn(column)
+		}
+	}
+	if q.TeamID != "" {
+		query = query.Where("team_id = ?", q.TeamID)
+	}
+	if q.UserID != "" {
+		query = query.Where("user_id = ?", q.UserID)
+	}
+	if !q.Since.IsZero() {
+		query = query.Where("timestamp >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		query = query.Where("timestamp < ?", q.Until)
+	}
+	if err := query.Select(); err != nil {
+		return nil, fmt.Errorf("error in db.Select: %v", err)
+	}
+	return items, nil
+}
+
+func (t *postgres) GetPreferences(userID string) (*Preferences, error) {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	p := &Preferences{UserID: userID}
+	if err := db.Select(p); err != nil {
+		if err == pg.ErrNoRows {
+			return &Preferences{UserID: userID}, nil
+		}
+		return nil, fmt.Errorf("error in db.Select: %v", err)
+	}
+	return p, nil
+}
+
+func (t *postgres) ListPreferences() ([]Preferences, error) {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	prefs := []Preferences{}
+	if err := db.Model(&prefs).Select(); err != nil {
+		return nil, fmt.Errorf("error in db.Select: %v", err)
+	}
+	return prefs, nil
+}
+
+func (t *postgres) SavePreferences(p Preferences) error {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	p.UpdatedAt = time.Now()
+	_, err = db.Model(&p).
+		OnConflict("(user_id) DO UPDATE").
+		Set("timezone = EXCLUDED.timezone").
+		Set("reminder = EXCLUDED.reminder").
+		Set("reply_style = EXCLUDED.reply_style").
+		Set("hide_notes = EXCLUDED.hide_notes").
+		Set("hide_from_reports = EXCLUDED.hide_from_reports").
+		Set("scale_labels = EXCLUDED.scale_labels").
+		Set("buddy = EXCLUDED.buddy").
+		Set("team_id = EXCLUDED.team_id").
+		Set("updated_at = EXCLUDED.updated_at").
+		Insert()
+	if err != nil {
 		return fmt.Errorf("error in db.Insert: %v", err)
 	}
+	return nil
+}
+
+func (t *postgres) GetInstallation(teamID string) (*Installation, error) {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	i := &Installation{TeamID: teamID}
+	if err := db.Select(i); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, ErrNotInstalled
+		}
+		return nil, fmt.Errorf("error in db.Select: %v", err)
+	}
+	return i, nil
+}
+
+func (t *postgres) ListInstallations() ([]Installation, error) {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
 
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	list := []Installation{}
+	if err := db.Model(&list).Select(); err != nil {
+		return nil, fmt.Errorf("error in db.Select: %v", err)
+	}
+	return list, nil
+}
+
+func (t *postgres) SaveInstallation(i Installation) error {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	_, err = db.Model(&i).
+		OnConflict("(team_id) DO UPDATE").
+		Set("team_name = EXCLUDED.team_name").
+		Set("enterprise_id = EXCLUDED.enterprise_id").
+		Set("bot_user_id = EXCLUDED.bot_user_id").
+		Set("bot_token = EXCLUDED.bot_token").
+		Set("scope = EXCLUDED.scope").
+		Set("installed_at = EXCLUDED.installed_at").
+		Set("updated_at = EXCLUDED.updated_at").
+		Insert()
+	if err != nil {
+		return fmt.Errorf("error in db.Insert: %v", err)
+	}
+	return nil
+}
+
+func (t *postgres) GetAPIToken(userID string) (*APIToken, error) {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	token := &APIToken{UserID: userID}
+	if err := db.Select(token); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, ErrNoToken
+		}
+		return nil, fmt.Errorf("error in db.Select: %v", err)
+	}
+	return token, nil
+}
+
+func (t *postgres) SaveAPIToken(token APIToken) error {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	_, err = db.Model(&token).
+		OnConflict("(user_id) DO UPDATE").
+		Set("team_id = EXCLUDED.team_id").
+		Set("hash = EXCLUDED.hash").
+		Set("issued_at = EXCLUDED.issued_at").
+		Insert()
+	if err != nil {
+		return fmt.Errorf("error in db.Insert: %v", err)
+	}
+	return nil
+}
+
+func (t *postgres) LastAlert(userID, rule string) (*Alert, error) {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	a := &Alert{UserID: userID, Rule: rule}
+	if err := db.Select(a); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, ErrNoAlert
+		}
+		return nil, fmt.Errorf("error in db.Select: %v", err)
+	}
+	return a, nil
+}
+
+func (t *postgres) SaveAlert(a Alert) error {
+	opts, err := pg.ParseURL(t.URL)
+	if err != nil {
+		return fmt.Errorf("error in pg.ParseURL: %v", err)
+	}
+
+	db := pg.Connect(opts)
+	defer db.Close()
+
+	_, err = db.Model(&a).
+		OnConflict("(user_id, rule) DO UPDATE").
+		Set("period = EXCLUDED.period").
+		Set("sent_at = EXCLUDED.sent_at").
+		Insert()
+	if err != nil {
+		return fmt.Errorf("error in db.Insert: %v", err)
+	}
+	return nil
+}
+
+// Memory
+
+// memory keeps everything in-memory. This is useful for trying out the
+// barometer locally and for testing.
+type memory struct {
+	mu            sync.Mutex
+	items         []LogItem
+	preferences   map[string]Preferences
+	installations map[string]Installation
+	apiTokens     map[string]APIToken
+	alerts        map[string]Alert
+}
+
+func (t *memory) InsertDB(item LogItem) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.items = append(t.items, item)
+	return nil
+}
+
+func (t *memory) PingDB() error {
+	return nil
+}
+
+func (t *memory) QueryDB(q Query) ([]LogItem, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	items := []LogItem{}
+	for _, item := range t.items {
+		if q.match(item) {
+			items = append(items, item)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Timestamp.Before(items[j].Timestamp)
+	})
+	return items, nil
+}
+
+func (t *memory) GetPreferences(userID string) (*Preferences, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.preferences[userID]; ok {
+		return &p, nil
+	}
+	return &Preferences{UserID: userID}, nil
+}
+
+func (t *memory) ListPreferences() ([]Preferences, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prefs := []Preferences{}
+	for _, p := range t.preferences {
+		prefs = append(prefs, p)
+	}
+	return prefs, nil
+}
+
+func (t *memory) SavePreferences(p Preferences) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.preferences == nil {
+		t.preferences = make(map[string]Preferences)
+	}
+	p.UpdatedAt = time.Now()
+	t.preferences[p.UserID] = p
+	return nil
+}
+
+func (t *memory) GetInstallation(teamID string) (*Installation, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i, ok := t.installations[teamID]; ok {
+		return &i, nil
+	}
+	return nil, ErrNotInstalled
+}
+
+func (t *memory) ListInstallations() ([]Installation, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := []Installation{}
+	for _, i := range t.installations {
+		list = append(list, i)
+	}
+	return list, nil
+}
+
+func (t *memory) SaveInstallation(i Installation) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.installations == nil {
+		t.installations = make(map[string]Installation)
+	}
+	t.installations[i.TeamID] = i
+	return nil
+}
+
+func (t *memory) GetAPIToken(userID string) (*APIToken, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if token, ok := t.apiTokens[userID]; ok {
+		return &token, nil
+	}
+	return nil, ErrNoToken
+}
+
+func (t *memory) SaveAPIToken(token APIToken) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.apiTokens == nil {
+		t.apiTokens = make(map[string]APIToken)
+	}
+	t.apiTokens[token.UserID] = token
+	return nil
+}
+
+func (t *memory) LastAlert(userID, rule string) (*Alert, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if a, ok := t.alerts[userID+"/"+rule]; ok {
+		return &a, nil
+	}
+	return nil, ErrNoAlert
+}
+
+func (t *memory) SaveAlert(a Alert) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.alerts == nil {
+		t.alerts = make(map[string]Alert)
+	}
+	t.alerts[a.UserID+"/"+a.Rule] = a
 	return nil
 }
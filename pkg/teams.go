@@ -0,0 +1,191 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const adaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+
+var (
+	// Teams mentions the webhook in the text, e.g. "<at>Barometer</at> 3"
+	teamsMentionPattern = regexp.MustCompile(`<at>.*?</at>`)
+	teamsTagPattern     = regexp.MustCompile(`<[^>]+>`)
+)
+
+// TeamsTransport receives logs through a Microsoft Teams outgoing webhook,
+// i.e. by mentioning the webhook in a channel: "@Barometer 3 long review day".
+type TeamsTransport struct {
+	// SecurityToken is the base64-encoded key that Teams shows when creating
+	// the outgoing webhook. Requests are signed with it.
+	SecurityToken string
+}
+
+// teamsActivity is the part of a Bot Framework activity that the barometer
+// needs. See https://docs.microsoft.com/microsoftteams/platform/webhooks-and-connectors/how-to/add-outgoing-webhook
+type teamsActivity struct {
+	Type      string    `json:"type"`
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"timestamp"`
+	From      struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		AADObjectID string `json:"aadObjectId"`
+	} `json:"from"`
+	ChannelData struct {
+		Team struct {
+			ID string `json:"id"`
+		} `json:"team"`
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+		Tenant struct {
+			ID string `json:"id"`
+		} `json:"tenant"`
+	} `json:"channelData"`
+}
+
+// Verify checks the HMAC-SHA256 signature of the body, which Teams sends in
+// the Authorization header as "HMAC <base64 signature>".
+func (t *TeamsTransport) Verify(r *http.Request, body []byte) error {
+	key, err := base64.StdEncoding.DecodeString(t.SecurityToken)
+	if err != nil {
+		return fmt.Errorf("invalid security token: %v", err)
+	}
+	sig := strings.TrimPrefix(r.Header.Get("Authorization"), "HMAC ")
+	if sig == "" {
+		return fmt.Errorf("missing signature")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	if !hmac.Equal([]byte(sig), []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// Parse extracts the log from the message. The users are identified by their
+// Azure AD object ID if available, as it's the same across teams.
+func (t *TeamsTransport) Parse(r *http.Request, body []byte) (*Submission, error) {
+	a := teamsActivity{}
+	if err := json.Unmarshal(body, &a); err != nil {
+		return nil, err
+	}
+	if a.Type != "message" {
+		return nil, fmt.Errorf("unexpected activity %q", a.Type)
+	}
+
+	userID := a.From.AADObjectID
+	if userID == "" {
+		userID = a.From.ID
+	}
+	if userID == "" {
+		return nil, fmt.Errorf("missing user")
+	}
+	return &Submission{
+		From: LogContext{
+			UserID:       "teams:" + userID,
+			UserName:     a.From.Name,
+			TeamID:       a.ChannelData.Team.ID,
+			EnterpriseID: a.ChannelData.Tenant.ID,
+			ChannelID:    a.ChannelData.Channel.ID,
+		},
+		Text:      teamsText(a.Text),
+		Timestamp: a.Timestamp,
+	}, nil
+}
+
+// Reply responds with an Adaptive Card, since Teams can't render the
+// attachments of a Slack message.
+func (t *TeamsTransport) Reply(w http.ResponseWriter, msg *Message) error {
+	type attachment struct {
+		ContentType string        `json:"contentType"`
+		Content     *AdaptiveCard `json:"content"`
+	}
+	type response struct {
+		Type        string       `json:"type"`
+		Attachments []attachment `json:"attachments"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response{
+		Type:        "message",
+		Attachments: []attachment{{ContentType: adaptiveCardContentType, Content: NewAdaptiveCard(msg)}},
+	})
+}
+
+// ReplyError responds to a failed log with a message, since Teams doesn't
+// show the body of failed requests.
+func (t *TeamsTransport) ReplyError(w http.ResponseWriter, err error) error {
+	return t.Reply(w, errorReply(err))
+}
+
+// teamsText removes the mention of the webhook and the HTML markup from the
+// text of a message.
+func teamsText(s string) string {
+	s = teamsMentionPattern.ReplaceAllString(s, "")
+	s = teamsTagPattern.ReplaceAllString(s, " ")
+	return strings.TrimSpace(strings.Replace(html.UnescapeString(s), "\u00a0", " ", -1))
+}
+
+// AdaptiveCard is a card rendered by Microsoft Teams. See
+// https://adaptivecards.io/explorer for the schema.
+type AdaptiveCard struct {
+	Type    string        `json:"type"`
+	Schema  string        `json:"$schema"`
+	Version string        `json:"version"`
+	Body    []CardElement `json:"body"`
+}
+
+// CardElement is a TextBlock or a Container of an AdaptiveCard.
+type CardElement struct {
+	Type   string        `json:"type"`
+	Text   string        `json:"text,omitempty"`
+	Weight string        `json:"weight,omitempty"`
+	Wrap   bool          `json:"wrap,omitempty"`
+	Style  string        `json:"style,omitempty"`
+	Items  []CardElement `json:"items,omitempty"`
+}
+
+// NewAdaptiveCard mirrors a Slack message as an Adaptive Card. Each attachment
+// becomes a container with its title and text.
+func NewAdaptiveCard(msg *Message) *AdaptiveCard {
+	card := &AdaptiveCard{
+		Type:    "AdaptiveCard",
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Version: "1.2",
+		Body:    []CardElement{{Type: "TextBlock", Text: msg.Text, Wrap: true}},
+	}
+	for _, a := range msg.Attachments {
+		c := CardElement{Type: "Container", Style: "emphasis"}
+		if a.Title != "" {
+			c.Items = append(c.Items, CardElement{Type: "TextBlock", Text: a.Title, Weight: "bolder", Wrap: true})
+		}
+		if a.Text != "" {
+			c.Items = append(c.Items, CardElement{Type: "TextBlock", Text: a.Text, Wrap: true})
+		}
+		card.Body = append(card.Body, c)
+	}
+	return card
+}
+
+// teams returns the Teams transport, or nil if it's not configured. It must
+// be called on a snapshot of the Server.
+func (s *Server) teams() Transport {
+	if s.Config.TeamsSecurityToken == "" {
+		return nil
+	}
+	return &TeamsTransport{SecurityToken: s.Config.TeamsSecurityToken}
+}
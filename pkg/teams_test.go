@@ -0,0 +1,153 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const teamsToken = "c2VjcmV0LXNlY3VyaXR5LXRva2Vu" // base64 of "secret-security-token"
+
+// teamsSign signs a body the way Teams does.
+func teamsSign(token, body string) string {
+	key, _ := base64.StdEncoding.DecodeString(token)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(body))
+	return "HMAC " + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+const teamsMessage = `{
+	"type": "message",
+	"text": "<at>Barometer</at> 3 long&nbsp;review day\n",
+	"timestamp": "2020-05-04T03:02:01.000Z",
+	"from": {"id": "29:1abc", "name": "Jane Doe", "aadObjectId": "aad-1"},
+	"channelData": {"team": {"id": "19:team"}, "channel": {"id": "19:channel"}, "tenant": {"id": "tenant-1"}}
+}`
+
+func TestTeamsTransport_Verify(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		auth    string
+		wantErr bool
+	}{
+		{name: "signed", token: teamsToken, auth: teamsSign(teamsToken, teamsMessage), wantErr: false},
+		{name: "missing signature", token: teamsToken, auth: "", wantErr: true},
+		{name: "other token", token: teamsToken, auth: teamsSign("b3RoZXI=", teamsMessage), wantErr: true},
+		{name: "invalid token", token: "not base64!", auth: teamsSign(teamsToken, teamsMessage), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/teams/messages", nil)
+			req.Header.Set("Authorization", tt.auth)
+			tr := &TeamsTransport{SecurityToken: tt.token}
+			if err := tr.Verify(req, []byte(teamsMessage)); (err != nil) != tt.wantErr {
+				t.Errorf("TeamsTransport.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTeamsTransport_Parse(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantUser string
+		wantText string
+		wantErr  bool
+	}{
+		{name: "message", body: teamsMessage, wantUser: "teams:aad-1", wantText: "3 long review day"},
+		{name: "without aad object", body: `{"type": "message", "text": "<p>2</p>", "from": {"id": "29:1abc"}}`, wantUser: "teams:29:1abc", wantText: "2"},
+		{name: "not a message", body: `{"type": "conversationUpdate", "from": {"id": "29:1abc"}}`, wantErr: true},
+		{name: "missing user", body: `{"type": "message", "text": "3"}`, wantErr: true},
+		{name: "malformed", body: `{"type": `, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &TeamsTransport{SecurityToken: teamsToken}
+			got, err := tr.Parse(httptest.NewRequest(http.MethodPost, "/teams/messages", nil), []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TeamsTransport.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.From.UserID != tt.wantUser || got.Text != tt.wantText {
+				t.Errorf("TeamsTransport.Parse() = %q %q, want %q %q", got.From.UserID, got.Text, tt.wantUser, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestNewAdaptiveCard(t *testing.T) {
+	msg := &Message{
+		Text:        "Gotcha",
+		Attachments: []Attachment{{Title: "A tweet", Text: "Drink some water"}, {Text: "Without title"}},
+	}
+	card := NewAdaptiveCard(msg)
+	if card.Type != "AdaptiveCard" || len(card.Body) != 3 {
+		t.Fatalf("NewAdaptiveCard() = %+v, want a text block and 2 containers", card)
+	}
+	if card.Body[0].Text != "Gotcha" {
+		t.Errorf("NewAdaptiveCard() text = %q, want %q", card.Body[0].Text, "Gotcha")
+	}
+	if items := card.Body[1].Items; len(items) != 2 || items[0].Weight != "bolder" || items[1].Text != "Drink some water" {
+		t.Errorf("NewAdaptiveCard() attachment = %+v", items)
+	}
+	if items := card.Body[2].Items; len(items) != 1 {
+		t.Errorf("NewAdaptiveCard() attachment without title = %+v", items)
+	}
+}
+
+func TestServer_handleTeams(t *testing.T) {
+	db := &memory{}
+	s := &Server{
+		Config:   &Configuration{Area: "Asia/Manila", TeamsSecurityToken: teamsToken, ContextFields: "team_id,enterprise_id"},
+		database: db,
+	}
+	req := httptest.NewRequest(http.MethodPost, "/teams/messages", strings.NewReader(teamsMessage))
+	req.Header.Set("Authorization", teamsSign(teamsToken, teamsMessage))
+	rec := httptest.NewRecorder()
+	s.handleTransport("/teams/messages", s.teams)(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("handleTeams() status = %d: %s", rec.Code, rec.Body)
+	}
+	resp := struct {
+		Type        string `json:"type"`
+		Attachments []struct {
+			ContentType string       `json:"contentType"`
+			Content     AdaptiveCard `json:"content"`
+		} `json:"attachments"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("handleTeams() returned invalid JSON: %v", err)
+	}
+	if resp.Type != "message" || len(resp.Attachments) != 1 || resp.Attachments[0].ContentType != adaptiveCardContentType {
+		t.Fatalf("handleTeams() = %+v, want an adaptive card", resp)
+	}
+	if text := resp.Attachments[0].Content.Body[0].Text; !strings.HasPrefix(text, ackPrefix) {
+		t.Errorf("handleTeams() card text = %q, want %q", text, ackPrefix)
+	}
+
+	items, _ := db.QueryDB(Query{})
+	if len(items) != 1 {
+		t.Fatalf("handleTeams() stored %d logs, want 1", len(items))
+	}
+	got := items[0]
+	if got.UserID != "teams:aad-1" || got.Measure != 3 || got.Notes != "long review day" || got.TeamID != "19:team" || got.EnterpriseID != "tenant-1" || got.ChannelID != "" {
+		t.Errorf("handleTeams() stored %+v", got)
+	}
+	if got.Timestamp.UTC().Format("2006-01-02 15:04:05") != "2020-05-04 03:02:01" {
+		t.Errorf("handleTeams() stored timestamp %v", got.Timestamp)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// defaultACMECacheDir is where certificates obtained through ACME are cached
+// if ACMECacheDir is not set.
+const defaultACMECacheDir = "certs"
+
+// acmeDomains returns the domains to obtain certificates for.
+func (cfg *Configuration) acmeDomains() []string {
+	domains := []string{}
+	for _, d := range strings.Split(cfg.ACMEDomains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// certManager creates the ACME certificate manager, or nil if ACME is not
+// configured.
+func (cfg *Configuration) certManager() *autocert.Manager {
+	domains := cfg.acmeDomains()
+	if len(domains) == 0 {
+		return nil
+	}
+	dir := cfg.ACMECacheDir
+	if dir == "" {
+		dir = defaultACMECacheDir
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(dir),
+		Email:      cfg.ACMEEmail,
+	}
+}
+
+// configureTLS prepares the HTTP server for TLS if it is configured, along
+// with the server that redirects plain HTTP requests to HTTPS.
+func (s *Server) configureTLS() error {
+	cfg := s.Config
+	m := cfg.certManager()
+	if cfg.TLSCert == "" && m == nil {
+		return nil
+	}
+
+	var redirect http.Handler = redirectHTTPS(s.Port)
+	addr := cfg.RedirectAddr
+	if m != nil {
+		// The HTTP-01 challenge of ACME is served on port 80
+		redirect = m.HTTPHandler(redirect)
+		if addr == "" {
+			addr = ":80"
+		}
+		s.http.TLSConfig = m.TLSConfig()
+	} else {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return err
+		}
+		s.http.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	if addr != "" {
+		s.redirect = &http.Server{
+			Addr:         addr,
+			Handler:      redirect,
+			ReadTimeout:  s.http.ReadTimeout,
+			WriteTimeout: s.http.WriteTimeout,
+			IdleTimeout:  s.http.IdleTimeout,
+		}
+	}
+	return nil
+}
+
+// serveTLS handles requests over TLS and starts redirecting plain HTTP
+// requests. It returns false if TLS is not configured.
+func (s *Server) serveTLS(ln net.Listener) (bool, error) {
+	if s.http.TLSConfig == nil {
+		return false, nil
+	}
+
+	if s.redirect != nil {
+		go func() {
+			log.Infof("redirecting HTTP requests on %s to HTTPS", s.redirect.Addr)
+			if err := s.redirect.ListenAndServe(); err != http.ErrServerClosed {
+				log.WithFields(log.Fields{"err": err}).Error("http.Server.ListenAndServe")
+			}
+		}()
+	}
+
+	log.Info("serving over TLS")
+	return true, s.http.ServeTLS(ln, "", "")
+}
+
+// redirectHTTPS redirects requests to the same URL over HTTPS on the given
+// port.
+func redirectHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 && port != 0 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
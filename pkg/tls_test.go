@@ -0,0 +1,137 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 and its
+// key into dir.
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("cannot marshal key: %v", err)
+	}
+
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certPath, keyPath
+}
+
+func TestServer_serveTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cert, key := writeCertificate(t, dir)
+
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/", func(w http.ResponseWriter, r *http.Request) {})
+	s := &Server{Router: router, Config: &Configuration{
+		Table: "memory://", Token: "token", Area: "Asia/Manila",
+		TLSCert: cert, TLSKey: key, RedirectAddr: "127.0.0.1:0",
+	}}
+	if err := s.setup(); err != nil {
+		t.Fatalf("Server.setup() error = %v", err)
+	}
+	if s.redirect == nil {
+		t.Errorf("Server.setup() did not prepare the HTTP redirect")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.serve(ln) }()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("GET over TLS error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("GET over TLS = %d, TLS %v", resp.StatusCode, resp.TLS != nil)
+	}
+
+	s.Shutdown(context.Background())
+	if err := <-served; err != nil {
+		t.Errorf("Server.serve() error = %v", err)
+	}
+}
+
+func TestServer_setup_tls(t *testing.T) {
+	s := &Server{Router: httprouter.New(), Config: &Configuration{
+		Table: "memory://", Token: "token", Area: "Asia/Manila",
+		TLSCert: "testdata/missing.pem", TLSKey: "testdata/missing.key",
+	}}
+	if err := s.setup(); err == nil {
+		t.Fatalf("Server.setup() error = nil, want missing certificate")
+	}
+
+	// Nothing was started that would have to be stopped
+	if s.scheduler != nil || s.queue != nil || s.tracing != nil {
+		t.Errorf("Server.setup() started the scheduler, queue or tracing before loading the certificate")
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		name string
+		port int
+		host string
+		want string
+	}{
+		{name: "default port", port: 443, host: "barometer.example.com", want: "https://barometer.example.com/log?a=b"},
+		{name: "custom port", port: 8443, host: "barometer.example.com:8080", want: "https://barometer.example.com:8443/log?a=b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/log?a=b", nil)
+			req.Host = tt.host
+			rr := httptest.NewRecorder()
+			redirectHTTPS(tt.port).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != tt.want {
+				t.Errorf("redirectHTTPS() = %d %s, want %s", rr.Code, rr.Header().Get("Location"), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// instrumentationName identifies the spans created by the barometer.
+	instrumentationName = "github.com/ljvmiranda921/burnout-barometer"
+
+	// serviceName is reported as the service.name of all spans.
+	serviceName = "burnout-barometer"
+)
+
+// tracer returns the tracer of the global provider, which doesn't record
+// anything unless tracing is configured.
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traced starts a server span for each request handled by h.
+func traced(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer().Start(r.Context(), name, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethodKey.String(r.Method), semconv.HTTPTargetKey.String(r.URL.Path)))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h(rec, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(rec.code))
+		if rec.code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.code))
+		}
+	}
+}
+
+// NewTracerProvider creates a provider that exports spans to the OTLP
+// endpoint of the configuration, or nil if tracing is disabled.
+func NewTracerProvider(cfg *Configuration) *sdktrace.TracerProvider {
+	if cfg.OTLPEndpoint == "" {
+		return nil
+	}
+	res := sdkresource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(NewOTLPExporter(cfg.OTLPEndpoint)),
+		sdktrace.WithResource(res),
+	)
+}
+
+// configureTracing exports spans to the OTLP endpoint of cfg, and shuts down
+// the provider of the previous configuration in the background. The caller
+// must hold the write lock of s.mu.
+func (s *Server) configureTracing(cfg *Configuration) {
+	old := s.tracing
+	s.tracing = NewTracerProvider(cfg)
+	switch {
+	case s.tracing != nil:
+		otel.SetTracerProvider(s.tracing)
+	case old != nil:
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}
+	if old == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+		defer cancel()
+		if err := old.Shutdown(ctx); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("TracerProvider.Shutdown")
+		}
+	}()
+}
+
+// OTLPExporter exports spans to an OpenTelemetry collector through OTLP/HTTP
+// with JSON encoding, see https://opentelemetry.io/docs/specs/otlp/. It stands
+// in for the otlptracehttp exporter, which needs a newer gRPC than the one
+// that google.golang.org/api v0.13 builds against, until the BigQuery client
+// is upgraded.
+type OTLPExporter struct {
+	URL    string // e.g. http://localhost:4318/v1/traces
+	Client *http.Client
+}
+
+// NewOTLPExporter creates an exporter for the collector at endpoint.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		URL:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ExportSpans sends the spans to the collector.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown does nothing, as the exporter holds no resources.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// The types below follow the JSON mapping of the OTLP protobuf messages.
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpEvent struct {
+	Name         string         `json:"name"`
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes,omitempty"`
+	} `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpRequest groups the spans by resource and instrumentation scope.
+func otlpRequest(spans []sdktrace.ReadOnlySpan) map[string][]*otlpResourceSpans {
+	resources := []*otlpResourceSpans{}
+	byResource := map[*sdkresource.Resource]*otlpResourceSpans{}
+	byScope := map[*otlpResourceSpans]map[string]*otlpScopeSpans{}
+
+	for _, s := range spans {
+		rs, ok := byResource[s.Resource()]
+		if !ok {
+			rs = &otlpResourceSpans{}
+			if s.Resource() != nil {
+				rs.Resource.Attributes = otlpAttributes(s.Resource().Attributes())
+			}
+			byResource[s.Resource()] = rs
+			byScope[rs] = map[string]*otlpScopeSpans{}
+			resources = append(resources, rs)
+		}
+
+		scope := s.InstrumentationScope()
+		ss, ok := byScope[rs][scope.Name+"@"+scope.Version]
+		if !ok {
+			ss = &otlpScopeSpans{}
+			ss.Scope.Name, ss.Scope.Version = scope.Name, scope.Version
+			byScope[rs][scope.Name+"@"+scope.Version] = ss
+			rs.ScopeSpans = append(rs.ScopeSpans, ss)
+		}
+		ss.Spans = append(ss.Spans, otlpFromSpan(s))
+	}
+	return map[string][]*otlpResourceSpans{"resourceSpans": resources}
+}
+
+func otlpFromSpan(s sdktrace.ReadOnlySpan) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.SpanContext().TraceID().String(),
+		SpanID:            s.SpanContext().SpanID().String(),
+		Name:              s.Name(),
+		Kind:              int(s.SpanKind()),
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.EndTime().UnixNano(), 10),
+		Attributes:        otlpAttributes(s.Attributes()),
+	}
+	if s.Parent().IsValid() {
+		span.ParentSpanID = s.Parent().SpanID().String()
+	}
+	for _, e := range s.Events() {
+		span.Events = append(span.Events, otlpEvent{
+			Name:         e.Name,
+			TimeUnixNano: strconv.FormatInt(e.Time.UnixNano(), 10),
+			Attributes:   otlpAttributes(e.Attributes),
+		})
+	}
+
+	// The status codes of OTLP differ from the ones of the API
+	switch s.Status().Code {
+	case codes.Ok:
+		span.Status.Code = 1
+	case codes.Error:
+		span.Status.Code = 2
+		span.Status.Message = s.Status().Description
+	}
+	return span
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []otlpKeyValue {
+	kvs := []otlpKeyValue{}
+	for _, a := range attrs {
+		var value map[string]interface{}
+		switch a.Value.Type() {
+		case attribute.BOOL:
+			value = map[string]interface{}{"boolValue": a.Value.AsBool()}
+		case attribute.INT64:
+			// 64-bit integers are encoded as strings in JSON
+			value = map[string]interface{}{"intValue": strconv.FormatInt(a.Value.AsInt64(), 10)}
+		case attribute.FLOAT64:
+			value = map[string]interface{}{"doubleValue": a.Value.AsFloat64()}
+		default:
+			value = map[string]interface{}{"stringValue": a.Value.Emit()}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: string(a.Key), Value: value})
+	}
+	return kvs
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans records the spans of the test in memory.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+	return exporter
+}
+
+func TestServer_handleLog_tracing(t *testing.T) {
+	tests := []struct {
+		name       string
+		db         DBInserter
+		wantSpans  []string // in the order they end
+		wantFailed []string // the spans that record an error
+	}{
+		{
+			name:      "happy path",
+			db:        &memory{},
+			wantSpans: []string{"ParseMessage", "InsertDB", "StoreLog", "handleLog"},
+		},
+		{
+			name:       "failed insert",
+			db:         &failingDB{},
+			wantSpans:  []string{"ParseMessage", "InsertDB", "StoreLog", "handleLog"},
+			wantFailed: []string{"InsertDB", "StoreLog"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := recordSpans(t)
+			s := &Server{Config: &Configuration{Token: "token", Area: "Asia/Manila"}, database: tt.db}
+
+			form := url.Values{"token": {"token"}, "user_id": {"U1"}, "text": {"3 long review day"}}
+			req := httptest.NewRequest(http.MethodPost, "/log", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Slack-Request-Timestamp", "1579324284")
+			traced("handleLog", s.handleLog())(httptest.NewRecorder(), req)
+
+			spans := exporter.GetSpans()
+			if len(spans) != len(tt.wantSpans) {
+				t.Fatalf("recorded %d spans, want %v", len(spans), tt.wantSpans)
+			}
+			root := spans[len(spans)-1].SpanContext.TraceID()
+			for i, span := range spans {
+				if span.Name != tt.wantSpans[i] {
+					t.Errorf("span %d = %s, want %s", i, span.Name, tt.wantSpans[i])
+				}
+				if span.SpanContext.TraceID() != root {
+					t.Errorf("span %s is not part of the request trace", span.Name)
+				}
+				if failed := span.Status.Code == codes.Error; failed != contains(tt.wantFailed, span.Name) {
+					t.Errorf("span %s status = %v", span.Name, span.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestOTLPExporter_ExportSpans(t *testing.T) {
+	var got map[string][]struct {
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID      string `json:"traceId"`
+				ParentSpanID string `json:"parentSpanId"`
+				Name         string `json:"name"`
+				Status       struct {
+					Code int `json:"code"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer collector.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	_, child := tp.Tracer("test").Start(ctx, "child")
+	endSpan(child, errors.New("failed"))
+	parent.End()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "happy path", url: collector.URL},
+		{name: "wrong path", url: collector.URL + "/collector", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewOTLPExporter(tt.url)
+			err := e.ExportSpans(context.Background(), exporter.GetSpans().Snapshots())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OTLPExporter.ExportSpans() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	spans := got["resourceSpans"][0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "child" || spans[0].ParentSpanID == "" || spans[0].Status.Code != 2 {
+		t.Errorf("OTLPExporter.ExportSpans() sent %+v", spans)
+	}
+	if len(spans[0].TraceID) != 32 || spans[0].TraceID != spans[1].TraceID {
+		t.Errorf("OTLPExporter.ExportSpans() sent trace IDs %s and %s", spans[0].TraceID, spans[1].TraceID)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestServer_Reload_tracing(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, to    string
+		wantTracing bool
+		wantKept    bool // whether the provider of the previous configuration is kept
+	}{
+		{name: "enabled", to: "http://localhost:4318", wantTracing: true},
+		{name: "disabled", from: "http://localhost:4318", wantTracing: false},
+		{name: "endpoint changed", from: "http://localhost:4318", to: "http://collector:4318", wantTracing: true},
+		{name: "endpoint unchanged", from: "http://localhost:4318", to: "http://localhost:4318", wantTracing: true, wantKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+			current := &Configuration{Table: "memory://", Token: "token", Area: "Asia/Manila", OTLPEndpoint: tt.from}
+			next := &Configuration{Table: "memory://", Token: "token", Area: "Asia/Manila", OTLPEndpoint: tt.to}
+			s := newReloadServer(t, current, func() (*Configuration, error) { return next, nil })
+			defer close(s.stop)
+			s.configureTracing(current)
+			old := s.tracing
+
+			if err := s.Reload(); err != nil {
+				t.Fatalf("Server.Reload() error = %v", err)
+			}
+			if (s.tracing != nil) != tt.wantTracing {
+				t.Errorf("Server.Reload() tracing = %v, want %v", s.tracing != nil, tt.wantTracing)
+			}
+			if kept := old != nil && s.tracing == old; kept != tt.wantKept {
+				t.Errorf("Server.Reload() kept the tracer provider = %v, want %v", kept, tt.wantKept)
+			}
+			if tt.wantTracing && otel.GetTracerProvider() != s.tracing {
+				t.Errorf("Server.Reload() did not set the global tracer provider")
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"4d63.com/tz"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxBodySize bounds the size of the requests read by a Transport.
+const maxBodySize = 1 << 20
+
+// Transport connects a chat platform to the barometer. It verifies and parses
+// the requests of the platform, and renders the replies of UpdateLog in its
+// format. The interactive messages of Slack have a handler of their own.
+type Transport interface {
+	// Verify checks that the request with the given body was sent by the
+	// platform.
+	Verify(r *http.Request, body []byte) error
+	// Parse extracts the log from a verified request.
+	Parse(r *http.Request, body []byte) (*Submission, error)
+	// Reply writes a message as the response to the request.
+	Reply(w http.ResponseWriter, msg *Message) error
+}
+
+// Acknowledger is implemented by transports whose platform also sends
+// requests that aren't logs, e.g. to check the endpoint or to run a
+// subcommand. Acknowledge responds to a verified request if it's one of
+// those, and reports whether it did.
+type Acknowledger interface {
+	Acknowledge(w http.ResponseWriter, r *http.Request, body []byte) bool
+}
+
+// ErrorReplier is implemented by transports whose platform doesn't show the
+// body of a failed request, so that mistakes such as an invalid mood-level
+// are replied to the user as a message instead.
+type ErrorReplier interface {
+	ReplyError(w http.ResponseWriter, err error) error
+}
+
+// Submission is a log sent through a Transport.
+type Submission struct {
+	From      LogContext // UserID is prefixed with the platform, e.g. "teams:"
+	Text      string     // Mood-level and notes, e.g. "3 long review day"
+	Timestamp time.Time  // When the log was sent, or zero to use the current time
+
+	// Workspace is the Slack workspace verified by verifyTeam, only set if
+	// the app is installed into several.
+	Workspace string
+}
+
+// handleTransport handles the logs sent through a transport. The transport
+// is looked up on each request since it depends on the configuration, and a
+// nil transport means that the platform is disabled.
+func (s *Server) handleTransport(route string, transport func() Transport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger(r.Context()).WithFields(log.Fields{"path": route}).Trace("received request")
+		t := transport()
+		if t == nil {
+			w.Header().Set("Content-Type", "application/json")
+			e := errorMsg{Message: fmt.Sprintf("%s is disabled", route), Code: http.StatusNotFound}
+			e.JSONError(w)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			e := errorMsg{Message: fmt.Sprintf("couldn't read body: %s", err), Code: http.StatusBadRequest}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("ioutil.ReadAll")
+			return
+		}
+		if err := t.Verify(r, body); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			e := errorMsg{Message: fmt.Sprintf("request may be forged: %s", err), Code: http.StatusUnauthorized}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("Transport.Verify")
+			verificationFailures.WithLabelValues(route).Inc()
+			return
+		}
+		if a, ok := t.(Acknowledger); ok && a.Acknowledge(w, r, body) {
+			return
+		}
+		sub, err := t.Parse(r, body)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			e := errorMsg{Message: fmt.Sprintf("couldn't parse request: %s", err), Code: http.StatusBadRequest}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("Transport.Parse")
+			return
+		}
+
+		resp, err := s.submit(r.Context(), sub)
+		if err != nil {
+			if e, ok := t.(ErrorReplier); ok {
+				if err := e.ReplyError(w, err); err != nil {
+					logger(r.Context()).WithFields(log.Fields{"err": err}).Error("Transport.ReplyError")
+				}
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			e := errorMsg{Message: fmt.Sprintf("error in processing request: %s", err), Code: http.StatusBadRequest}
+			e.JSONError(w)
+			logger(r.Context()).WithFields(log.Fields{"err": e.Message}).Error("submit")
+			return
+		}
+		if err := t.Reply(w, resp); err != nil {
+			logger(r.Context()).WithFields(log.Fields{"err": err}).Error("Transport.Reply")
+		}
+	}
+}
+
+// submit stores a log sent through a transport and returns the reply.
+func (s *Server) submit(ctx context.Context, sub *Submission) (*Message, error) {
+	item, prefs, resp, err := s.store(ctx, sub)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+	return replyTo(ctx, item, s.twitterClient(), prefs)
+}
+
+// store checks the limits of the user, stamps the log in the user's timezone
+// and stores it, as in StoreLog. If a limit is reached, it returns the reply
+// explaining why instead of the item. It must be called on a snapshot of the
+// Server.
+func (s *Server) store(ctx context.Context, sub *Submission) (*LogItem, *Preferences, *Message, error) {
+	from := s.logContext(sub.From, sub.Workspace)
+	prefs := s.userPreferences(from.UserID)
+	area := s.area(sub.Workspace, prefs)
+	if resp, err := s.limitUser(sub.Workspace, from.UserID, area, time.Now()); err != nil {
+		logger(ctx).WithFields(log.Fields{"err": err}).Error("limitUser")
+	} else if resp != nil {
+		return nil, prefs, resp, nil
+	}
+
+	loc, err := tz.LoadLocation(area)
+	if err != nil {
+		return nil, prefs, nil, err
+	}
+	timestamp := sub.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	item, err := StoreLog(ctx, from, sub.Text, timestamp.In(loc), s.database, prefs, s.alerts, s.Debug)
+	if err != nil {
+		logger(ctx).WithFields(log.Fields{"err": err}).Error("StoreLog")
+		return nil, prefs, nil, err
+	}
+	return item, prefs, nil, nil
+}
+
+// verifyTokens checks a token against a comma-separated list of the tokens of
+// a platform, where each command or webhook has a token of its own.
+func verifyTokens(token, tokens string) error {
+	if token == "" {
+		return fmt.Errorf("empty token")
+	}
+	for _, t := range strings.Split(tokens, ",") {
+		if t = strings.TrimSpace(t); t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return nil
+		}
+	}
+	// Never echo the submitted token, as errors end up in logs and replies
+	return fmt.Errorf("invalid request/credentials")
+}
+
+// platformUser reports whether a user ID was prefixed by a Transport, since
+// Slack user IDs never contain a colon. These users can't be reached through
+// Slack, e.g. for alerts and digests.
+func platformUser(userID string) bool {
+	return strings.Contains(userID, ":")
+}
+
+// errorReply is the message replied by an ErrorReplier when a log fails.
+func errorReply(err error) *Message {
+	return &Message{ResponseType: "ephemeral", Text: fmt.Sprintf("Sorry, I couldn't log that: %s", err)}
+}
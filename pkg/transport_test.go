@@ -0,0 +1,118 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeTransport accepts requests signed with "ok", and logs the body as the
+// text of user "fake:U1".
+type fakeTransport struct{}
+
+func (fakeTransport) Verify(r *http.Request, body []byte) error {
+	if r.Header.Get("Authorization") != "ok" {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func (fakeTransport) Parse(r *http.Request, body []byte) (*Submission, error) {
+	if len(body) == 0 {
+		return nil, fmt.Errorf("empty message")
+	}
+	return &Submission{From: LogContext{UserID: "fake:U1", ChannelID: "C1"}, Text: string(body)}, nil
+}
+
+func (fakeTransport) Reply(w http.ResponseWriter, msg *Message) error {
+	return json.NewEncoder(w).Encode(msg)
+}
+
+// replyingTransport is a fakeTransport that replies to failed logs with a
+// message.
+type replyingTransport struct{ fakeTransport }
+
+func (t replyingTransport) ReplyError(w http.ResponseWriter, err error) error {
+	return t.Reply(w, errorReply(err))
+}
+
+func TestServer_handleTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport Transport
+		auth      string
+		body      string
+		wantCode  int
+		wantText  string // substring of the reply
+		wantItems int
+	}{
+		{name: "disabled", transport: nil, auth: "ok", body: "3", wantCode: http.StatusNotFound},
+		{name: "forged", transport: fakeTransport{}, auth: "forged", body: "3", wantCode: http.StatusUnauthorized},
+		{name: "unparseable", transport: fakeTransport{}, auth: "ok", body: "", wantCode: http.StatusBadRequest},
+		{name: "logged", transport: fakeTransport{}, auth: "ok", body: "3 long review day", wantCode: http.StatusOK, wantText: ackPrefix, wantItems: 1},
+		{name: "empty text", transport: fakeTransport{}, auth: "ok", body: " ", wantCode: http.StatusBadRequest, wantText: "empty text"},
+		{name: "invalid mood", transport: fakeTransport{}, auth: "ok", body: "great", wantCode: http.StatusBadRequest, wantText: "error in processing request"},
+		{name: "invalid mood replied", transport: replyingTransport{}, auth: "ok", body: "great", wantCode: http.StatusOK, wantText: "couldn't log that"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &memory{}
+			s := &Server{Config: &Configuration{Area: "Asia/Manila"}, database: db}
+
+			req := httptest.NewRequest(http.MethodPost, "/fake", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", tt.auth)
+			rec := httptest.NewRecorder()
+			s.handleTransport("/fake", func() Transport { return tt.transport })(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("handleTransport() status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantText) {
+				t.Errorf("handleTransport() = %s, want %q", rec.Body, tt.wantText)
+			}
+			items, _ := db.QueryDB(Query{})
+			if len(items) != tt.wantItems {
+				t.Fatalf("handleTransport() stored %d logs, want %d", len(items), tt.wantItems)
+			}
+			if tt.wantItems > 0 && (items[0].UserID != "fake:U1" || items[0].Measure != 3 || items[0].ChannelID != "") {
+				t.Errorf("handleTransport() stored %+v", items[0])
+			}
+		})
+	}
+}
+
+func TestPlatformUser(t *testing.T) {
+	for userID, want := range map[string]bool{"U1": false, "W012A3CDE": false, "teams:29:1abc": true} {
+		if got := platformUser(userID); got != want {
+			t.Errorf("platformUser(%q) = %v, want %v", userID, got, want)
+		}
+	}
+}
+
+func TestVerifyTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		tokens  string
+		wantErr bool
+	}{
+		{name: "single", token: "t1", tokens: "t1", wantErr: false},
+		{name: "one of several", token: "t2", tokens: "t1, t2", wantErr: false},
+		{name: "unknown", token: "t3", tokens: "t1,t2", wantErr: true},
+		{name: "empty", token: "", tokens: "t1,", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyTokens(tt.token, tt.tokens); (err != nil) != tt.wantErr {
+				t.Errorf("verifyTokens() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 Lester James V. Miranda. All rights reserved.
+// Licensed under the MIT License. See LICENSE in the project root
+// for license information.
+
+package pkg
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"4d63.com/tz"
+	"github.com/go-pg/pg"
+)
+
+// ValidationError lists all problems found in a Configuration.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// Validate checks the configuration for mistakes that would otherwise only
+// show up while serving requests, such as an unknown database scheme or an
+// invalid timezone. It returns a ValidationError listing all problems.
+func (cfg *Configuration) Validate() error {
+	var errs ValidationError
+	addf := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, a...))
+	}
+
+	if err := validateTable(cfg.Table); err != nil {
+		addf("TABLE: %v", err)
+	}
+	if cfg.Token == "" && cfg.TeamsSecurityToken == "" && cfg.DiscordPublicKey == "" && cfg.MattermostTokens == "" && cfg.RocketChatTokens == "" {
+		addf("SLACK_TOKEN, TEAMS_SECURITY_TOKEN, DISCORD_PUBLIC_KEY, MATTERMOST_TOKENS or ROCKETCHAT_TOKENS is required for receiving logs")
+	}
+	if _, err := tz.LoadLocation(cfg.Area); err != nil || cfg.Area == "" {
+		addf("AREA: unknown IANA timezone %q", cfg.Area)
+	}
+
+	twitter := []string{cfg.TwitterConsumerKey, cfg.TwitterConsumerSecret, cfg.TwitterAccessKey, cfg.TwitterAccessSecret}
+	if ContainsEmpty(twitter...) && strings.Join(twitter, "") != "" {
+		addf("TWITTER_*: all four Twitter keys are required to fetch messages")
+	}
+
+	for i, r := range cfg.Reminders {
+		if r.UserID == "" {
+			addf("REMINDERS[%d]: USER_ID is required", i)
+		}
+		if _, err := time.Parse("15:04", r.Time); err != nil {
+			addf("REMINDERS[%d]: TIME %q is not in the HH:MM format", i, r.Time)
+		}
+		if _, err := tz.LoadLocation(r.Area); r.Area != "" && err != nil {
+			addf("REMINDERS[%d]: unknown IANA timezone %q", i, r.Area)
+		}
+	}
+
+	for i, r := range cfg.Alerts {
+		switch r.Kind {
+		case RuleConsecutiveLow, RuleAverageDrop, RuleInactivity:
+		default:
+			addf("ALERTS[%d]: unknown KIND %q", i, r.Kind)
+		}
+		if r.Count < 1 {
+			addf("ALERTS[%d]: COUNT must be at least 1", i)
+		}
+	}
+
+	if cfg.ReportDay != "" {
+		if _, err := ParseWeekday(cfg.ReportDay); err != nil {
+			addf("REPORT_DAY: %v", err)
+		}
+		if _, err := time.Parse("15:04", cfg.ReportTime); cfg.ReportTime != "" && err != nil {
+			addf("REPORT_TIME: %q is not in the HH:MM format", cfg.ReportTime)
+		}
+		if cfg.BotToken == "" && cfg.SlackClientID == "" {
+			addf("REPORT_DAY: SLACK_BOT_TOKEN or SLACK_CLIENT_ID is required for sending reports")
+		}
+	}
+
+	if (cfg.SlackClientID == "") != (cfg.SlackClientSecret == "") {
+		addf("SLACK_CLIENT_ID and SLACK_CLIENT_SECRET must be set together")
+	}
+	if u, err := url.Parse(cfg.SlackRedirectURL); cfg.SlackRedirectURL != "" && (err != nil || u.Scheme != "https") {
+		addf("SLACK_REDIRECT_URL: %q is not an https URL", cfg.SlackRedirectURL)
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(cfg.TeamsSecurityToken); err != nil {
+		addf("TEAMS_SECURITY_TOKEN: not the base64-encoded token shown by Teams")
+	}
+
+	if _, err := NewDiscordTransport(cfg.DiscordPublicKey); cfg.DiscordPublicKey != "" && err != nil {
+		addf("DISCORD_PUBLIC_KEY: %v", err)
+	}
+
+	for _, t := range []struct{ key, value string }{
+		{"READ_TIMEOUT", cfg.ReadTimeout},
+		{"WRITE_TIMEOUT", cfg.WriteTimeout},
+		{"IDLE_TIMEOUT", cfg.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout},
+	} {
+		if d, err := time.ParseDuration(t.value); t.value != "" && (err != nil || d < 0) {
+			addf("%s: %q is not a duration such as 10s", t.key, t.value)
+		}
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		addf("TLS_CERT and TLS_KEY must be set together")
+	} else if cfg.TLSCert != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey); err != nil {
+			addf("TLS_CERT: %v", err)
+		}
+		if cfg.ACMEDomains != "" {
+			addf("ACME_DOMAINS cannot be used together with TLS_CERT")
+		}
+	}
+
+	if _, err := ParseRateLimit(cfg.UserRateLimit, defaultUserRateLimit); err != nil {
+		addf("USER_RATE_LIMIT: %v", err)
+	}
+	if _, err := ParseRateLimit(cfg.IPRateLimit, defaultIPRateLimit); err != nil {
+		addf("IP_RATE_LIMIT: %v", err)
+	}
+	if _, err := ParseTrustedProxies(cfg.TrustedProxies); err != nil {
+		addf("TRUSTED_PROXIES: %v", err)
+	}
+	if n, err := strconv.Atoi(cfg.DailyLogLimit); cfg.DailyLogLimit != "" && (err != nil || n < 0) {
+		addf("DAILY_LOG_LIMIT: %q is not a number of logs", cfg.DailyLogLimit)
+	}
+
+	if _, err := cfg.contextFields(); err != nil {
+		addf("CONTEXT_FIELDS: %v", err)
+	}
+
+	if u, err := url.Parse(cfg.OTLPEndpoint); cfg.OTLPEndpoint != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+		addf("OTLP_ENDPOINT: %q is not an http(s) URL", cfg.OTLPEndpoint)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateTable checks that the database URL has a known scheme and the
+// shape that its backend expects.
+func validateTable(table string) error {
+	if table == "" {
+		return fmt.Errorf("database URL is required")
+	}
+	u, err := url.Parse(table)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "bigquery", "bq":
+		parts := strings.Split(u.Host, ".")
+		if len(parts) != 3 || ContainsEmpty(parts...) {
+			return fmt.Errorf("BigQuery table must be in the form %s://project.dataset.table", u.Scheme)
+		}
+	case "postgres":
+		if _, err := pg.ParseURL(table); err != nil {
+			return err
+		}
+	case "memory":
+	default:
+		return fmt.Errorf("unknown database scheme: %q", u.Scheme)
+	}
+	return nil
+}
+
+// Probe checks that the configured backend can be reached.
+func (cfg *Configuration) Probe() error {
+	db, err := NewDBInserter(cfg.Table)
+	if err != nil {
+		return err
+	}
+	if c, ok := db.(io.Closer); ok {
+		defer c.Close()
+	}
+	if p, ok := db.(DBPinger); ok {
+		return p.PingDB()
+	}
+	return nil
+}